@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
@@ -14,7 +15,25 @@ func GenAuthToken() (string, error) {
 	return hex.EncodeToString(authTokenBytes), nil
 }
 
-func RequireAuth(authToken string, h http.Handler) http.Handler {
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx that carries the given identity.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// IdentityFromRequest returns the identity of the token the request was
+// authenticated with. ok is false if the request didn't go through
+// RequireAuth.
+func IdentityFromRequest(r *http.Request) (identity Identity, ok bool) {
+	identity, ok = r.Context().Value(contextKey{}).(Identity)
+	return
+}
+
+// RequireAuth rejects requests that don't have a valid auth token, and
+// attaches the identity of the token to the request context so handlers can
+// enforce its scope.
+func RequireAuth(tokens *Tokens, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet || r.Method == http.MethodOptions || r.Method == http.MethodHead {
 			h.ServeHTTP(w, r)
@@ -27,11 +46,12 @@ func RequireAuth(authToken string, h http.Handler) http.Handler {
 			return
 		}
 
-		if token[0] != authToken {
+		identity, ok := tokens.Lookup(token[0])
+		if !ok {
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
 	})
 }
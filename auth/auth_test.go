@@ -33,7 +33,7 @@ func TestRequireAuthAllowed(t *testing.T) {
 
 	recorder := httptest.NewRecorder()
 
-	handler := RequireAuth(token, testHandler)
+	handler := RequireAuth(NewTokens(token, ""), testHandler)
 	handler.ServeHTTP(recorder, req)
 
 	res := recorder.Result()
@@ -59,7 +59,7 @@ func TestRequireAuthForbidden(t *testing.T) {
 
 	recorder := httptest.NewRecorder()
 
-	handler := RequireAuth(token, testHandler)
+	handler := RequireAuth(NewTokens(token, ""), testHandler)
 	handler.ServeHTTP(recorder, req)
 
 	res := recorder.Result()
@@ -68,3 +68,83 @@ func TestRequireAuthForbidden(t *testing.T) {
 		t.Fatalf(`res.StatusCode expected "%v", got "%v"`, http.StatusForbidden, res.StatusCode)
 	}
 }
+
+func TestRequireAuthPluginToken(t *testing.T) {
+	coreToken, err := GenAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := NewTokens(coreToken, "")
+	pluginToken, err := tokens.IssuePluginToken("example", Scope{Exec: []string{"git"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var identity Identity
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromRequest(r)
+	})
+
+	req, err := http.NewRequest("POST", "/rpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Cs-Auth", pluginToken)
+
+	recorder := httptest.NewRecorder()
+	RequireAuth(tokens, testHandler).ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf(`res.StatusCode expected "%v", got "%v"`, http.StatusOK, recorder.Result().StatusCode)
+	}
+	if identity.PluginId != "example" {
+		t.Fatalf(`identity.PluginId expected "%v", got "%v"`, "example", identity.PluginId)
+	}
+
+	// Revoked tokens should be rejected
+	tokens.RevokePluginToken("example")
+
+	recorder = httptest.NewRecorder()
+	RequireAuth(tokens, testHandler).ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf(`res.StatusCode expected "%v", got "%v"`, http.StatusForbidden, recorder.Result().StatusCode)
+	}
+}
+
+func TestRequireAuthInjectorToken(t *testing.T) {
+	coreToken, err := GenAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	injectorToken, err := GenAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var coreErr, injectorErr error
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coreErr = RequireCore(r)
+		injectorErr = RequireCoreOrInjector(r)
+	})
+
+	req, err := http.NewRequest("POST", "/rpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Cs-Auth", injectorToken)
+
+	recorder := httptest.NewRecorder()
+	RequireAuth(NewTokens(coreToken, injectorToken), testHandler).ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf(`res.StatusCode expected "%v", got "%v"`, http.StatusOK, recorder.Result().StatusCode)
+	}
+	if coreErr == nil {
+		t.Fatal("Expected the injector token to be refused by RequireCore")
+	}
+	if injectorErr != nil {
+		t.Fatalf("Expected the injector token to be allowed to inject, got: %v", injectorErr)
+	}
+}
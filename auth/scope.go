@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/pathutil"
+)
+
+// Scope lists what a plugin is allowed to access. Plugins declare it in the
+// permissions section of their plugin.toml.
+type Scope struct {
+	// Executables the plugin can run
+	Exec []string `json:"exec" toml:"exec"`
+	// Filesystem roots the plugin can access, these can use the same `~` and
+	// XDG variables as FSService paths
+	FS []string `json:"fs" toml:"fs"`
+	// Hosts the plugin can make network requests to, "*.example.com" matches
+	// any subdomain of example.com
+	Network []string `json:"network" toml:"network"`
+	// The plugin can read and write the store namespace shared between plugins
+	SharedStore bool `json:"sharedStore" toml:"shared-store"`
+	// The plugin runs a native backend process. This is set automatically for
+	// plugins that declare a backend.
+	Backend bool `json:"backend" toml:"backend"`
+}
+
+// IsEmpty returns if the scope doesn't grant any permissions.
+func (s Scope) IsEmpty() bool {
+	return len(s.Exec) == 0 && len(s.FS) == 0 && len(s.Network) == 0 && !s.SharedStore && !s.Backend
+}
+
+// Covers returns if every permission in other is also in s.
+func (s Scope) Covers(other Scope) bool {
+	return containsAll(s.Exec, other.Exec) &&
+		containsAll(s.FS, other.FS) &&
+		containsAll(s.Network, other.Network) &&
+		(s.SharedStore || !other.SharedStore) &&
+		(s.Backend || !other.Backend)
+}
+
+func containsAll(list []string, values []string) bool {
+	for _, value := range values {
+		found := false
+		for _, item := range list {
+			if item == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Identity is who an auth token was issued to.
+type Identity struct {
+	// PluginId is empty for the core Crankshaft scripts, which aren't limited
+	// by a scope
+	PluginId string
+	Scope    Scope
+	// Injector is set for the token the patched Steam scripts use to ask for
+	// Crankshaft to be injected. Those scripts run alongside plugins, so the
+	// token can't be used for anything else.
+	Injector bool
+}
+
+// IsCore returns if the identity belongs to the core Crankshaft scripts.
+func (i Identity) IsCore() bool {
+	return i.PluginId == "" && !i.Injector
+}
+
+// name describes the identity in errors.
+func (i Identity) name() string {
+	if i.Injector {
+		return "Injector"
+	}
+	return fmt.Sprintf(`Plugin "%s"`, i.PluginId)
+}
+
+// CanExec returns if the identity is allowed to run the given command.
+func (i Identity) CanExec(command string) bool {
+	if i.IsCore() {
+		return true
+	}
+
+	for _, allowed := range i.Scope.Exec {
+		if command == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessPath returns if the given path is inside one of the identity's
+// filesystem roots, after following symlinks. The path should already have `~`
+// and XDG variables substituted.
+func (i Identity) CanAccessPath(path string) bool {
+	if i.IsCore() {
+		return true
+	}
+
+	resolvedPath, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+
+	for _, root := range i.Scope.FS {
+		resolvedRoot, err := resolvePath(pathutil.SubstituteHomeAndXdg(root))
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(resolvedRoot, resolvedPath)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute path with symlinks followed, the way the
+// filesystem would resolve it. Once a component doesn't exist, the rest of the
+// path is joined as is, so paths for new files resolve through their closest
+// existing parent. Symlinks that can't be followed are an error, as writing
+// through a dangling symlink creates its target.
+func resolvePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		path = wd + string(filepath.Separator) + path
+	}
+
+	volume := filepath.VolumeName(path)
+	resolved := volume + string(filepath.Separator)
+	parts := strings.Split(filepath.ToSlash(path[len(volume):]), "/")
+	for n, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// resolved has no symlinks left, so this is the real parent
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		_, err := os.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.Join(append([]string{next}, parts[n+1:]...)...), nil
+		} else if err != nil {
+			return "", err
+		}
+
+		resolved, err = filepath.EvalSymlinks(next)
+		if err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+// CanAccessUrl returns if the identity is allowed to make requests to the
+// URL's host.
+func (i Identity) CanAccessUrl(rawUrl string) bool {
+	if i.IsCore() {
+		return true
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, allowed := range i.Scope.Network {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessSharedStore returns if the identity is allowed to use the store
+// namespace shared between plugins.
+func (i Identity) CanAccessSharedStore() bool {
+	return i.IsCore() || i.Scope.SharedStore
+}
+
+// PermissionError is returned when a request tries to access something outside
+// of its identity's scope.
+type PermissionError struct {
+	PluginId   string
+	Permission string
+	Value      string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf(`Plugin "%s" doesn't have %s permission for "%s"`, e.PluginId, e.Permission, e.Value)
+}
+
+var errNoIdentity = errors.New("Request is missing an auth identity")
+
+// CheckExec returns an error if the request isn't allowed to run the command.
+func CheckExec(r *http.Request, command string) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.CanExec(command) {
+		return &PermissionError{identity.PluginId, "exec", command}
+	}
+	return nil
+}
+
+// CheckPath returns an error if the request isn't allowed to access the path.
+func CheckPath(r *http.Request, path string) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.CanAccessPath(path) {
+		return &PermissionError{identity.PluginId, "fs", path}
+	}
+	return nil
+}
+
+// CheckUrl returns an error if the request isn't allowed to access the URL.
+func CheckUrl(r *http.Request, rawUrl string) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.CanAccessUrl(rawUrl) {
+		return &PermissionError{identity.PluginId, "network", rawUrl}
+	}
+	return nil
+}
+
+// CheckSharedStore returns an error if the request isn't allowed to use the
+// shared store namespace.
+func CheckSharedStore(r *http.Request) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.CanAccessSharedStore() {
+		return &PermissionError{identity.PluginId, "store", "shared namespace"}
+	}
+	return nil
+}
+
+// RequireCore returns an error if the request wasn't made with the core
+// Crankshaft token. This is used for APIs that manage Crankshaft itself, like
+// enabling plugins and approving their permissions.
+func RequireCore(r *http.Request) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.IsCore() {
+		return fmt.Errorf(`%s isn't allowed to manage Crankshaft`, identity.name())
+	}
+	return nil
+}
+
+// RequireCoreOrInjector returns an error unless the request was made with the
+// core Crankshaft token or the injector token. This is used for the APIs the
+// patched Steam scripts call to inject Crankshaft.
+func RequireCoreOrInjector(r *http.Request) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.IsCore() && !identity.Injector {
+		return fmt.Errorf(`%s isn't allowed to inject Crankshaft`, identity.name())
+	}
+	return nil
+}
+
+// RequireCoreOrPlugin returns an error unless the request was made with the
+// core Crankshaft token or by the given plugin. This is used for APIs that act
+// on a plugin's own data, like its settings.
+func RequireCoreOrPlugin(r *http.Request, pluginId string) error {
+	identity, ok := IdentityFromRequest(r)
+	if !ok {
+		return errNoIdentity
+	}
+	if !identity.IsCore() && identity.PluginId != pluginId {
+		return fmt.Errorf(`%s isn't allowed to access plugin "%s"`, identity.name(), pluginId)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityCanExec(t *testing.T) {
+	identity := Identity{PluginId: "example", Scope: Scope{Exec: []string{"git"}}}
+
+	if !identity.CanExec("git") {
+		t.Fatal(`CanExec("git") expected true`)
+	}
+	if identity.CanExec("/tmp/git") {
+		t.Fatal(`CanExec("/tmp/git") expected false`)
+	}
+	if !(Identity{}).CanExec("anything") {
+		t.Fatal("Core identity should be able to run anything")
+	}
+}
+
+func TestIdentityCanAccessPath(t *testing.T) {
+	root := t.TempDir()
+	identity := Identity{PluginId: "example", Scope: Scope{FS: []string{root}}}
+
+	tests := map[string]bool{
+		root:                                   true,
+		filepath.Join(root, "foo", "bar"):      true,
+		filepath.Join(root, "..", "other"):     false,
+		filepath.Join(root, "foo", "..", ".."): false,
+		root + "-sibling":                      false,
+	}
+
+	for path, expected := range tests {
+		if res := identity.CanAccessPath(path); res != expected {
+			t.Fatalf(`CanAccessPath(%v) expected "%v", got "%v"`, path, expected, res)
+		}
+	}
+}
+
+func TestIdentityCanAccessPathSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	for _, path := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "out"):      outside,
+		filepath.Join(root, "in"):       filepath.Join(root, "sub"),
+		filepath.Join(root, "dangling"): filepath.Join(outside, "new"),
+		filepath.Join(dir, "rootlink"):  root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("Can't create symlinks: %v", err)
+		}
+	}
+
+	identity := Identity{PluginId: "example", Scope: Scope{FS: []string{filepath.Join(dir, "rootlink")}}}
+	sep := string(filepath.Separator)
+
+	tests := map[string]bool{
+		filepath.Join(root, "sub", "file"):        true,
+		filepath.Join(root, "in", "new", "file"):  true,
+		filepath.Join(root, "out"):                false,
+		filepath.Join(root, "out", "new", "file"): false,
+		// Not cleaned, ".." after a symlink is the symlink target's parent
+		root + sep + "out" + sep + ".." + sep + "sub": false,
+		filepath.Join(root, "dangling"):               false,
+		filepath.Join(dir, "rootlink", "sub"):         true,
+	}
+
+	for path, expected := range tests {
+		if res := identity.CanAccessPath(path); res != expected {
+			t.Fatalf(`CanAccessPath(%v) expected "%v", got "%v"`, path, expected, res)
+		}
+	}
+}
+
+func TestIdentityCanAccessUrl(t *testing.T) {
+	identity := Identity{PluginId: "example", Scope: Scope{Network: []string{"example.com", "*.crankshaft.space"}}}
+
+	tests := map[string]bool{
+		"https://example.com/foo":         true,
+		"https://api.example.com/foo":     false,
+		"https://cdn.crankshaft.space/a":  true,
+		"https://crankshaft.space.evil/a": false,
+		"https://evilcrankshaft.space/a":  false,
+	}
+
+	for url, expected := range tests {
+		if res := identity.CanAccessUrl(url); res != expected {
+			t.Fatalf(`CanAccessUrl(%v) expected "%v", got "%v"`, url, expected, res)
+		}
+	}
+}
+
+func TestScopeCovers(t *testing.T) {
+	approved := Scope{Exec: []string{"git", "ls"}, Network: []string{"example.com"}}
+
+	if !approved.Covers(Scope{Exec: []string{"git"}}) {
+		t.Fatal("Expected scope to cover subset")
+	}
+	if approved.Covers(Scope{FS: []string{"~"}}) {
+		t.Fatal("Expected scope not to cover new permission")
+	}
+	if approved.Covers(Scope{SharedStore: true}) {
+		t.Fatal("Expected scope not to cover shared store permission")
+	}
+}
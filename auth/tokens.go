@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"sync"
+)
+
+// Tokens keeps track of the core Crankshaft auth token, the injector token
+// used by the patched Steam scripts, and the scoped tokens issued to plugins.
+// It's safe for concurrent use.
+type Tokens struct {
+	mu            sync.RWMutex
+	coreToken     string
+	injectorToken string
+	// Plugin identities by token
+	identities map[string]Identity
+	// Plugin tokens by plugin ID
+	pluginTokens map[string]string
+}
+
+// NewTokens creates a token store where coreToken has unrestricted access and
+// injectorToken can only ask for Crankshaft to be injected. injectorToken can
+// be empty if there's no injector.
+func NewTokens(coreToken, injectorToken string) *Tokens {
+	return &Tokens{
+		coreToken:     coreToken,
+		injectorToken: injectorToken,
+		identities:    make(map[string]Identity),
+		pluginTokens:  make(map[string]string),
+	}
+}
+
+// CoreToken returns the token used by the core Crankshaft scripts.
+func (t *Tokens) CoreToken() string {
+	return t.coreToken
+}
+
+// Lookup returns the identity the given token was issued to.
+func (t *Tokens) Lookup(token string) (Identity, bool) {
+	if token == t.coreToken {
+		return Identity{}, true
+	}
+	if t.injectorToken != "" && token == t.injectorToken {
+		return Identity{Injector: true}, true
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	identity, ok := t.identities[token]
+	return identity, ok
+}
+
+// IssuePluginToken returns a token for the plugin that's limited to the given
+// scope. If the plugin already has a token, the same token is returned with
+// its scope updated, so the plugin can be injected into several targets with
+// one token.
+func (t *Tokens) IssuePluginToken(pluginId string, scope Scope) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token, ok := t.pluginTokens[pluginId]
+	if !ok {
+		var err error
+		token, err = GenAuthToken()
+		if err != nil {
+			return "", err
+		}
+		t.pluginTokens[pluginId] = token
+	}
+
+	t.identities[token] = Identity{
+		PluginId: pluginId,
+		Scope:    scope,
+	}
+
+	return token, nil
+}
+
+// PluginToken returns the plugin's token, if it has been issued one.
+func (t *Tokens) PluginToken(pluginId string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	token, ok := t.pluginTokens[pluginId]
+	return token, ok
+}
+
+// RevokePluginToken invalidates the plugin's token, if it has one.
+func (t *Tokens) RevokePluginToken(pluginId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if token, ok := t.pluginTokens[pluginId]; ok {
+		delete(t.identities, token)
+		delete(t.pluginTokens, pluginId)
+	}
+}
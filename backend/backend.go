@@ -0,0 +1,294 @@
+// Package backend runs plugins' native backend processes. Backends are
+// restarted when they crash, their output is written to log files, and
+// plugins' frontends can call them with JSON-RPC over a Unix socket.
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/executil"
+)
+
+// SocketEnv is the environment variable that tells a backend the path of the
+// Unix socket it should listen on.
+const SocketEnv = "CRANKSHAFT_BACKEND_SOCKET"
+
+const (
+	// Delay before restarting a backend that crashed, doubled after each crash
+	initialRestartDelay = time.Second
+	maxRestartDelay     = time.Minute
+	// A backend that runs for this long is considered stable, and the restart
+	// delay is reset the next time it crashes
+	stableRunTime = time.Minute
+	// How long to wait for a backend to exit after asking it to stop before
+	// killing it
+	stopTimeout = 5 * time.Second
+	// Log files larger than this are rotated when the backend starts
+	maxLogSize = 1024 * 1024
+)
+
+// Backend is a backend process declared by a plugin.
+type Backend struct {
+	// Directory the backend runs in
+	Dir string
+	// Executable to run. If it contains a path separator it's relative to Dir,
+	// otherwise it's looked up in PATH.
+	Command string
+	Args    []string
+}
+
+func (b Backend) command() string {
+	if strings.ContainsAny(b.Command, `/\`) && !filepath.IsAbs(b.Command) {
+		return filepath.Join(b.Dir, b.Command)
+	}
+	return b.Command
+}
+
+// Supervisor starts and stops plugins' backends. It's safe for concurrent use.
+type Supervisor struct {
+	logsDir    string
+	socketsDir string
+
+	initialRestartDelay time.Duration
+	maxRestartDelay     time.Duration
+	stableRunTime       time.Duration
+
+	mu        sync.Mutex
+	processes map[string]*process
+}
+
+// NewSupervisor creates a supervisor that writes backend logs to logsDir and
+// creates backend sockets in socketsDir.
+func NewSupervisor(logsDir, socketsDir string) *Supervisor {
+	return &Supervisor{
+		logsDir:             logsDir,
+		socketsDir:          socketsDir,
+		initialRestartDelay: initialRestartDelay,
+		maxRestartDelay:     maxRestartDelay,
+		stableRunTime:       stableRunTime,
+		processes:           make(map[string]*process),
+	}
+}
+
+type process struct {
+	pluginId   string
+	backend    Backend
+	socketPath string
+	logPath    string
+	// Closed to stop the backend
+	stop chan struct{}
+	// Closed once the backend has stopped and won't be restarted
+	done chan struct{}
+}
+
+// Start starts the plugin's backend, and keeps restarting it if it exits
+// until Stop is called. If the backend is already running it's restarted.
+func (s *Supervisor) Start(pluginId string, backend Backend) error {
+	s.Stop(pluginId)
+
+	for _, dir := range []string{s.logsDir, s.socketsDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf(`Error creating directory "%s": %v`, dir, err)
+		}
+	}
+
+	p := &process{
+		pluginId:   pluginId,
+		backend:    backend,
+		socketPath: filepath.Join(s.socketsDir, pluginId+".sock"),
+		logPath:    filepath.Join(s.logsDir, pluginId+".log"),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if _, ok := s.processes[pluginId]; ok {
+		// Started by another caller in the meantime
+		s.mu.Unlock()
+		return nil
+	}
+	s.processes[pluginId] = p
+	s.mu.Unlock()
+
+	go s.supervise(p)
+
+	return nil
+}
+
+// Stop stops the plugin's backend if it's running, and waits for it to exit.
+func (s *Supervisor) Stop(pluginId string) {
+	s.mu.Lock()
+	p, ok := s.processes[pluginId]
+	delete(s.processes, pluginId)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	close(p.stop)
+	<-p.done
+}
+
+// StopAll stops every running backend. This should be called before
+// Crankshaft exits so backends aren't left running.
+func (s *Supervisor) StopAll() {
+	s.mu.Lock()
+	pluginIds := make([]string, 0, len(s.processes))
+	for pluginId := range s.processes {
+		pluginIds = append(pluginIds, pluginId)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pluginId := range pluginIds {
+		wg.Add(1)
+		go func(pluginId string) {
+			defer wg.Done()
+			s.Stop(pluginId)
+		}(pluginId)
+	}
+	wg.Wait()
+}
+
+// IsRunning returns if the plugin's backend is being supervised.
+func (s *Supervisor) IsRunning(pluginId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.processes[pluginId]
+	return ok
+}
+
+func (s *Supervisor) socketPath(pluginId string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.processes[pluginId]
+	if !ok {
+		return "", false
+	}
+	return p.socketPath, true
+}
+
+// supervise runs the backend until it's stopped, restarting it with
+// exponential backoff whenever it exits.
+func (s *Supervisor) supervise(p *process) {
+	defer close(p.done)
+	defer os.Remove(p.socketPath)
+
+	delay := s.initialRestartDelay
+	for {
+		startedAt := time.Now()
+		stopped, err := p.run()
+		if stopped {
+			return
+		}
+
+		if time.Since(startedAt) >= s.stableRunTime {
+			delay = s.initialRestartDelay
+		}
+		log.Printf("Backend of plugin \"%s\" exited, restarting in %v: %v\n", p.pluginId, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			return
+		}
+
+		delay *= 2
+		if delay > s.maxRestartDelay {
+			delay = s.maxRestartDelay
+		}
+	}
+}
+
+// run runs the backend once. It returns when the backend exits, or when it's
+// stopped, in which case stopped is true.
+func (p *process) run() (stopped bool, err error) {
+	logFile, err := openLog(p.logPath)
+	if err != nil {
+		return false, err
+	}
+	defer logFile.Close()
+
+	// Remove the socket left behind if the previous run crashed
+	os.Remove(p.socketPath)
+
+	cmd := executil.CommandIn(p.backend.Dir, []string{SocketEnv + "=" + p.socketPath}, p.backend.command(), p.backend.Args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	setSysProcAttr(cmd)
+
+	fmt.Fprintf(logFile, "--- Starting backend at %s ---\n", time.Now().Format(time.RFC3339))
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(logFile, "--- Error starting backend: %v ---\n", err)
+		return false, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		fmt.Fprintf(logFile, "--- Backend exited: %v ---\n", exitDescription(err))
+		if err == nil {
+			err = errors.New("exited without an error")
+		}
+		return false, err
+
+	case <-p.stop:
+		stopProcess(cmd, exited)
+		fmt.Fprintf(logFile, "--- Backend stopped ---\n")
+		return true, nil
+	}
+}
+
+// stopProcess asks the process to exit, and kills it if it doesn't exit in
+// time.
+func stopProcess(cmd *exec.Cmd, exited <-chan error) {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		// Interrupting isn't supported on Windows
+		cmd.Process.Kill()
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+func exitDescription(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// openLog opens the log file for appending, first rotating it if it's too
+// large. Only one previous log file is kept.
+func openLog(logPath string) (*os.File, error) {
+	if info, err := os.Stat(logPath); err == nil && info.Size() > maxLogSize {
+		if err := os.Rename(logPath, logPath+".old"); err != nil {
+			log.Printf("Error rotating backend log \"%s\": %v\n", logPath, err)
+		}
+	}
+
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf(`Error opening backend log "%s": %v`, logPath, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary runs itself as a backend when this is set
+const testBackendEnv = "CRANKSHAFT_TEST_BACKEND"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testBackendEnv) != "" {
+		runTestBackend()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestBackend is a backend that echoes params back, returns an error for
+// the "fail" method, and crashes for the "crash" method.
+func runTestBackend() {
+	fmt.Println("hello from backend")
+
+	listener, err := net.Listen("unix", os.Getenv(SocketEnv))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+
+		var req request
+		if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+			conn.Close()
+			continue
+		}
+
+		res := response{Id: req.Id}
+		switch req.Method {
+		case "crash":
+			os.Exit(2)
+		case "fail":
+			res.Error = &CallError{Code: 1, Message: "failed"}
+		default:
+			res.Result = req.Params
+		}
+		json.NewEncoder(conn).Encode(res)
+		conn.Close()
+	}
+}
+
+func newTestSupervisor(t *testing.T) *Supervisor {
+	t.Setenv(testBackendEnv, "1")
+
+	// Socket paths are limited to around 100 characters, so the sockets
+	// directory can't be nested too deeply
+	socketsDir, err := os.MkdirTemp("", "cs-sockets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketsDir) })
+
+	s := NewSupervisor(t.TempDir(), socketsDir)
+	s.initialRestartDelay = 10 * time.Millisecond
+	t.Cleanup(s.StopAll)
+	return s
+}
+
+func testBackend(t *testing.T) Backend {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Backend{Dir: t.TempDir(), Command: executable}
+}
+
+func TestCall(t *testing.T) {
+	s := newTestSupervisor(t)
+	if err := s.Start("test-plugin", testBackend(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Call("test-plugin", "echo", json.RawMessage(`{"value":1}`))
+	if err != nil {
+		t.Fatalf("Call returned error: %v", err)
+	}
+	if string(result) != `{"value":1}` {
+		t.Fatalf("Expected params to be echoed, got %s", result)
+	}
+
+	var callErr *CallError
+	if _, err := s.Call("test-plugin", "fail", nil); !errors.As(err, &callErr) || callErr.Message != "failed" {
+		t.Fatalf("Expected CallError, got %v", err)
+	}
+
+	s.Stop("test-plugin")
+	if s.IsRunning("test-plugin") {
+		t.Fatal("Expected backend to be stopped")
+	}
+	if _, err := s.Call("test-plugin", "echo", nil); err == nil {
+		t.Fatal("Expected calling a stopped backend to fail")
+	}
+}
+
+func TestRestartsAfterCrash(t *testing.T) {
+	s := newTestSupervisor(t)
+	if err := s.Start("test-plugin", testBackend(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backend exits without responding
+	if _, err := s.Call("test-plugin", "crash", nil); err == nil {
+		t.Fatal("Expected call that crashes the backend to fail")
+	}
+
+	// Calls can reach the crashed backend's socket before it has fully exited,
+	// so keep trying until the restarted backend responds
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.Call("test-plugin", "echo", json.RawMessage(`1`))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected backend to be restarted, got error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Stop("test-plugin")
+
+	logs, err := os.ReadFile(filepath.Join(s.logsDir, "test-plugin.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(logs), "hello from backend"); count != 2 {
+		t.Fatalf("Expected output of both runs in log, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How long to keep trying to connect to a backend that was just started
+	// and isn't listening yet
+	dialTimeout = 5 * time.Second
+	// Maximum amount of time a call can take
+	callTimeout = 30 * time.Second
+)
+
+// Calls are sent as JSON-RPC 2.0 requests, one per connection, with the
+// request and response each on a single line.
+type request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	Id     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *CallError      `json:"error"`
+}
+
+// CallError is an error returned by a backend.
+type CallError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("Backend returned error %d: %s", e.Code, e.Message)
+}
+
+var lastRequestId uint64
+
+// Call calls method on the plugin's backend with the given JSON params, and
+// returns the JSON result. If the backend returns an error, it's returned as a
+// CallError.
+func (s *Supervisor) Call(pluginId, method string, params json.RawMessage) (json.RawMessage, error) {
+	socketPath, ok := s.socketPath(pluginId)
+	if !ok {
+		return nil, fmt.Errorf(`Plugin "%s" doesn't have a running backend`, pluginId)
+	}
+
+	conn, err := dialBackend(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf(`Error connecting to backend of plugin "%s": %v`, pluginId, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	req := request{
+		JsonRpc: "2.0",
+		Id:      atomic.AddUint64(&lastRequestId, 1),
+		Method:  method,
+		Params:  params,
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf(`Error calling backend of plugin "%s": %v`, pluginId, err)
+	}
+
+	var res response
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return nil, fmt.Errorf(`Error reading response from backend of plugin "%s": %v`, pluginId, err)
+	}
+	if res.Id != req.Id {
+		return nil, fmt.Errorf(`Backend of plugin "%s" responded to the wrong request`, pluginId)
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return res.Result, nil
+}
+
+// dialBackend connects to the backend's socket, waiting for the backend to
+// start listening if it was just started.
+func dialBackend(socketPath string) (net.Conn, error) {
+	deadline := time.Now().Add(dialTimeout)
+	for {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) {
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
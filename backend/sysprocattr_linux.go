@@ -0,0 +1,12 @@
+package backend
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setSysProcAttr makes the backend exit if Crankshaft dies without stopping
+// it, so it isn't left orphaned.
+func setSysProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
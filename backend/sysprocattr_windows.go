@@ -0,0 +1,5 @@
+package backend
+
+import "os/exec"
+
+func setSysProcAttr(cmd *exec.Cmd) {}
@@ -0,0 +1,380 @@
+// Package backup implements backing up Crankshaft's plugins, config and
+// plugin store into a single archive, and restoring them on the same or
+// another device.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/build"
+	"git.sr.ht/~avery/crankshaft/store"
+	"git.sr.ht/~avery/crankshaft/untar"
+)
+
+// Version of the backup format, restoring a backup with a newer version fails
+const formatVersion = 1
+
+// Names of the files and directories in a backup
+const (
+	manifestName = "crankshaft-backup.json"
+	configName   = "config.toml"
+	storeName    = "store.db"
+	pluginsName  = "plugins"
+)
+
+// Manifest describes a backup, it's the first file in the archive.
+type Manifest struct {
+	Version           int       `json:"version"`
+	CrankshaftVersion string    `json:"crankshaftVersion"`
+	Created           time.Time `json:"created"`
+}
+
+// Create backs up the plugins directory, the Crankshaft config and the plugin
+// store in dataDir into a .tar.gz archive at archivePath. Files that don't
+// exist yet are left out. The store can't be backed up while Crankshaft is
+// running, store.ErrInUse is returned if it is.
+//
+// The archive is written next to archivePath and renamed into place once it's
+// complete, so a failed backup never leaves a partial archive behind.
+func Create(archivePath, dataDir, pluginsDir string) error {
+	stagingDir, err := os.MkdirTemp(filepath.Dir(archivePath), ".backup-")
+	if err != nil {
+		return fmt.Errorf("Error creating staging directory: %v", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	// The store is copied first so it's consistent, it can't be read straight
+	// into the archive without knowing its size
+	storePath := filepath.Join(dataDir, storeName)
+	storeSnapshot := filepath.Join(stagingDir, storeName)
+	if _, err := os.Stat(storePath); err == nil {
+		if err := store.Snapshot(storePath, storeSnapshot); err != nil {
+			return err
+		}
+	} else {
+		storeSnapshot = ""
+	}
+
+	stagedArchive := filepath.Join(stagingDir, "backup.tar.gz")
+	if err := writeArchive(stagedArchive, filepath.Join(dataDir, configName), storeSnapshot, pluginsDir); err != nil {
+		return fmt.Errorf("Error writing backup: %v", err)
+	}
+
+	if err := os.Rename(stagedArchive, archivePath); err != nil {
+		return fmt.Errorf("Error moving backup into place: %v", err)
+	}
+
+	return nil
+}
+
+func writeArchive(archivePath, configPath, storePath, pluginsDir string) error {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifest, err := json.MarshalIndent(Manifest{
+		Version:           formatVersion,
+		CrankshaftVersion: build.VERSION,
+		Created:           time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name:     manifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(manifest); err != nil {
+		return err
+	}
+
+	if err := addFile(tarWriter, configPath, configName); err != nil {
+		return err
+	}
+	if storePath != "" {
+		if err := addFile(tarWriter, storePath, storeName); err != nil {
+			return err
+		}
+	}
+	if err := addDir(tarWriter, pluginsDir, pluginsName); err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// addFile adds the file at filePath to the archive as name, if it exists.
+func addFile(tarWriter *tar.Writer, filePath, name string) error {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return addEntry(tarWriter, filePath, name, info)
+}
+
+// addDir adds the plugins directory and the plugins in it to the archive under
+// name, if it exists. Entries starting with a dot are left out, they're staging
+// directories from installs in progress.
+//
+// Plugins being developed are often symlinked into the plugins directory. The
+// link wouldn't work on another device, so the plugin it points to is backed up
+// instead.
+func addDir(tarWriter *tar.Writer, dir, name string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := addEntry(tarWriter, dir, name, info); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		pluginDir := filepath.Join(dir, entry.Name())
+		if entry.Type()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(pluginDir)
+			if err != nil {
+				return fmt.Errorf(`Can't back up symlinked plugin "%s": %v`, pluginDir, err)
+			}
+			pluginDir = target
+		}
+
+		if err := addTree(tarWriter, pluginDir, name+"/"+entry.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTree adds the directory and everything in it to the archive under name.
+// Symlinks are kept as they are, but they have to be relative and stay inside
+// the directory, otherwise they won't work once the backup is restored.
+func addTree(tarWriter *tar.Writer, dir, name string) error {
+	return filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			if !linksInside(dir, filePath, link) {
+				return fmt.Errorf(`Can't back up "%s", it links to "%s" outside of "%s"`, filePath, link, dir)
+			}
+		}
+
+		return addEntry(tarWriter, filePath, filepath.ToSlash(filepath.Join(name, rel)), info)
+	})
+}
+
+// linksInside returns if the symlink at linkPath with the given target points
+// inside dir.
+func linksInside(dir, linkPath, link string) bool {
+	if filepath.IsAbs(link) {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, filepath.Join(filepath.Dir(linkPath), link))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// addEntry adds a file, directory or symlink to the archive as name.
+func addEntry(tarWriter *tar.Writer, filePath, name string, info fs.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf(`Can't back up "%s": %v`, filePath, err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tarWriter, file)
+	return err
+}
+
+// Restore replaces the plugins directory, the Crankshaft config and the plugin
+// store in dataDir with the ones in the backup at archivePath. Anything the
+// backup doesn't include is removed, so the result matches the backed up
+// setup. Crankshaft can't be running, store.ErrInUse is returned if it is.
+//
+// The backup is extracted into a staging directory in dataDir first. Then the
+// current files are moved aside and the backed up ones moved into place, and
+// if any move fails the ones already made are undone. The plugins directory
+// has to be on the same filesystem as dataDir.
+func Restore(archivePath, dataDir, pluginsDir string) error {
+	if err := store.EnsureNotInUse(filepath.Join(dataDir, storeName)); err != nil {
+		return err
+	}
+
+	stagingDir, err := os.MkdirTemp(dataDir, ".restore-")
+	if err != nil {
+		return fmt.Errorf("Error creating staging directory: %v", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	extractDir := filepath.Join(stagingDir, "extracted")
+	if err := os.Mkdir(extractDir, 0755); err != nil {
+		return err
+	}
+	if err := untar.Untar(archivePath, extractDir); err != nil {
+		return fmt.Errorf("Error extracting backup: %v", err)
+	}
+
+	manifest, err := readManifest(extractDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restoring backup from Crankshaft %s created %s\n", manifest.CrankshaftVersion, manifest.Created.Format(time.RFC3339))
+
+	previousDir := filepath.Join(stagingDir, "previous")
+	if err := os.Mkdir(previousDir, 0755); err != nil {
+		return err
+	}
+
+	moves := []struct{ staged, dest string }{
+		{filepath.Join(extractDir, configName), filepath.Join(dataDir, configName)},
+		{filepath.Join(extractDir, storeName), filepath.Join(dataDir, storeName)},
+		{filepath.Join(extractDir, pluginsName), pluginsDir},
+	}
+
+	// Undo the moves made so far in reverse, moving the previous files back
+	var undo []func() error
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				log.Printf("Error rolling back restore: %v\n", err)
+			}
+		}
+	}
+
+	for i, move := range moves {
+		previous := filepath.Join(previousDir, fmt.Sprint(i))
+		dest := move.dest
+
+		if _, err := os.Lstat(dest); err == nil {
+			if err := os.Rename(dest, previous); err != nil {
+				rollback()
+				return fmt.Errorf(`Error moving "%s" aside: %v`, dest, err)
+			}
+			undo = append(undo, func() error {
+				return os.Rename(previous, dest)
+			})
+		}
+
+		if _, err := os.Lstat(move.staged); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := os.Rename(move.staged, dest); err != nil {
+			rollback()
+			return fmt.Errorf(`Error restoring "%s": %v`, dest, err)
+		}
+		undo = append(undo, func() error {
+			return os.RemoveAll(dest)
+		})
+	}
+
+	// Crankshaft expects the plugins directory to exist
+	if err := os.MkdirAll(pluginsDir, 0755); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func readManifest(extractDir string) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := os.ReadFile(filepath.Join(extractDir, manifestName))
+	if err != nil {
+		return manifest, errors.New("Archive isn't a Crankshaft backup")
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("Error decoding backup manifest: %v", err)
+	}
+	if manifest.Version > formatVersion {
+		return manifest, fmt.Errorf("Backup was created by a newer version of Crankshaft (%s), update Crankshaft to restore it", manifest.CrankshaftVersion)
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/store"
+)
+
+func writeTestFile(t *testing.T, filePath, contents string) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFile(t *testing.T, filePath, contents string) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != contents {
+		t.Fatalf(`Expected "%s" to contain "%s", got "%s"`, filePath, contents, data)
+	}
+}
+
+func expectMissing(t *testing.T, filePath string) {
+	if _, err := os.Lstat(filePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf(`Expected "%s" not to exist, got %v`, filePath, err)
+	}
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	dataDir := t.TempDir()
+	pluginsDir := filepath.Join(dataDir, "plugins")
+	archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	writeTestFile(t, filepath.Join(dataDir, "config.toml"), "watch-plugins = true\n")
+	writeTestFile(t, filepath.Join(pluginsDir, "kept", "plugin.toml"), `name = "Kept"`)
+	writeTestFile(t, filepath.Join(pluginsDir, ".install-123", "archive.tar.gz"), "partial")
+
+	pluginStore, err := store.Open(filepath.Join(dataDir, "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pluginStore.Set(store.PluginNamespace("kept"), "key", "backed up", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store can't be backed up while it's open
+	if err := Create(archivePath, dataDir, pluginsDir); !errors.Is(err, store.ErrInUse) {
+		t.Fatalf("Create expected ErrInUse, got %v", err)
+	}
+	pluginStore.Close()
+
+	if err := Create(archivePath, dataDir, pluginsDir); err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	// Change everything after the backup
+	writeTestFile(t, filepath.Join(dataDir, "config.toml"), "watch-plugins = false\n")
+	writeTestFile(t, filepath.Join(pluginsDir, "kept", "plugin.toml"), `name = "Changed"`)
+	writeTestFile(t, filepath.Join(pluginsDir, "added", "plugin.toml"), `name = "Added"`)
+	pluginStore, err = store.Open(filepath.Join(dataDir, "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pluginStore.Set(store.PluginNamespace("kept"), "key", "changed", 0); err != nil {
+		t.Fatal(err)
+	}
+	pluginStore.Close()
+
+	if err := Restore(archivePath, dataDir, pluginsDir); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+
+	expectFile(t, filepath.Join(dataDir, "config.toml"), "watch-plugins = true\n")
+	expectFile(t, filepath.Join(pluginsDir, "kept", "plugin.toml"), `name = "Kept"`)
+	expectMissing(t, filepath.Join(pluginsDir, "added"))
+	expectMissing(t, filepath.Join(pluginsDir, ".install-123"))
+
+	pluginStore, err = store.Open(filepath.Join(dataDir, "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginStore.Close()
+	if value, _, err := pluginStore.Get(store.PluginNamespace("kept"), "key"); err != nil || value != "backed up" {
+		t.Fatalf(`Expected restored store value "backed up", got "%s", %v`, value, err)
+	}
+}
+
+func TestRestoreRejectsOtherArchives(t *testing.T) {
+	dataDir := t.TempDir()
+	pluginsDir := filepath.Join(dataDir, "plugins")
+	writeTestFile(t, filepath.Join(dataDir, "config.toml"), "watch-plugins = true\n")
+	writeTestFile(t, filepath.Join(pluginsDir, "kept", "plugin.toml"), `name = "Kept"`)
+
+	// An archive without a backup manifest, like a plugin archive
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	contents := `name = "Other"`
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name:     "plugins/other/plugin.toml",
+		Mode:     0644,
+		Size:     int64(len(contents)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tarWriter.Write([]byte(contents))
+	tarWriter.Close()
+	gzipWriter.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "other.tar.gz")
+	writeTestFile(t, archivePath, buf.String())
+
+	if err := Restore(archivePath, dataDir, pluginsDir); err == nil {
+		t.Fatal("Restore expected error, got nil")
+	}
+
+	expectFile(t, filepath.Join(dataDir, "config.toml"), "watch-plugins = true\n")
+	expectFile(t, filepath.Join(pluginsDir, "kept", "plugin.toml"), `name = "Kept"`)
+	expectMissing(t, filepath.Join(pluginsDir, "other"))
+}
+
+func TestCreateFollowsSymlinkedPlugins(t *testing.T) {
+	dataDir := t.TempDir()
+	pluginsDir := filepath.Join(dataDir, "plugins")
+	sourceDir := filepath.Join(t.TempDir(), "linked")
+	archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+
+	writeTestFile(t, filepath.Join(sourceDir, "plugin.toml"), `name = "Linked"`)
+	writeTestFile(t, filepath.Join(sourceDir, "node_modules", "pkg", "cli.js"), "cli")
+	if err := os.MkdirAll(filepath.Join(sourceDir, "node_modules", ".bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../pkg/cli.js", filepath.Join(sourceDir, "node_modules", ".bin", "cli")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(pluginsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(sourceDir, filepath.Join(pluginsDir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Create(archivePath, dataDir, pluginsDir); err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	if err := Restore(archivePath, dataDir, pluginsDir); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(pluginsDir, "linked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Fatal("Expected symlinked plugin to be restored as a directory")
+	}
+	expectFile(t, filepath.Join(pluginsDir, "linked", "plugin.toml"), `name = "Linked"`)
+	expectFile(t, filepath.Join(pluginsDir, "linked", "node_modules", ".bin", "cli"), "cli")
+
+	// Links out of the plugin wouldn't work once it's restored
+	if err := os.Symlink(t.TempDir(), filepath.Join(sourceDir, "outside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(sourceDir, filepath.Join(pluginsDir, "relinked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Create(archivePath, dataDir, pluginsDir); err == nil {
+		t.Fatal("Create expected error for a link outside of the plugin, got nil")
+	}
+}
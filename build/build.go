@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"path"
 	"runtime"
+	"strings"
 	"text/template"
 
 	"git.sr.ht/~avery/crankshaft/cdp"
@@ -39,13 +41,6 @@ var Engines = []api.Engine{
 func BundleScripts() error {
 	log.Println("Bundling scripts to inject...")
 
-	// TODO: get sourcemaps working
-	// I tried inline sourcemaps, and aside from being massive, they were broken
-	// and linked everything to the same line. Tried external maps served from
-	// the static server, it seems like Chrome was refusing to load them and
-	// throwing an error.
-	// (not a huge deal since the bundle is small and isn't minified or anything)
-
 	res := api.Build(api.BuildOptions{
 		EntryPoints: []string{
 			"injected/src/entrypoints/library.ts",
@@ -70,8 +65,10 @@ func BundleScripts() error {
 			"process": `{"env":{"NODE_ENV":"development"}}`,
 		},
 		Plugins: []api.Plugin{DomChefPlugin()},
-		Outdir:  ".build",
-		Write:   true,
+		// Source maps are served by the RPC server, see CoreSourceComments
+		Sourcemap: api.SourceMapExternal,
+		Outdir:    ".build",
+		Write:     true,
 	})
 
 	if err := checkErrors(res.Errors); err != nil {
@@ -96,6 +93,7 @@ func BundleSharedScripts() (string, error) {
 		JSXFragment: "DocumentFragment",
 		Inject:      []string{"injected/preact-shim.js"},
 		GlobalName:  "smmShared",
+		Sourcemap:   api.SourceMapExternal,
 		Outdir:      ".build",
 		Write:       true,
 	})
@@ -106,7 +104,7 @@ func BundleSharedScripts() (string, error) {
 
 	checkWarnings(res.Warnings)
 
-	script, err := ioutil.ReadFile(res.OutputFiles[0].Path)
+	script, err := ioutil.ReadFile(path.Join(".build", "shared.js"))
 	if err != nil {
 		return "", fmt.Errorf("Failed to read shared scripts output: %v", err)
 	}
@@ -117,7 +115,8 @@ func BundleSharedScripts() (string, error) {
 //go:embed eval.template.js
 var evalScriptTemplate string
 
-// BuildEvalScriptFromFile gets a script from a file and builds an eval script with it.
+// BuildEvalScriptFromFile gets a script from a file and builds an eval script
+// with it. The script is named after the file.
 func BuildEvalScriptFromFile(serverPort string, uiMode cdp.UIMode, scriptPath, steamPath, authToken, pluginsDir string) (string, error) {
 	scriptBytes, err := ioutil.ReadFile(scriptPath)
 	if err != nil {
@@ -126,11 +125,14 @@ func BuildEvalScriptFromFile(serverPort string, uiMode cdp.UIMode, scriptPath, s
 
 	script := string(scriptBytes)
 
-	return BuildEvalScript(serverPort, uiMode, script, steamPath, authToken, pluginsDir)
+	name := strings.TrimSuffix(path.Base(scriptPath), path.Ext(scriptPath))
+
+	return BuildEvalScript(serverPort, uiMode, name, script, steamPath, authToken, pluginsDir)
 }
 
 // BuildEvalScript builds a script to be evaluated in the Steam target context.
-func BuildEvalScript(serverPort string, uiMode cdp.UIMode, script, steamPath, authToken, pluginsDir string) (string, error) {
+// The name is used to link the script to its source map.
+func BuildEvalScript(serverPort string, uiMode cdp.UIMode, name, script, steamPath, authToken, pluginsDir string) (string, error) {
 	evalTmpl := template.Must(template.New("eval").Parse(evalScriptTemplate))
 	var evalScript bytes.Buffer
 
@@ -149,6 +151,7 @@ func BuildEvalScript(serverPort string, uiMode cdp.UIMode, script, steamPath, au
 	if err := evalTmpl.Execute(&evalScript, globals); err != nil {
 		return "", fmt.Errorf("Failed to execute eval script template: %w", err)
 	}
+	evalScript.WriteString(CoreSourceComments(serverPort, name))
 
 	_ = ioutil.WriteFile(".build/evalScript.js", []byte(evalScript.String()), 0644)
 
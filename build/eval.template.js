@@ -6,7 +6,7 @@ window.smmUIMode = '{{ .UIMode }}';
 window.csSteamDir = '{{ .SteamDir }}';
 window.csPluginsDir = '{{ .PluginsDir }}';
 window.csVersion = '{{ .Version }}';
-window.csAuthToken = '{{ .AuthToken }}';
+window.csCoreAuthToken = '{{ .AuthToken }}';
 window.csPlatform = '{{ .Platform }}';
 
 {{ .InjectedScript }}
\ No newline at end of file
@@ -0,0 +1,106 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var namedImports = regexp.MustCompile(`import\s*(?:type\s*)?\{([^}]*)\}\s*from\s*'[^./][^']*'`)
+
+// stubPackagesPlugin replaces packages with a stub, so the injected scripts
+// can be bundled without installing their dependencies. The stub exports every
+// name the scripts import from a package.
+func stubPackagesPlugin(t *testing.T) api.Plugin {
+	// dom-chef's h is imported by DomChefPlugin
+	names := map[string]bool{"h": true}
+	err := filepath.Walk("../injected", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.Contains(".ts.tsx.js", filepath.Ext(path)) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range namedImports.FindAllStringSubmatch(string(data), -1) {
+			for _, name := range strings.Split(match[1], ",") {
+				name = strings.TrimSpace(strings.Split(strings.TrimSpace(name), " as ")[0])
+				if name != "" {
+					names[name] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var contents strings.Builder
+	contents.WriteString(`const stub = new Proxy(function () {}, {
+	get: (_, key) => (key === 'then' ? undefined : stub),
+	apply: () => stub,
+	construct: () => stub,
+});
+export default stub;
+`)
+	for name := range names {
+		fmt.Fprintf(&contents, "export const %s = stub;\n", name)
+	}
+	stub := contents.String()
+
+	return api.Plugin{
+		Name: "stub-packages",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: `^[^./]`}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				return api.OnResolveResult{Path: args.Path, Namespace: "stub"}, nil
+			})
+			build.OnLoad(api.OnLoadOptions{Filter: `.*`, Namespace: "stub"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{Contents: &stub, Loader: api.LoaderJS}, nil
+			})
+		},
+	}
+}
+
+func TestPluginInstanceIsolation(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node isn't installed")
+	}
+
+	res := api.Build(api.BuildOptions{
+		EntryPoints: []string{"testdata/plugin-isolation.ts"},
+		Bundle:      true,
+		Format:      api.FormatIIFE,
+		Target:      Target,
+		JSXFactory:  "h",
+		JSXFragment: "DocumentFragment",
+		Inject:      []string{"../injected/preact-shim.js"},
+		Loader: map[string]api.Loader{
+			".svg": api.LoaderDataURL,
+			".css": api.LoaderText,
+		},
+		Plugins: []api.Plugin{DomChefPlugin(), stubPackagesPlugin(t)},
+		Write:   false,
+		Outdir:  t.TempDir(),
+	})
+	if err := checkErrors(res.Errors); err != nil {
+		t.Fatal(err)
+	}
+
+	script := filepath.Join(t.TempDir(), "plugin-isolation.js")
+	if err := os.WriteFile(script, res.OutputFiles[0].Contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(node, script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Plugin instance isn't isolated from the core instance: %v\n%s", err, out)
+	}
+}
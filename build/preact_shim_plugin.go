@@ -0,0 +1,36 @@
+package build
+
+import (
+	"path/filepath"
+	"regexp"
+
+	"git.sr.ht/~avery/crankshaft/injected"
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// PreactShimPath returns the path to inject into builds that use
+// PreactShimPlugin. The file doesn't exist, the plugin provides its contents.
+func PreactShimPath(resolveDir string) string {
+	return filepath.Join(resolveDir, "__crankshaft_preact_shim.js")
+}
+
+// PreactShimPlugin provides the Preact shim for builds that don't have
+// injected/preact-shim.js on disk, like plugin builds. The shim's imports are
+// resolved from resolveDir, so Preact is loaded from its node_modules.
+func PreactShimPlugin(resolveDir string) api.Plugin {
+	shimPath := PreactShimPath(resolveDir)
+
+	return api.Plugin{
+		Name: "preact-shim",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{Filter: "^" + regexp.QuoteMeta(shimPath) + "$"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				contents := injected.PreactShim
+				return api.OnLoadResult{
+					Contents:   &contents,
+					ResolveDir: resolveDir,
+					Loader:     api.LoaderJS,
+				}, nil
+			})
+		},
+	}
+}
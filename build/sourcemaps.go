@@ -0,0 +1,66 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Number of lines the eval script template adds before the injected script,
+// which its source map has to be offset by
+var EvalScriptHeaderLines = strings.Count(
+	evalScriptTemplate[:strings.Index(evalScriptTemplate, "{{ .InjectedScript }}")],
+	"\n",
+)
+
+// CoreSourceComments returns the comments to append to a core script so it
+// shows up in devtools as crankshaft://core/<name>.js, with its source map
+// loaded from the Crankshaft server.
+func CoreSourceComments(serverPort, name string) string {
+	return sourceComments(
+		"crankshaft://core/"+name+".js",
+		fmt.Sprintf("http://localhost:%s/sourcemaps/core/%s.js.map", serverPort, name),
+	)
+}
+
+// PluginSourceComments returns the comments to append to a plugin's script so
+// it shows up in devtools as crankshaft://plugins/<id>.js, with its source map
+// loaded from the Crankshaft server.
+func PluginSourceComments(serverPort, pluginId string) string {
+	return sourceComments(
+		"crankshaft://plugins/"+url.PathEscape(pluginId)+".js",
+		fmt.Sprintf("http://localhost:%s/sourcemaps/plugins/%s.js.map", serverPort, url.PathEscape(pluginId)),
+	)
+}
+
+func sourceComments(sourceUrl, sourceMapUrl string) string {
+	return "\n//# sourceURL=" + sourceUrl + "\n//# sourceMappingURL=" + sourceMapUrl + "\n"
+}
+
+// OffsetSourceMap shifts a source map down by the given number of lines, for
+// scripts that have lines added before them when they're injected.
+func OffsetSourceMap(sourceMap []byte, lines int) ([]byte, error) {
+	if lines == 0 {
+		return sourceMap, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(sourceMap, &fields); err != nil {
+		return nil, fmt.Errorf("Error decoding source map: %v", err)
+	}
+
+	var mappings string
+	if err := json.Unmarshal(fields["mappings"], &mappings); err != nil {
+		return nil, fmt.Errorf("Error decoding source map mappings: %v", err)
+	}
+
+	// Each ; in the mappings starts a new generated line
+	offsetMappings, err := json.Marshal(strings.Repeat(";", lines) + mappings)
+	if err != nil {
+		return nil, err
+	}
+	fields["mappings"] = offsetMappings
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,45 @@
+package build
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOffsetSourceMap(t *testing.T) {
+	sourceMap := `{"version":3,"sources":["index.ts"],"mappings":"AAAA;AACA"}`
+
+	offset, err := OffsetSourceMap([]byte(sourceMap), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}
+	if err := json.Unmarshal(offset, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Mappings != ";;AAAA;AACA" {
+		t.Fatalf("Expected mappings to be offset by 2 lines, got %q", decoded.Mappings)
+	}
+	if len(decoded.Sources) != 1 || decoded.Sources[0] != "index.ts" {
+		t.Fatalf("Expected other fields to be kept, got %v", decoded.Sources)
+	}
+}
+
+func TestEvalScriptHeaderLines(t *testing.T) {
+	script, err := BuildEvalScript("8085", "desktop", "library", "injectedScript();", "/steam", "token", "/plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(script, "\n")
+	if len(lines) <= EvalScriptHeaderLines || lines[EvalScriptHeaderLines] != "injectedScript();" {
+		t.Fatalf("Expected the script to start on line %d, got:\n%s", EvalScriptHeaderLines+1, script)
+	}
+	if !strings.Contains(script, "//# sourceMappingURL=http://localhost:8085/sourcemaps/core/library.js.map") {
+		t.Fatalf("Expected the script to link its source map, got:\n%s", script)
+	}
+}
@@ -0,0 +1,32 @@
+// Just enough of the browser for the services to be created in node
+
+const element = (): any => ({
+  style: {},
+  dataset: {},
+  classList: { add() {}, remove() {} },
+  appendChild: (child: unknown) => child,
+  addEventListener() {},
+  querySelector: () => null,
+  querySelectorAll: () => [],
+});
+
+const g = globalThis as any;
+g.window = globalThis;
+g.smmServerPort = '8085';
+g.smmUIMode = 'desktop';
+g.document = {
+  ...element(),
+  body: element(),
+  head: element(),
+  createElement: element,
+};
+g.WebSocket = class {
+  close() {}
+};
+g.requests = [];
+g.fetch = async (_: string, init: { headers: any; body: string }) => {
+  const { method } = JSON.parse(init.body);
+  g.requests.push({ method, authToken: init.headers['X-Cs-Auth'] });
+  const result = method === 'InjectService.PluginAuthToken' ? { token: 'plugin-token' } : {};
+  return { ok: true, json: async () => ({ result }) };
+};
@@ -0,0 +1,103 @@
+import './dom-stubs';
+
+import type { GamepadHandler } from '../../injected/src/gamepad';
+import { createRpcRequest } from '../../injected/src/rpc';
+import { exposeOnWindow, SMM } from '../../injected/src/smm';
+
+// Walks everything reachable from roots through properties, getters and
+// prototypes, and fails if any of it is forbidden
+const assertUnreachable = (roots: unknown[], forbidden: Set<unknown>) => {
+  const seen = new Set<unknown>();
+  const queue = roots.map((value) => ({ value, path: 'root' }));
+  while (queue.length > 0) {
+    const { value, path } = queue.shift()!;
+    if (forbidden.has(value)) {
+      throw new Error(`Plugin can reach the core instance through ${path}`);
+    }
+    if (
+      (typeof value !== 'object' && typeof value !== 'function') ||
+      value === null ||
+      seen.has(value)
+    ) {
+      continue;
+    }
+    seen.add(value);
+
+    queue.push({
+      value: Object.getPrototypeOf(value),
+      path: `${path}.__proto__`,
+    });
+    for (
+      let obj: object | null = value as object;
+      obj;
+      obj = Object.getPrototypeOf(obj)
+    ) {
+      for (const key of Reflect.ownKeys(obj)) {
+        const descriptor = Object.getOwnPropertyDescriptor(obj, key)!;
+        let property: unknown = descriptor.value;
+        if (descriptor.get) {
+          try {
+            property = descriptor.get.call(value);
+          } catch {
+            continue;
+          }
+        }
+        queue.push({ value: property, path: `${path}.${String(key)}` });
+      }
+    }
+  }
+};
+
+const main = async () => {
+  const coreRpcRequest = createRpcRequest('core-token');
+  const core = new SMM('keyboard', coreRpcRequest);
+  exposeOnWindow(core);
+
+  let plugin: SMM | undefined;
+  window.smmPlugins = {
+    test: {
+      load: (smm) => {
+        plugin = smm;
+      },
+    },
+  };
+  await core.loadPlugin('test');
+  if (!plugin) {
+    throw new Error('Plugin was not loaded');
+  }
+
+  let event: Event | undefined;
+  plugin.addEventListener('switchToHome', (e) => {
+    event = e;
+  });
+  core.switchToHome();
+  if (!event) {
+    throw new Error('Plugin did not get events from the core instance');
+  }
+
+  // Gamepad handlers hold the instance they were created with
+  core._setActiveGamepadHandler({
+    smm: core,
+    recalculateTree() {},
+  } as unknown as GamepadHandler);
+
+  assertUnreachable(
+    [plugin, window.smm, event],
+    new Set<unknown>([core, coreRpcRequest])
+  );
+
+  const requests = (globalThis as any).requests;
+  requests.length = 0;
+  await plugin.Plugins.list();
+  await plugin.Config.get();
+  for (const { method, authToken } of requests) {
+    if (authToken !== 'plugin-token') {
+      throw new Error(`Plugin called ${method} with token "${authToken}"`);
+    }
+  }
+};
+
+main().catch((err) => {
+  console.error(err);
+  process.exit(1);
+});
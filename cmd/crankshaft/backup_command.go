@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"git.sr.ht/~avery/crankshaft/backup"
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// runBackupCommand runs `crankshaft backup <file>`, which backs up the
+// plugins, config and plugin store into a single archive.
+func runBackupCommand(args []string) error {
+	flags := flag.NewFlagSet("crankshaft backup", flag.ExitOnError)
+	optionFlags := config.AddOptionFlags(flags, "data-dir", "plugins-dir")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected the file to write the backup to")
+	}
+	archivePath := flags.Arg(0)
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		return err
+	}
+
+	if err := backup.Create(archivePath, opts.DataDir, opts.PluginsDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backed up plugins, config and store to %s\n", archivePath)
+	return nil
+}
+
+// runRestoreCommand runs `crankshaft restore <file>`, which replaces the
+// plugins, config and plugin store with the ones in a backup.
+func runRestoreCommand(args []string) error {
+	flags := flag.NewFlagSet("crankshaft restore", flag.ExitOnError)
+	optionFlags := config.AddOptionFlags(flags, "data-dir", "plugins-dir")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected the backup to restore")
+	}
+	archivePath := flags.Arg(0)
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		return err
+	}
+
+	if err := backup.Restore(archivePath, opts.DataDir, opts.PluginsDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored plugins, config and store from %s\n", archivePath)
+	return nil
+}
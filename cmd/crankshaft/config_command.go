@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+const configCommandUsage = `Usage: crankshaft config <command> [arguments]
+
+Commands:
+  show   Show the options Crankshaft would run with, and where each is set
+`
+
+// runConfigCommand runs the `crankshaft config` commands.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configCommandUsage)
+		return errors.New("Missing config command")
+	}
+
+	switch args[0] {
+	case "show":
+		return runConfigShow(args[1:])
+	}
+
+	fmt.Fprint(os.Stderr, configCommandUsage)
+	return fmt.Errorf(`Unknown config command "%s"`, args[0])
+}
+
+// runConfigShow prints every option's effective value and its source. It
+// takes the same flags as Crankshaft, so passing them shows their effect.
+func runConfigShow(args []string) error {
+	flags := flag.NewFlagSet("crankshaft config show", flag.ExitOnError)
+	optionFlags := config.AddOptionFlags(flags)
+	flags.Parse(args)
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+	for _, value := range opts.Values() {
+		source := string(value.Source)
+		if value.Source == config.SourceEnv {
+			source = fmt.Sprintf("env (%s)", value.Env)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", value.Name, value.Value, source)
+	}
+	return w.Flush()
+}
@@ -8,84 +8,156 @@ import (
 	"path"
 	"sync"
 	"syscall"
+	"time"
 
 	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/backend"
 	"git.sr.ht/~avery/crankshaft/build"
 	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/config"
 	"git.sr.ht/~avery/crankshaft/patcher"
 	"git.sr.ht/~avery/crankshaft/plugins"
 	"git.sr.ht/~avery/crankshaft/ps"
+	"git.sr.ht/~avery/crankshaft/registry"
 	"git.sr.ht/~avery/crankshaft/rpc"
+	"git.sr.ht/~avery/crankshaft/safemode"
+	"git.sr.ht/~avery/crankshaft/store"
 	"git.sr.ht/~avery/crankshaft/tags"
 	"git.sr.ht/~avery/crankshaft/tray"
 )
 
+// How long to wait after a plugin's files stop changing before rebuilding it
+const pluginWatchDebounce = 300 * time.Millisecond
+
 func main() {
+	// Plugin, backup, restore and config commands don't start Crankshaft
+	if len(os.Args) > 1 {
+		var command func(args []string) error
+		switch os.Args[1] {
+		case "plugin":
+			command = runPluginCommand
+		case "backup":
+			command = runBackupCommand
+		case "restore":
+			command = runRestoreCommand
+		case "config":
+			command = runConfigCommand
+		}
+
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+	}
+
 	if err := run(); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 }
 
 func run() error {
-	debugPort, serverPort, skipPatching, dataDir, pluginsDir, logsDir, cacheDir, steamPath, cleanup, noCache := config.ParseFlags()
+	opts, err := config.ParseFlags()
+	if err != nil {
+		return err
+	}
 
-	if cleanup {
+	if opts.Cleanup {
 		log.Println("Cleaning up patched files and exiting")
-		err := patcher.Cleanup(steamPath)
+		err := patcher.Cleanup(opts.SteamPath)
 		if err != nil {
 			log.Println("Error cleaning up", err)
 		}
 		os.Exit(0)
 	}
 
-	if err := ensureDirsExist(noCache, dataDir, pluginsDir, logsDir, cacheDir, steamPath); err != nil {
+	if err := ensureDirsExist(opts.NoCache, opts.DataDir, opts.PluginsDir, opts.LogsDir, opts.CacheDir, opts.SteamPath); err != nil {
 		return fmt.Errorf("Error ensuring directories exist: %v", err)
 	}
 
-	if err := setupLogging(logsDir); err != nil {
+	if err := setupLogging(opts.LogsDir); err != nil {
 		return fmt.Errorf("Error setting up logging: %v", err)
 	}
 
-	crksftConfig, found, err := config.NewCrksftConfig(dataDir)
+	crksftConfig, found, err := config.NewCrksftConfig(opts.DataDir)
 	if err != nil {
 		return err
 	}
 
 	if !tags.Dev && (!found || !crksftConfig.InstalledAutostart) {
-		if err := firstLaunchEnableAutostart(dataDir, crksftConfig); err != nil {
+		if err := firstLaunchEnableAutostart(opts.DataDir, crksftConfig); err != nil {
 			return fmt.Errorf("Error installing autostart service on first launch: %v", err)
 		}
 	}
 
 	// Make sure CEF debugging is enabled
-	_, err = os.OpenFile(path.Join(steamPath, ".cef-enable-remote-debugging"), os.O_CREATE, 0644)
+	_, err = os.OpenFile(path.Join(opts.SteamPath, ".cef-enable-remote-debugging"), os.O_CREATE, 0644)
 	if err != nil {
 		log.Printf("Error enabling CEF debugging %v\n", err)
 	}
 
-	plugins, err := plugins.NewPlugins(crksftConfig, pluginsDir)
+	pluginStore, err := store.Open(path.Join(opts.DataDir, "store.db"))
+	if err != nil {
+		return err
+	}
+
+	plugins, err := plugins.NewPlugins(crksftConfig, opts.PluginsDir, path.Join(opts.DataDir, "plugin-backups"), pluginStore)
 	if err != nil {
 		return err
 	}
 
+	// Plugins are listed and updated from the repositories in the config, which
+	// are rebuilt when config.toml is edited
+	repositories, err := registry.NewRepositories(crksftConfig.GetRepositories(), plugins)
+	if err != nil {
+		return fmt.Errorf("Invalid repositories in Crankshaft config: %v", err)
+	}
+
+	if opts.WatchPlugins {
+		log.Println("Watching plugins for changes")
+		if _, err := plugins.Watch(pluginWatchDebounce); err != nil {
+			log.Printf("Error watching plugins: %v\n", err)
+			opts.WatchPlugins = false
+		}
+	}
+
+	// Failed startups are counted across restarts, after too many Crankshaft
+	// starts in safe mode and doesn't inject plugins
+	safeModeTracker, err := safemode.Open(path.Join(opts.DataDir, "safe-mode.json"), opts.SafeMode)
+	if err != nil {
+		return err
+	}
+	if safeModeTracker.Active() {
+		log.Println("Starting in safe mode, plugins won't be injected")
+	}
+
+	// Plugin backends are stopped when Crankshaft exits
+	backends := backend.NewSupervisor(path.Join(opts.LogsDir, "backends"), path.Join(opts.DataDir, "backends"))
+
 	authToken, err := auth.GenAuthToken()
 	if err != nil {
 		return err
 	}
+	// The patched Steam scripts get their own token, since plugins run in the
+	// same page and could read it
+	injectorToken, err := auth.GenAuthToken()
+	if err != nil {
+		return err
+	}
 
 	waitAndPatch := func() error {
-		cdp.WaitForConnection(debugPort)
-		cdp.WaitForLibraryEl(debugPort)
-		cdp.ShowLoadingIndicator(debugPort, serverPort, authToken)
-		err = patcher.Patch(debugPort, serverPort, steamPath, cacheDir, noCache, authToken)
+		cdp.WaitForConnection(opts.DebugPort)
+		cdp.WaitForLibraryEl(opts.DebugPort)
+		cdp.ShowLoadingIndicator(opts.DebugPort, opts.ServerPort, authToken)
+		err = patcher.Patch(opts.DebugPort, opts.ServerPort, opts.SteamPath, opts.CacheDir, opts.NoCache, injectorToken)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	tray.StartTray(waitAndPatch, logsDir)
+	tray.StartTray(waitAndPatch, opts.LogsDir)
 
 	// Patch and bundle in parallel
 	var wg sync.WaitGroup
@@ -100,7 +172,7 @@ func run() error {
 
 	// If Steam is already running we can patch it while bundling
 	alreadyPatched := false
-	if ps.IsSteamRunning() && !skipPatching {
+	if ps.IsSteamRunning() && !opts.SkipPatching {
 		wg.Add(1)
 		alreadyPatched = true
 
@@ -115,18 +187,20 @@ func run() error {
 	// Start RPC server in the background
 	// This will keep running in the background, so we don't need to add it to the wait group
 	go func() {
-		rpc.StartRpcServer(debugPort, serverPort, steamPath, dataDir, pluginsDir, authToken, plugins)
+		rpc.StartRpcServer(opts.DebugPort, opts.ServerPort, opts.SteamPath, opts.DataDir, opts.PluginsDir, authToken, injectorToken, crksftConfig, plugins, repositories, pluginStore, backends, safeModeTracker, opts.WatchPlugins)
 	}()
 
 	wg.Wait()
 
 	// When Crankshaft exits, clean up patched JS
-	exitSigs := make(chan os.Signal)
+	exitSigs := make(chan os.Signal, 1)
 	signal.Notify(exitSigs, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		<-exitSigs
+		log.Println("Stopping plugin backends")
+		backends.StopAll()
 		log.Println("Cleaning up patched scripts before exiting")
-		err := patcher.Cleanup(steamPath)
+		err := patcher.Cleanup(opts.SteamPath)
 		if err != nil {
 			log.Println("Error cleaning up", err)
 		}
@@ -136,7 +210,7 @@ func run() error {
 	// If Steam was already running and we patched it earlier, wait for Steam to stop first
 	if alreadyPatched {
 		ps.WaitForSteamProcessToStop()
-		err := patcher.Cleanup(steamPath)
+		err := patcher.Cleanup(opts.SteamPath)
 		if err != nil {
 			log.Println("Error cleaning up", err)
 		}
@@ -147,6 +221,10 @@ func run() error {
 		log.Println("Waiting for Steam to start...")
 		ps.WaitForSteamProcess()
 
+		if err := safeModeTracker.SteamStarted(); err != nil {
+			log.Println(err)
+		}
+
 		if err := waitAndPatch(); err != nil {
 			return err
 		}
@@ -154,7 +232,7 @@ func run() error {
 		ps.WaitForSteamProcessToStop()
 
 		log.Println("Steam stopped, cleaning up patched files...")
-		err := patcher.Cleanup(steamPath)
+		err := patcher.Cleanup(opts.SteamPath)
 		if err != nil {
 			log.Println("Error cleaning up", err)
 		}
@@ -18,9 +18,10 @@ func firstLaunchEnableAutostart(dataDir string, crksftConfig *config.CrksftConfi
 			return fmt.Errorf("Error installing autostart service: %v", err)
 		}
 
-		crksftConfig.InstalledAutostart = true
-
-		if err := crksftConfig.Write(); err != nil {
+		err := crksftConfig.Update(func(crksftConfig *config.CrksftConfig) {
+			crksftConfig.InstalledAutostart = true
+		})
+		if err != nil {
 			return fmt.Errorf("Error writing config: %v", err)
 		}
 
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/config"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/registry"
+	"git.sr.ht/~avery/crankshaft/store"
+)
+
+const pluginCommandUsage = `Usage: crankshaft plugin <command> [arguments]
+
+Commands:
+  new <dir>        Create a new plugin in dir
+  validate [dir]   Check a plugin for problems, dir defaults to the current directory
+  pack [dir]       Pack a plugin into an archive for the registry
+  install <file>   Install a plugin archive without downloading it
+`
+
+// runPluginCommand runs the `crankshaft plugin` developer and install
+// commands.
+func runPluginCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, pluginCommandUsage)
+		return errors.New("Missing plugin command")
+	}
+
+	switch args[0] {
+	case "new":
+		return runPluginNew(args[1:])
+	case "validate":
+		return runPluginValidate(args[1:])
+	case "pack":
+		return runPluginPack(args[1:])
+	case "install":
+		return runPluginInstall(args[1:])
+	}
+
+	fmt.Fprint(os.Stderr, pluginCommandUsage)
+	return fmt.Errorf(`Unknown plugin command "%s"`, args[0])
+}
+
+func runPluginNew(args []string) error {
+	flags := flag.NewFlagSet("crankshaft plugin new", flag.ExitOnError)
+	name := flags.String("name", "", "Name of the plugin, defaults to the directory name")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected the directory to create the plugin in")
+	}
+	pluginDir := flags.Arg(0)
+
+	if *name == "" {
+		*name = filepath.Base(pluginDir)
+	}
+
+	if err := plugins.CreatePlugin(pluginDir, *name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created plugin %s in %s\n", *name, pluginDir)
+	return nil
+}
+
+func runPluginValidate(args []string) error {
+	flags := flag.NewFlagSet("crankshaft plugin validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	pluginDir := pluginDirArg(flags)
+
+	problems := plugins.ValidatePlugin(pluginDir)
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", pluginDir)
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", pluginDir, problem)
+	}
+	return fmt.Errorf("Found %d problems", len(problems))
+}
+
+func runPluginPack(args []string) error {
+	flags := flag.NewFlagSet("crankshaft plugin pack", flag.ExitOnError)
+	outDir := flags.String("out", "", "Directory to write the archive to, defaults to the plugin directory's parent")
+	archiveBaseUrl := flags.String("base-url", "", "URL the archive will be uploaded to, used for the registry entry")
+	flags.Parse(args)
+
+	pluginDir, err := filepath.Abs(pluginDirArg(flags))
+	if err != nil {
+		return err
+	}
+	if *outDir == "" {
+		*outDir = filepath.Dir(pluginDir)
+	}
+
+	res, err := registry.Pack(pluginDir, *outDir, *archiveBaseUrl)
+	if err != nil {
+		return err
+	}
+
+	entry, err := json.MarshalIndent(res.Entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	entryPath := strings.TrimSuffix(res.ArchivePath, ".tar.gz") + ".json"
+	if err := os.WriteFile(entryPath, append(entry, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing registry entry: %v", err)
+	}
+
+	fmt.Printf("Archive: %s\n", res.ArchivePath)
+	fmt.Printf("sha256: %s\n", res.Entry.Sha256)
+	fmt.Printf("Registry entry (also written to %s):\n%s\n", entryPath, entry)
+	return nil
+}
+
+func runPluginInstall(args []string) error {
+	flags := flag.NewFlagSet("crankshaft plugin install", flag.ExitOnError)
+	optionFlags := config.AddOptionFlags(flags, "data-dir", "plugins-dir")
+	signature := flags.String("signature", "", "Base64 encoded ed25519 signature of the archive")
+	allowUnsigned := flags.Bool("allow-unsigned", false, "Install the archive even if it isn't signed by a trusted key")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("Expected the plugin archive to install")
+	}
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		return err
+	}
+	// A running Crankshaft would overwrite the config we save, and it keeps the
+	// store locked, so that's how we know it's running
+	if err := store.EnsureNotInUse(filepath.Join(opts.DataDir, "store.db")); errors.Is(err, store.ErrInUse) {
+		return errors.New("Crankshaft is running, stop it first or install the plugin from the plugin manager")
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.PluginsDir, 0755); err != nil {
+		return err
+	}
+
+	crksftConfig, _, err := config.NewCrksftConfig(opts.DataDir)
+	if err != nil {
+		return err
+	}
+
+	// The store is left out, it's only needed to remove plugins' data
+	p, err := plugins.NewPlugins(crksftConfig, opts.PluginsDir, filepath.Join(opts.DataDir, "plugin-backups"), nil)
+	if err != nil {
+		return err
+	}
+
+	id, err := p.InstallFromFile(flags.Arg(0), *signature, *allowUnsigned)
+	var signatureErr *plugins.SignatureError
+	if errors.As(err, &signatureErr) {
+		return fmt.Errorf("%v, pass -allow-unsigned to install it anyway", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if plugin, ok := p.Get(id); ok && plugin.Status == plugins.PluginStatusUnsupported {
+		fmt.Printf("Installed plugin %s, but it can't be enabled: %s\n", id, plugin.Error)
+		return nil
+	}
+
+	fmt.Printf("Installed plugin %s, enable it in the plugin manager\n", id)
+	return nil
+}
+
+// pluginDirArg returns the plugin directory argument, or the current directory
+// if there isn't one.
+func pluginDirArg(flags *flag.FlagSet) string {
+	if flags.NArg() > 0 {
+		return flags.Arg(0)
+	}
+	return "."
+}
@@ -7,27 +7,183 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"github.com/BurntSushi/toml"
 )
 
+// DefaultRegistryUrl is the plugin registry index used if the config doesn't
+// set one.
+const DefaultRegistryUrl = "https://crankshaft.space/plugins.json"
+
+// DefaultRepositoryName is the name of the repository for RegistryUrl, used
+// if the config doesn't list any repositories.
+const DefaultRepositoryName = "crankshaft.space"
+
+// Default time between background checks for plugin updates
+const defaultUpdateCheckInterval = "24h"
+
+// Default size limit in bytes of each plugin's store namespace, and of the
+// namespace shared between plugins
+const defaultStoreQuota = 1024 * 1024
+
 type CrksftConfigPlugin struct {
+	// Enabled in every UI mode that isn't set in EnabledModes
 	Enabled bool `toml:"enabled"`
+	// Whether the plugin is enabled by UI mode, overriding Enabled
+	EnabledModes map[string]bool `toml:"enabled-modes"`
+	// Permissions the user approved when enabling the plugin
+	ApprovedPermissions auth.Scope `toml:"approved-permissions"`
+	// The user allowed installing archives of the plugin that aren't signed.
+	// It's cleared when a signed archive is installed.
+	AllowUnsigned bool `toml:"allow-unsigned"`
+	// Trusted key that signed the installed archive, empty if it wasn't signed
+	SignedBy string `toml:"signed-by"`
+	// Values of the settings declared by the plugin
+	Settings map[string]interface{} `toml:"settings"`
+	// Size limit in bytes of the plugin's store namespace, overriding the
+	// default store quota if it's set
+	StoreQuota int64 `toml:"store-quota"`
+	// Name of the repository the plugin was installed from, its updates are
+	// only taken from that repository. Empty if it wasn't installed from one.
+	Repository string `toml:"repository"`
+}
+
+// IsEnabledIn reports whether the user enabled the plugin in the UI mode.
+func (p CrksftConfigPlugin) IsEnabledIn(uiMode string) bool {
+	if enabled, ok := p.EnabledModes[uiMode]; ok {
+		return enabled
+	}
+	return p.Enabled
 }
 
+// clone returns a copy of the plugin's config that doesn't share its maps.
+// Values are replaced rather than changed in place, so they aren't copied.
+func (p CrksftConfigPlugin) clone() CrksftConfigPlugin {
+	if p.EnabledModes != nil {
+		enabledModes := make(map[string]bool, len(p.EnabledModes))
+		for uiMode, enabled := range p.EnabledModes {
+			enabledModes[uiMode] = enabled
+		}
+		p.EnabledModes = enabledModes
+	}
+	if p.Settings != nil {
+		settings := make(map[string]interface{}, len(p.Settings))
+		for key, value := range p.Settings {
+			settings[key] = value
+		}
+		p.Settings = settings
+	}
+	return p
+}
+
+// CrksftConfigProfile is a named set of plugins that can be enabled at once.
+type CrksftConfigProfile struct {
+	// IDs of the plugins the profile enables, by UI mode
+	Plugins map[string][]string `toml:"plugins"`
+}
+
+// clone returns a copy of the profile that doesn't share its map or lists.
+func (p CrksftConfigProfile) clone() CrksftConfigProfile {
+	plugins := make(map[string][]string, len(p.Plugins))
+	for uiMode, pluginIds := range p.Plugins {
+		plugins[uiMode] = append([]string{}, pluginIds...)
+	}
+	return CrksftConfigProfile{Plugins: plugins}
+}
+
+// CrksftConfigRepository is a plugin registry to list plugins and check for
+// updates from.
+type CrksftConfigRepository struct {
+	// Unique name the repository is shown and referred to by
+	Name string `toml:"name"`
+	// URL of the repository's plugin index
+	Url string `toml:"url"`
+	// Base64 encoded ed25519 public key that's trusted to sign archives
+	// installed from this repository, optional
+	TrustedKey string `toml:"trusted-key"`
+	// When repositories list the same plugin, the one with the highest priority
+	// is used. Repositories with the same priority are used in the order
+	// they're listed.
+	Priority int `toml:"priority"`
+}
+
+// CrksftConfig is Crankshaft's config.toml. Its fields can change whenever it's
+// updated or reloaded, so once it's shared they should only be read through the
+// Get methods, which return copies, or inside Update.
 type CrksftConfig struct {
-	filePath           string
+	filePath string
+	// Held while the config is updated and written, so concurrent updates
+	// don't interleave. It's a pointer since the encoder copies the config.
+	mu *sync.Mutex
+
+	// Version of the config format, see configVersion
+	Version            int `toml:"version"`
 	InstalledAutostart bool
-	Plugins            map[string]CrksftConfigPlugin `toml:"plugins"`
+
+	// Options that can also be set with flags and environment variables, which
+	// take precedence. See Options.
+	DebugPort    string `toml:"debug-port,omitempty"`
+	ServerPort   string `toml:"server-port,omitempty"`
+	SkipPatching bool   `toml:"skip-patching,omitempty"`
+	PluginsDir   string `toml:"plugins-dir,omitempty"`
+	LogsDir      string `toml:"logs-dir,omitempty"`
+	CacheDir     string `toml:"cache-dir,omitempty"`
+	SteamPath    string `toml:"steam-path,omitempty"`
+	NoCache      bool   `toml:"no-cache,omitempty"`
+	// Rebuild and reinject plugins when their files change
+	WatchPlugins bool `toml:"watch-plugins"`
+	SafeMode     bool `toml:"safe-mode,omitempty"`
+
+	// Number of previous versions to keep for each plugin
+	KeepPluginBackups int `toml:"keep-plugin-backups"`
+	// URL of the plugin registry index to check for updates, only used if
+	// Repositories is empty
+	RegistryUrl string `toml:"registry-url"`
+	// Plugin registries to list plugins and check for updates from
+	Repositories []CrksftConfigRepository `toml:"repositories"`
+	// How often to check for plugin updates, as a duration like "12h". "0"
+	// disables background checks.
+	UpdateCheckInterval string `toml:"update-check-interval"`
+	// Base64 encoded ed25519 public keys of plugin publishers to trust, in
+	// addition to the keys Crankshaft ships with
+	TrustedKeys []string `toml:"trusted-keys"`
+	// Size limit in bytes of each plugin's store namespace, 0 disables the
+	// limit
+	StoreQuota int64 `toml:"store-quota"`
+	// Size limit in bytes of the store namespace shared between plugins, 0
+	// disables the limit
+	SharedStoreQuota int64 `toml:"shared-store-quota"`
+	// Profile that was activated last, changes to enabled plugins are saved to
+	// it
+	ActiveProfile string                         `toml:"active-profile"`
+	Profiles      map[string]CrksftConfigProfile `toml:"profiles"`
+	Plugins       map[string]CrksftConfigPlugin  `toml:"plugins"`
 }
 
 func NewCrksftConfig(dataDir string) (*CrksftConfig, bool, error) {
+	return loadConfig(path.Join(dataDir, "config.toml"), true)
+}
+
+// loadConfig reads the config at filePath, migrating it if it has an older
+// version. It also returns whether the file exists. The migrated config is only
+// written back, after backing up the original, if save is true.
+func loadConfig(filePath string, save bool) (*CrksftConfig, bool, error) {
 	config := CrksftConfig{
-		filePath: path.Join(
-			dataDir, "config.toml",
-		),
-		InstalledAutostart: false,
-		Plugins:            make(map[string]CrksftConfigPlugin),
+		filePath:            filePath,
+		mu:                  &sync.Mutex{},
+		Version:             configVersion,
+		InstalledAutostart:  false,
+		RegistryUrl:         DefaultRegistryUrl,
+		UpdateCheckInterval: defaultUpdateCheckInterval,
+		StoreQuota:          defaultStoreQuota,
+		SharedStoreQuota:    defaultStoreQuota,
+		Profiles:            make(map[string]CrksftConfigProfile),
+		Plugins:             make(map[string]CrksftConfigPlugin),
 	}
 
 	data, err := os.ReadFile(config.filePath)
@@ -40,19 +196,103 @@ func NewCrksftConfig(dataDir string) (*CrksftConfig, bool, error) {
 		}
 	}
 
+	original := data
+	data, fromVersion, err := migrateConfig(original)
+	if err != nil {
+		return &config, true, err
+	}
+
 	if _, err := toml.Decode(string(data), &config); err != nil {
 		return &config, true, fmt.Errorf("Error decoding Crankshaft config: %v", err)
 	}
 
+	if save && fromVersion < configVersion {
+		if err := backupConfig(config.filePath, fromVersion, original); err != nil {
+			return &config, true, err
+		}
+		if err := config.Write(); err != nil {
+			return &config, true, fmt.Errorf("Error writing migrated Crankshaft config: %v", err)
+		}
+	}
+
 	return &config, true, nil
 }
 
+// Reload reads config.toml again and replaces the config with it, so edits
+// made outside of Crankshaft are applied. The config is left as it is if the
+// file doesn't exist or can't be decoded. It returns the keys of the settings
+// whose values changed.
+func (c *CrksftConfig) Reload() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded, found, err := loadConfig(c.filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []string{}, nil
+	}
+
+	before := c.settings()
+	c.replaceFields(loaded)
+
+	return changedSettings(before, c.settings()), nil
+}
+
+// replaceFields replaces the config's fields with other's. Only the exported
+// fields are replaced, the mutex readers lock can't be written while they use
+// it.
+func (c *CrksftConfig) replaceFields(other *CrksftConfig) {
+	current := reflect.ValueOf(c).Elem()
+	replacement := reflect.ValueOf(other).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		if current.Type().Field(i).IsExported() {
+			current.Field(i).Set(replacement.Field(i))
+		}
+	}
+}
+
+// clone returns a copy of the config that doesn't share its maps or lists, so
+// it can be changed without changing the config.
+func (c *CrksftConfig) clone() *CrksftConfig {
+	clone := *c
+	clone.Repositories = append([]CrksftConfigRepository(nil), c.Repositories...)
+	clone.TrustedKeys = append([]string(nil), c.TrustedKeys...)
+	clone.Profiles = make(map[string]CrksftConfigProfile, len(c.Profiles))
+	for name, profile := range c.Profiles {
+		clone.Profiles[name] = profile.clone()
+	}
+	clone.Plugins = make(map[string]CrksftConfigPlugin, len(c.Plugins))
+	for pluginId, plugin := range c.Plugins {
+		clone.Plugins[pluginId] = plugin.clone()
+	}
+	return &clone
+}
+
+// FilePath returns the path of config.toml.
+func (c *CrksftConfig) FilePath() string {
+	return c.filePath
+}
+
+// Write writes the config. It's written to a temporary file that's renamed
+// over config.toml once it's complete, so a crash while writing leaves the
+// previous config in place.
 func (c *CrksftConfig) Write() error {
-	file, err := os.OpenFile(c.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.write()
+}
+
+func (c *CrksftConfig) write() error {
+	file, err := os.CreateTemp(filepath.Dir(c.filePath), ".config.toml-")
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
 	defer file.Close()
 
 	encoder := toml.NewEncoder(file)
@@ -60,21 +300,161 @@ func (c *CrksftConfig) Write() error {
 		return fmt.Errorf("Error encoding Crankshaft config: %v", err)
 	}
 
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, c.filePath); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(c.filePath))
+
 	return nil
 }
 
-func (c *CrksftConfig) UpdatePlugin(pluginId string, newConfig CrksftConfigPlugin) error {
-	plugin, ok := c.Plugins[pluginId]
-	if !ok {
-		// Create a default plugin config
-		plugin = CrksftConfigPlugin{}
+// syncDir flushes the directory so a rename in it survives a crash. Not every
+// platform supports syncing directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
 	}
-	plugin.Enabled = newConfig.Enabled
-	c.Plugins[pluginId] = plugin
+	d.Sync()
+	d.Close()
+}
+
+// UpdatePlugin updates the config for the given plugin with the update
+// function and writes the config.
+func (c *CrksftConfig) UpdatePlugin(pluginId string, update func(plugin *CrksftConfigPlugin)) error {
+	return c.Update(func(c *CrksftConfig) {
+		// A missing plugin gets the default plugin config
+		plugin := c.Plugins[pluginId]
+		update(&plugin)
+		c.Plugins[pluginId] = plugin
+	})
+}
+
+// Update changes the config with the update function and writes it, for
+// changes that aren't limited to one plugin. The update is made to a copy of
+// the config, which replaces it once it's written, so the config is left as it
+// was if writing fails.
+func (c *CrksftConfig) Update(update func(c *CrksftConfig)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if err := c.Write(); err != nil {
+	updated := c.clone()
+	update(updated)
+
+	if err := updated.write(); err != nil {
 		return fmt.Errorf("Error writing updated Crankshaft config: %v", err)
 	}
+	c.replaceFields(updated)
 
 	return nil
 }
+
+// GetPlugin returns the config for the given plugin, or the default config if
+// it doesn't have one yet.
+func (c *CrksftConfig) GetPlugin(pluginId string) CrksftConfigPlugin {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Plugins[pluginId].clone()
+}
+
+// GetProfiles returns the saved profiles by name, and the name of the active
+// profile.
+func (c *CrksftConfig) GetProfiles() (map[string]CrksftConfigProfile, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	profiles := make(map[string]CrksftConfigProfile, len(c.Profiles))
+	for name, profile := range c.Profiles {
+		profiles[name] = profile.clone()
+	}
+	return profiles, c.ActiveProfile
+}
+
+// GetTrustedKeys returns the keys the user added that are trusted to sign
+// plugin archives.
+func (c *CrksftConfig) GetTrustedKeys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.TrustedKeys...)
+}
+
+// GetRepositories returns the configured plugin repositories. If there aren't
+// any, the registry set by RegistryUrl is the only repository.
+func (c *CrksftConfig) GetRepositories() []CrksftConfigRepository {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.Repositories) > 0 {
+		return append([]CrksftConfigRepository{}, c.Repositories...)
+	}
+
+	registryUrl := c.RegistryUrl
+	if registryUrl == "" {
+		registryUrl = DefaultRegistryUrl
+	}
+	return []CrksftConfigRepository{{Name: DefaultRepositoryName, Url: registryUrl}}
+}
+
+// GetUpdateCheckInterval returns how often to check for plugin updates. Zero
+// means background checks are disabled.
+func (c *CrksftConfig) GetUpdateCheckInterval() (time.Duration, error) {
+	c.mu.Lock()
+	value := c.UpdateCheckInterval
+	c.mu.Unlock()
+
+	if value == "" {
+		value = defaultUpdateCheckInterval
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid update-check-interval in Crankshaft config: %v", err)
+	}
+
+	return interval, nil
+}
+
+// GetKeepPluginBackups returns the number of previous versions to keep for
+// each plugin.
+func (c *CrksftConfig) GetKeepPluginBackups() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.keepPluginBackups()
+}
+
+func (c *CrksftConfig) keepPluginBackups() int {
+	if c.KeepPluginBackups <= 0 {
+		return DefaultKeepPluginBackups
+	}
+	return c.KeepPluginBackups
+}
+
+// GetStoreQuota returns the size limit in bytes of the plugin's store
+// namespace, or of the shared namespace if pluginId is empty. Zero means
+// there's no limit.
+func (c *CrksftConfig) GetStoreQuota(pluginId string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pluginId == "" {
+		return c.SharedStoreQuota
+	}
+
+	if quota := c.Plugins[pluginId].StoreQuota; quota > 0 {
+		return quota
+	}
+	return c.StoreQuota
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMigrateUnversionedConfig(t *testing.T) {
+	dataDir := t.TempDir()
+	configPath := filepath.Join(dataDir, "config.toml")
+	original := `InstalledAutostart = true
+watch-plugins = true
+
+[plugins.example]
+enabled = true
+`
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	crksftConfig, found, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatalf("NewCrksftConfig returned error: %v", err)
+	}
+	if !found {
+		t.Fatal("Expected config to be found")
+	}
+	if crksftConfig.Version != configVersion || !crksftConfig.InstalledAutostart || !crksftConfig.WatchPlugins || !crksftConfig.Plugins["example"].Enabled {
+		t.Fatalf("Config wasn't migrated: %+v", crksftConfig)
+	}
+
+	backup, err := os.ReadFile(configPath + ".v0.bak")
+	if err != nil {
+		t.Fatalf("Expected a backup of the unmigrated config: %v", err)
+	}
+	if string(backup) != original {
+		t.Fatalf(`Expected backup to contain "%s", got "%s"`, original, backup)
+	}
+
+	migrated, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(migrated), "version = 1") || !strings.Contains(string(migrated), "InstalledAutostart = true") {
+		t.Fatalf("Expected migrated config to be written, got:\n%s", migrated)
+	}
+
+	// The migrated config loads without migrating again
+	if err := os.Remove(configPath + ".v0.bak"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewCrksftConfig(dataDir); err != nil {
+		t.Fatalf("NewCrksftConfig returned error: %v", err)
+	}
+	if _, err := os.Stat(configPath + ".v0.bak"); err == nil {
+		t.Fatal("Expected migrated config not to be backed up again")
+	}
+}
+
+func TestNewerConfigVersion(t *testing.T) {
+	dataDir := t.TempDir()
+	configPath := filepath.Join(dataDir, "config.toml")
+	contents := fmt.Sprintf("version = %d\n", configVersion+1)
+	if err := os.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := NewCrksftConfig(dataDir); err == nil {
+		t.Fatal("NewCrksftConfig expected error, got nil")
+	}
+
+	// The newer config is left alone
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != contents {
+		t.Fatalf(`Expected config to still contain "%s", got "%s"`, contents, data)
+	}
+}
+
+func TestConcurrentUpdatePlugin(t *testing.T) {
+	dataDir := t.TempDir()
+	crksftConfig, _, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := crksftConfig.UpdatePlugin(fmt.Sprintf("plugin-%d", i), func(plugin *CrksftConfigPlugin) {
+				plugin.Enabled = true
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	written, _, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatalf("Error reading written config: %v", err)
+	}
+	if len(written.Plugins) != 20 {
+		t.Fatalf("Expected 20 plugins in written config, got %d", len(written.Plugins))
+	}
+
+	// Only config.toml is left, without temporary files
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only config.toml in data directory, got %d entries", len(entries))
+	}
+
+	info, err := os.Stat(filepath.Join(dataDir, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected config mode 0644, got %v", info.Mode().Perm())
+	}
+}
+
+func TestUpdateFailedWrite(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "data")
+	crksftConfig, _, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data directory doesn't exist, so writing fails
+	err = crksftConfig.UpdatePlugin("example", func(plugin *CrksftConfigPlugin) {
+		plugin.EnabledModes = map[string]bool{"deck": true}
+	})
+	if err == nil {
+		t.Fatal("UpdatePlugin expected error, got nil")
+	}
+	if _, ok := crksftConfig.Plugins["example"]; ok {
+		t.Fatal("Expected the config to be left as it was after a failed write")
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := crksftConfig.UpdatePlugin("example", func(plugin *CrksftConfigPlugin) {
+		plugin.Enabled = true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !crksftConfig.GetPlugin("example").Enabled {
+		t.Fatal("Expected the update to be applied once it was written")
+	}
+}
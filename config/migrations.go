@@ -0,0 +1,91 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Version of the Crankshaft config format. Configs with an older version are
+// migrated when they're loaded, configs with a newer version can't be loaded.
+const configVersion = 1
+
+// A migration upgrades the decoded config from one version to the next.
+type migration func(data map[string]interface{}) error
+
+// Migrations by the version they upgrade from, so migrations[0] upgrades
+// configs written before the config had a version to version 1
+var migrations = []migration{
+	migrateToV1,
+}
+
+// migrateToV1 doesn't change anything, version 1 only adds the version itself.
+func migrateToV1(data map[string]interface{}) error {
+	return nil
+}
+
+// configFileVersion returns the version of the decoded config, 0 if it doesn't
+// have one.
+func configFileVersion(data map[string]interface{}) (int, error) {
+	value, ok := data["version"]
+	if !ok {
+		return 0, nil
+	}
+
+	version, ok := value.(int64)
+	if !ok || version < 0 {
+		return 0, fmt.Errorf(`Invalid version "%v" in Crankshaft config`, value)
+	}
+	return int(version), nil
+}
+
+// migrateConfig upgrades the contents of a config to the current version. It
+// returns the upgraded contents and the version they were upgraded from, which
+// is configVersion if they didn't need to be migrated.
+func migrateConfig(contents []byte) ([]byte, int, error) {
+	data := make(map[string]interface{})
+	if _, err := toml.Decode(string(contents), &data); err != nil {
+		return nil, 0, fmt.Errorf("Error decoding Crankshaft config: %v", err)
+	}
+
+	fromVersion, err := configFileVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fromVersion > configVersion {
+		return nil, 0, fmt.Errorf("Crankshaft config has version %d, which is newer than this version of Crankshaft supports (%d)", fromVersion, configVersion)
+	}
+	if fromVersion == configVersion {
+		return contents, fromVersion, nil
+	}
+
+	for version := fromVersion; version < configVersion; version++ {
+		if err := migrations[version](data); err != nil {
+			return nil, 0, fmt.Errorf("Error migrating Crankshaft config to version %d: %v", version+1, err)
+		}
+	}
+	data["version"] = configVersion
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, 0, fmt.Errorf("Error encoding migrated Crankshaft config: %v", err)
+	}
+
+	return buf.Bytes(), fromVersion, nil
+}
+
+// backupConfig copies the contents of the config at filePath to
+// config.toml.v<version>.bak next to it, before it's overwritten by the
+// migrated config.
+func backupConfig(filePath string, version int, contents []byte) error {
+	backupPath := fmt.Sprintf("%s.v%d.bak", filePath, version)
+	if err := os.WriteFile(backupPath, contents, 0644); err != nil {
+		return fmt.Errorf("Error backing up Crankshaft config before migrating it: %v", err)
+	}
+	log.Printf("Migrating Crankshaft config from version %d to %d, backed up to \"%s\"\n", version, configVersion, backupPath)
+
+	return nil
+}
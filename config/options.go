@@ -0,0 +1,322 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/pathutil"
+)
+
+// Source is where an option's value came from.
+type Source string
+
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceFile    Source = "file"
+	SourceDefault Source = "default"
+)
+
+// Prefix of the environment variables options can be set with
+const envPrefix = "CRANKSHAFT_"
+
+// Options are the settings Crankshaft runs with.
+type Options struct {
+	DebugPort    string
+	ServerPort   string
+	SkipPatching bool
+	DataDir      string
+	PluginsDir   string
+	LogsDir      string
+	CacheDir     string
+	SteamPath    string
+	Cleanup      bool
+	NoCache      bool
+	WatchPlugins bool
+	SafeMode     bool
+
+	values []OptionValue
+}
+
+// OptionValue is an option's effective value, and where it came from.
+type OptionValue struct {
+	Name   string
+	Value  string
+	Source Source
+	// Env is the environment variable the option can be set with, empty if it
+	// can't be set with one
+	Env string
+}
+
+// Values returns every option's effective value in the order they're
+// declared.
+func (o *Options) Values() []OptionValue {
+	return append([]OptionValue{}, o.values...)
+}
+
+type option struct {
+	name   string
+	usage  string
+	isBool bool
+	// Returns the value used if the option isn't set anywhere else
+	defaultValue func() string
+	// Returns the value set in the Crankshaft config, or an empty string if
+	// it isn't set. It's nil for options that can't be set there.
+	fileValue func(c *CrksftConfig) string
+	// The option can't be set with an environment variable
+	flagOnly bool
+	// The value is a path that can start with ~
+	isPath bool
+	// Sets the option's field
+	set func(o *Options, value string)
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+// Crankshaft's options. The data directory can't be set in the Crankshaft
+// config, since that's where the config is read from, and cleaning up is an
+// action rather than a setting, so it can only be passed as a flag.
+var options = []option{
+	{
+		name:         "debug-port",
+		usage:        "CEF debug port",
+		defaultValue: func() string { return "8080" },
+		fileValue:    func(c *CrksftConfig) string { return c.DebugPort },
+		set:          func(o *Options, value string) { o.DebugPort = value },
+	},
+	{
+		name:         "server-port",
+		usage:        "Port to run HTTP/websocket server on",
+		defaultValue: func() string { return "8085" },
+		fileValue:    func(c *CrksftConfig) string { return c.ServerPort },
+		set:          func(o *Options, value string) { o.ServerPort = value },
+	},
+	{
+		name:         "skip-patching",
+		usage:        "Skip patching Steam client resources",
+		isBool:       true,
+		defaultValue: func() string { return "false" },
+		fileValue:    func(c *CrksftConfig) string { return boolString(c.SkipPatching) },
+		set:          func(o *Options, value string) { o.SkipPatching = value == "true" },
+	},
+	{
+		name:         "data-dir",
+		usage:        "Crankshaft data directory",
+		defaultValue: DefaultDataDir,
+		isPath:       true,
+		set:          func(o *Options, value string) { o.DataDir = value },
+	},
+	{
+		name:         "plugins-dir",
+		usage:        "Directory to load plugins from",
+		defaultValue: DefaultPluginsDir,
+		fileValue:    func(c *CrksftConfig) string { return c.PluginsDir },
+		isPath:       true,
+		set:          func(o *Options, value string) { o.PluginsDir = value },
+	},
+	{
+		name:         "logs-dir",
+		usage:        "Directory to write logs to",
+		defaultValue: func() string { return filepath.Join(GetXdgStateHome(), "crankshaft", "logs") },
+		fileValue:    func(c *CrksftConfig) string { return c.LogsDir },
+		isPath:       true,
+		set:          func(o *Options, value string) { o.LogsDir = value },
+	},
+	{
+		name:         "cache-dir",
+		usage:        "Directory to use for caching files",
+		defaultValue: func() string { return filepath.Join(GetXdgCacheHome(), "crankshaft") },
+		fileValue:    func(c *CrksftConfig) string { return c.CacheDir },
+		isPath:       true,
+		set:          func(o *Options, value string) { o.CacheDir = value },
+	},
+	{
+		name:         "steam-path",
+		usage:        "Path to Steam files",
+		defaultValue: getDefaultSteamPath,
+		fileValue:    func(c *CrksftConfig) string { return c.SteamPath },
+		isPath:       true,
+		set:          func(o *Options, value string) { o.SteamPath = value },
+	},
+	{
+		name:         "cleanup",
+		usage:        "Cleanup patched files and exit",
+		isBool:       true,
+		defaultValue: func() string { return "false" },
+		flagOnly:     true,
+		set:          func(o *Options, value string) { o.Cleanup = value == "true" },
+	},
+	{
+		name:         "no-cache",
+		usage:        "Disable caching",
+		isBool:       true,
+		defaultValue: func() string { return "false" },
+		fileValue:    func(c *CrksftConfig) string { return boolString(c.NoCache) },
+		set:          func(o *Options, value string) { o.NoCache = value == "true" },
+	},
+	{
+		name:         "watch-plugins",
+		usage:        "Rebuild and reinject plugins when their files change",
+		isBool:       true,
+		defaultValue: func() string { return "false" },
+		fileValue:    func(c *CrksftConfig) string { return boolString(c.WatchPlugins) },
+		set:          func(o *Options, value string) { o.WatchPlugins = value == "true" },
+	},
+	{
+		name:         "safe-mode",
+		usage:        "Start without injecting plugins",
+		isBool:       true,
+		defaultValue: func() string { return "false" },
+		fileValue:    func(c *CrksftConfig) string { return boolString(c.SafeMode) },
+		set:          func(o *Options, value string) { o.SafeMode = value == "true" },
+	},
+}
+
+// findOption returns the option with the given name, which must exist.
+func findOption(name string) option {
+	for _, opt := range options {
+		if opt.name == name {
+			return opt
+		}
+	}
+	panic("Unknown option " + name)
+}
+
+// envName returns the environment variable the option can be set with.
+func (opt option) envName() string {
+	if opt.flagOnly {
+		return ""
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(opt.name, "-", "_"))
+}
+
+// OptionFlags are command line flags for Crankshaft's options.
+type OptionFlags struct {
+	flags *flag.FlagSet
+	// Values of the flags by option name, *string or *bool
+	values map[string]interface{}
+}
+
+// AddOptionFlags adds flags for the named options to the flag set, or for
+// every option if no names are given. Options without a flag can still be set
+// with environment variables and the Crankshaft config.
+func AddOptionFlags(flags *flag.FlagSet, names ...string) *OptionFlags {
+	optionFlags := &OptionFlags{flags, make(map[string]interface{})}
+
+	for _, opt := range options {
+		if len(names) > 0 && !containsName(names, opt.name) {
+			continue
+		}
+
+		usage := opt.usage
+		if env := opt.envName(); env != "" {
+			usage += fmt.Sprintf(" (env %s)", env)
+		}
+		if opt.isBool {
+			optionFlags.values[opt.name] = flags.Bool(opt.name, opt.defaultValue() == "true", usage)
+		} else {
+			optionFlags.values[opt.name] = flags.String(opt.name, opt.defaultValue(), usage)
+		}
+	}
+
+	return optionFlags
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value of the option's flag, and if it was passed.
+func (f *OptionFlags) flagValue(name string) (string, bool) {
+	passed := false
+	f.flags.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			passed = true
+		}
+	})
+	if !passed {
+		return "", false
+	}
+
+	switch value := f.values[name].(type) {
+	case *bool:
+		return strconv.FormatBool(*value), true
+	case *string:
+		return *value, true
+	}
+	return "", false
+}
+
+// Resolve returns the options once the flags have been parsed. Each option is
+// taken from its flag, then its CRANKSHAFT_* environment variable, then the
+// Crankshaft config in the data directory, then its default.
+func (f *OptionFlags) Resolve() (*Options, error) {
+	// The data directory is resolved first, since the config is read from it
+	dataDir, err := f.resolve(findOption("data-dir"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The config is only read here, migrating it is left to whatever uses it,
+	// since commands like `config show` shouldn't change it
+	crksftConfig, _, err := loadConfig(filepath.Join(dataDir.Value, "config.toml"), false)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &Options{}
+	for _, opt := range options {
+		value, err := f.resolve(opt, crksftConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		opt.set(opts, value.Value)
+		opts.values = append(opts.values, value)
+	}
+
+	return opts, nil
+}
+
+// resolve returns the option's effective value. crksftConfig can be nil if the
+// config hasn't been read yet.
+func (f *OptionFlags) resolve(opt option, crksftConfig *CrksftConfig) (OptionValue, error) {
+	value := OptionValue{Name: opt.name, Env: opt.envName()}
+
+	if flagValue, ok := f.flagValue(opt.name); ok {
+		value.Value, value.Source = flagValue, SourceFlag
+	} else if envValue := os.Getenv(value.Env); value.Env != "" && envValue != "" {
+		value.Value, value.Source = envValue, SourceEnv
+	} else if opt.fileValue != nil && crksftConfig != nil && opt.fileValue(crksftConfig) != "" {
+		value.Value, value.Source = opt.fileValue(crksftConfig), SourceFile
+	} else {
+		value.Value, value.Source = opt.defaultValue(), SourceDefault
+	}
+
+	if opt.isBool {
+		b, err := strconv.ParseBool(value.Value)
+		if err != nil {
+			return value, fmt.Errorf(`Invalid value "%s" for %s from %s, expected true or false`, value.Value, opt.name, value.Source)
+		}
+		value.Value = strconv.FormatBool(b)
+	}
+	if opt.isPath {
+		value.Value = pathutil.SubstituteHomeDir(value.Value)
+	}
+
+	return value, nil
+}
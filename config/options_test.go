@@ -0,0 +1,125 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func findValue(t *testing.T, opts *Options, name string) OptionValue {
+	for _, value := range opts.Values() {
+		if value.Name == name {
+			return value
+		}
+	}
+	t.Fatalf(`Option "%s" wasn't resolved`, name)
+	return OptionValue{}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	dataDir := t.TempDir()
+	configToml := `debug-port = "9000"
+server-port = "9001"
+logs-dir = "/file/logs"
+no-cache = true
+`
+	if err := os.WriteFile(filepath.Join(dataDir, "config.toml"), []byte(configToml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CRANKSHAFT_DATA_DIR", dataDir)
+	t.Setenv("CRANKSHAFT_SERVER_PORT", "9002")
+	t.Setenv("CRANKSHAFT_DEBUG_PORT", "9003")
+	t.Setenv("CRANKSHAFT_CACHE_DIR", "")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	optionFlags := AddOptionFlags(flags)
+	if err := flags.Parse([]string{"-debug-port", "9004"}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		source Source
+	}{
+		{"data-dir", dataDir, SourceEnv},
+		{"debug-port", "9004", SourceFlag},
+		{"server-port", "9002", SourceEnv},
+		{"logs-dir", "/file/logs", SourceFile},
+		{"no-cache", "true", SourceFile},
+		{"safe-mode", "false", SourceDefault},
+	}
+	for _, test := range tests {
+		value := findValue(t, opts, test.name)
+		if value.Value != test.value || value.Source != test.source {
+			t.Errorf(`Expected %s to be "%s" from %s, got "%s" from %s`, test.name, test.value, test.source, value.Value, value.Source)
+		}
+	}
+
+	// An empty environment variable is treated as unset
+	if value := findValue(t, opts, "cache-dir"); value.Source != SourceDefault {
+		t.Errorf("Expected cache-dir from default, got %s", value.Source)
+	}
+
+	if opts.DebugPort != "9004" || opts.ServerPort != "9002" || !opts.NoCache || opts.SafeMode {
+		t.Errorf("Options fields don't match their values: %+v", opts)
+	}
+}
+
+func TestResolveInvalidBool(t *testing.T) {
+	t.Setenv("CRANKSHAFT_DATA_DIR", t.TempDir())
+	t.Setenv("CRANKSHAFT_SAFE_MODE", "sometimes")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	optionFlags := AddOptionFlags(flags, "safe-mode")
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := optionFlags.Resolve(); err == nil {
+		t.Fatal("Resolve expected error, got nil")
+	}
+}
+
+func TestResolveLeavesConfigAlone(t *testing.T) {
+	dataDir := t.TempDir()
+	configPath := filepath.Join(dataDir, "config.toml")
+	original := "server-port = \"9001\"\n"
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CRANKSHAFT_DATA_DIR", dataDir)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	optionFlags := AddOptionFlags(flags)
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := optionFlags.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+	if opts.ServerPort != "9001" {
+		t.Errorf(`Expected server-port "9001" from the unmigrated config, got "%s"`, opts.ServerPort)
+	}
+
+	// The unversioned config is read, but only migrated once Crankshaft loads it
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Fatalf("Expected config to be left alone, got:\n%s", data)
+	}
+	if _, err := os.Stat(configPath + ".v0.bak"); err == nil {
+		t.Fatal("Expected config not to be backed up")
+	}
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// SettingType is the type of value a Crankshaft setting holds.
+type SettingType string
+
+const (
+	SettingBoolean SettingType = "boolean"
+	// Numbers are whole numbers of at least zero
+	SettingNumber SettingType = "number"
+	// Durations are strings like "12h"
+	SettingDuration   SettingType = "duration"
+	SettingStringList SettingType = "string-list"
+)
+
+// DefaultKeepPluginBackups is the number of previous versions kept for each
+// plugin if the config doesn't set one.
+const DefaultKeepPluginBackups = 3
+
+// Setting is a Crankshaft setting that can be read and changed at runtime,
+// along with its current value.
+type Setting struct {
+	Key         string      `json:"key"`
+	Type        SettingType `json:"type"`
+	Label       string      `json:"label"`
+	Description string      `json:"description,omitempty"`
+	Value       interface{} `json:"value"`
+	// The setting only takes effect once Crankshaft restarts
+	RequiresRestart bool `json:"requiresRestart"`
+}
+
+// SettingError is returned when setting a value that isn't valid for the
+// setting.
+type SettingError struct {
+	Key    string
+	Reason string
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf(`Invalid value for setting "%s": %s`, e.Key, e.Reason)
+}
+
+type settingSpec struct {
+	key             string
+	settingType     SettingType
+	label           string
+	description     string
+	requiresRestart bool
+	get             func(c *CrksftConfig) interface{}
+	// Sets the setting to a value that's already been normalized
+	set func(c *CrksftConfig, value interface{})
+	// Checks a normalized value beyond its type, optional
+	validate func(value interface{}) error
+}
+
+// Crankshaft's settings. Settings without RequiresRestart are read each time
+// they're used, so changes apply immediately.
+var settingSpecs = []settingSpec{
+	{
+		key:             "update-check-interval",
+		settingType:     SettingDuration,
+		label:           "Update check interval",
+		description:     `How often to check for plugin updates, like "12h". "0" disables background checks.`,
+		requiresRestart: true,
+		get:             func(c *CrksftConfig) interface{} { return c.UpdateCheckInterval },
+		set:             func(c *CrksftConfig, value interface{}) { c.UpdateCheckInterval = value.(string) },
+	},
+	{
+		key:         "keep-plugin-backups",
+		settingType: SettingNumber,
+		label:       "Plugin backups",
+		description: "Number of previous versions to keep for each plugin",
+		get:         func(c *CrksftConfig) interface{} { return int64(c.keepPluginBackups()) },
+		set:         func(c *CrksftConfig, value interface{}) { c.KeepPluginBackups = int(value.(int64)) },
+		validate: func(value interface{}) error {
+			if value.(int64) < 1 {
+				return errors.New("must be at least 1")
+			}
+			if value.(int64) > math.MaxInt32 {
+				return fmt.Errorf("must be at most %d", math.MaxInt32)
+			}
+			return nil
+		},
+	},
+	{
+		key:         "store-quota",
+		settingType: SettingNumber,
+		label:       "Plugin storage limit",
+		description: "Size limit in bytes of each plugin's stored data, 0 disables the limit",
+		get:         func(c *CrksftConfig) interface{} { return c.StoreQuota },
+		set:         func(c *CrksftConfig, value interface{}) { c.StoreQuota = value.(int64) },
+	},
+	{
+		key:         "shared-store-quota",
+		settingType: SettingNumber,
+		label:       "Shared storage limit",
+		description: "Size limit in bytes of the data plugins share, 0 disables the limit",
+		get:         func(c *CrksftConfig) interface{} { return c.SharedStoreQuota },
+		set:         func(c *CrksftConfig, value interface{}) { c.SharedStoreQuota = value.(int64) },
+	},
+	{
+		key:         "trusted-keys",
+		settingType: SettingStringList,
+		label:       "Trusted keys",
+		description: "Base64 encoded ed25519 public keys of plugin publishers to trust",
+		get:         func(c *CrksftConfig) interface{} { return append([]string{}, c.TrustedKeys...) },
+		set:         func(c *CrksftConfig, value interface{}) { c.TrustedKeys = value.([]string) },
+		validate: func(value interface{}) error {
+			for _, key := range value.([]string) {
+				decoded, err := base64.StdEncoding.DecodeString(key)
+				if err != nil || len(decoded) != ed25519.PublicKeySize {
+					return fmt.Errorf(`"%s" isn't a base64 encoded ed25519 public key`, key)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		key:             "watch-plugins",
+		settingType:     SettingBoolean,
+		label:           "Watch plugins",
+		description:     "Rebuild and reinject plugins when their files change",
+		requiresRestart: true,
+		get:             func(c *CrksftConfig) interface{} { return c.WatchPlugins },
+		set:             func(c *CrksftConfig, value interface{}) { c.WatchPlugins = value.(bool) },
+	},
+}
+
+// normalize checks that value has the setting's type, and returns it as the
+// type used for the setting's values. Numbers are int64 and string lists are
+// []string, since they're decoded from JSON as float64 and []interface{}.
+func (s settingSpec) normalize(value interface{}) (interface{}, error) {
+	var normalized interface{}
+
+	switch s.settingType {
+	case SettingBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("expected a boolean")
+		}
+		normalized = b
+
+	case SettingNumber:
+		var n int64
+		switch v := value.(type) {
+		case float64:
+			if v != math.Trunc(v) || v < 0 {
+				return nil, errors.New("expected a whole number of at least 0")
+			}
+			// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit
+			if v >= math.MaxInt64 {
+				return nil, errors.New("expected a number that fits in 64 bits")
+			}
+			n = int64(v)
+		case int64:
+			n = v
+		case int:
+			n = int64(v)
+		default:
+			return nil, errors.New("expected a number")
+		}
+		if n < 0 {
+			return nil, errors.New("expected a whole number of at least 0")
+		}
+		normalized = n
+
+	case SettingDuration:
+		str, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a duration string")
+		}
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			return nil, errors.New("must not be negative")
+		}
+		normalized = str
+
+	case SettingStringList:
+		var list []string
+		switch v := value.(type) {
+		case []string:
+			list = append([]string{}, v...)
+		case []interface{}:
+			list = make([]string, 0, len(v))
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return nil, errors.New("expected a list of strings")
+				}
+				list = append(list, str)
+			}
+		default:
+			return nil, errors.New("expected a list of strings")
+		}
+		normalized = list
+	}
+
+	if s.validate != nil {
+		if err := s.validate(normalized); err != nil {
+			return nil, err
+		}
+	}
+
+	return normalized, nil
+}
+
+func findSettingSpec(key string) (settingSpec, bool) {
+	for _, spec := range settingSpecs {
+		if spec.key == key {
+			return spec, true
+		}
+	}
+	return settingSpec{}, false
+}
+
+// Settings returns Crankshaft's settings with their current values.
+func (c *CrksftConfig) Settings() []Setting {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.settings()
+}
+
+func (c *CrksftConfig) settings() []Setting {
+	settings := make([]Setting, 0, len(settingSpecs))
+	for _, spec := range settingSpecs {
+		settings = append(settings, Setting{
+			Key:             spec.key,
+			Type:            spec.settingType,
+			Label:           spec.label,
+			Description:     spec.description,
+			Value:           spec.get(c),
+			RequiresRestart: spec.requiresRestart,
+		})
+	}
+	return settings
+}
+
+// SetSettings changes the settings with the given keys and writes the config.
+// If any value is invalid, a *SettingError is returned and nothing is changed.
+// It returns the keys of the settings whose values changed.
+func (c *CrksftConfig) SetSettings(values map[string]interface{}) ([]string, error) {
+	normalized := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		spec, ok := findSettingSpec(key)
+		if !ok {
+			return nil, &SettingError{key, "no such setting"}
+		}
+		n, err := spec.normalize(value)
+		if err != nil {
+			return nil, &SettingError{key, err.Error()}
+		}
+		normalized[key] = n
+	}
+
+	var changed []string
+	err := c.Update(func(c *CrksftConfig) {
+		before := c.settings()
+		for _, spec := range settingSpecs {
+			if value, ok := normalized[spec.key]; ok {
+				spec.set(c, value)
+			}
+		}
+		changed = changedSettings(before, c.settings())
+	})
+
+	return changed, err
+}
+
+// changedSettings returns the keys of the settings whose values differ.
+func changedSettings(before, after []Setting) []string {
+	changed := []string{}
+	for i := range before {
+		if !reflect.DeepEqual(before[i].Value, after[i].Value) {
+			changed = append(changed, before[i].Key)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSettingsNormalize(t *testing.T) {
+	tests := []struct {
+		key   string
+		value interface{}
+		valid bool
+	}{
+		{"update-check-interval", "12h", true},
+		{"update-check-interval", "often", false},
+		{"update-check-interval", "-1h", false},
+		{"keep-plugin-backups", float64(2), true},
+		{"keep-plugin-backups", float64(0), false},
+		{"keep-plugin-backups", 1.5, false},
+		{"keep-plugin-backups", float64(math.MaxInt32), true},
+		{"keep-plugin-backups", float64(math.MaxInt32 + 1), false},
+		{"store-quota", float64(0), true},
+		{"store-quota", float64(-1), false},
+		{"store-quota", float64(8 << 30), true},
+		{"store-quota", float64(math.MaxInt64), false},
+		{"store-quota", "1024", false},
+		{"trusted-keys", []interface{}{"11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, true},
+		{"trusted-keys", []interface{}{"not a key"}, false},
+		{"trusted-keys", []interface{}{1}, false},
+		{"watch-plugins", true, true},
+		{"watch-plugins", "true", false},
+	}
+
+	for _, test := range tests {
+		spec, ok := findSettingSpec(test.key)
+		if !ok {
+			t.Fatalf(`Setting "%s" not found`, test.key)
+		}
+		_, err := spec.normalize(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("normalize(%v) for %s: expected valid %v, got error %v", test.value, test.key, test.valid, err)
+		}
+	}
+}
+
+func TestSetSettings(t *testing.T) {
+	dataDir := t.TempDir()
+	crksftConfig, _, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := crksftConfig.SetSettings(map[string]interface{}{
+		"keep-plugin-backups": float64(5),
+		"trusted-keys":        []interface{}{"11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		// Unchanged
+		"watch-plugins": false,
+	})
+	if err != nil {
+		t.Fatalf("SetSettings returned error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"keep-plugin-backups", "trusted-keys"}) {
+		t.Fatalf("Expected keep-plugin-backups and trusted-keys to change, got %v", changed)
+	}
+
+	if _, err := crksftConfig.SetSettings(map[string]interface{}{"unknown": true}); err == nil {
+		t.Fatal("SetSettings expected error for unknown setting, got nil")
+	}
+
+	// The settings were written
+	written, _, err := NewCrksftConfig(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.GetKeepPluginBackups() != 5 || len(written.TrustedKeys) != 1 {
+		t.Fatalf("Expected settings to be written, got %+v", written)
+	}
+
+	// Reloading the written config doesn't change anything
+	changed, err = crksftConfig.Reload()
+	if err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("Expected no changes reloading the written config, got %v", changed)
+	}
+}
@@ -1,6 +1,7 @@
 package executil
 
 import (
+	"os"
 	"os/exec"
 
 	"git.sr.ht/~avery/crankshaft/tags"
@@ -30,3 +31,26 @@ func Command(name string, args ...string) *exec.Cmd {
 
 	return exec.Command(name, args...)
 }
+
+// CommandIn is like Command, but runs the command in dir with env added to
+// its environment. env is a list of "KEY=value" strings.
+func CommandIn(dir string, env []string, name string, args ...string) *exec.Cmd {
+	if tags.Flatpak {
+		cmdArgs := []string{
+			"--host",
+			"--env=DISPLAY=" + getDisplay(),
+			"--directory=" + dir,
+		}
+		for _, variable := range env {
+			cmdArgs = append(cmdArgs, "--env="+variable)
+		}
+		cmdArgs = append(cmdArgs, name)
+		cmdArgs = append(cmdArgs, args...)
+		return exec.Command("flatpak-spawn", cmdArgs...)
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
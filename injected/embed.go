@@ -0,0 +1,13 @@
+// Package injected embeds the parts of the injected scripts that Crankshaft
+// needs at run time, when it isn't running from the repository.
+package injected
+
+import (
+	_ "embed"
+)
+
+// PreactShim is injected into builds that use JSX, so they can use Preact's
+// h and Fragment without importing them.
+//
+//go:embed preact-shim.js
+var PreactShim string
@@ -1,5 +1,6 @@
 import { SHARED_SELECTORS } from '../selectors';
-import { SMM } from '../smm';
+import { rpcRequest } from '../rpc';
+import { exposeOnWindow, SMM } from '../smm';
 import { AppPropsApp } from '../types/global';
 import { info, waitForElement } from '../util';
 
@@ -12,11 +13,8 @@ const main = async () => {
 
   waitForElement(SHARED_SELECTORS.appProperties);
 
-  const smm = new SMM('appProperties');
-  if (window.smm) {
-    delete window.smm;
-  }
-  window.smm = smm;
+  const smm = new SMM('appProperties', rpcRequest);
+  exposeOnWindow(smm);
 
   await smm.loadPlugins();
 
@@ -1,5 +1,6 @@
 import { MENU_DESKTOP_SELECTORS } from '../menu-manager/selectors';
-import { SMM } from '../smm';
+import { rpcRequest } from '../rpc';
+import { exposeOnWindow, SMM } from '../smm';
 import { info, waitForElement } from '../util';
 
 const main = async () => {
@@ -11,11 +12,8 @@ const main = async () => {
 
   await waitForElement(MENU_DESKTOP_SELECTORS.keyboardContainer);
 
-  const smm = new SMM('keyboard');
-  if (window.smm) {
-    delete window.smm;
-  }
-  window.smm = smm;
+  const smm = new SMM('keyboard', rpcRequest);
+  exposeOnWindow(smm);
 
   await smm.loadPlugins();
 };
@@ -5,7 +5,8 @@ import {
 } from '../internal-plugins';
 import { MENU_DESKTOP_SELECTORS } from '../menu-manager/selectors';
 import { getSelectorByMode } from '../selectors';
-import { SMM } from '../smm';
+import { rpcRequest } from '../rpc';
+import { exposeOnWindow, SMM } from '../smm';
 import { createTabObserver } from '../tab-observer';
 import { info, waitForElement } from '../util';
 
@@ -18,7 +19,7 @@ const main = async () => {
 
   let smm: SMM;
   try {
-    smm = new SMM('library');
+    smm = new SMM('library', rpcRequest);
   } catch (err) {
     info('Failed to initialize SMM, waiting for focus and retrying...');
     if (!document.hasFocus()) {
@@ -31,7 +32,7 @@ const main = async () => {
       });
     }
     try {
-      smm = new SMM('library');
+      smm = new SMM('library', rpcRequest);
     } catch (err) {
       throw new Error(`Error initializing SMM after retry: ${err}`);
     }
@@ -39,10 +40,7 @@ const main = async () => {
 
   info('Successfully initialized SMM');
 
-  if (window.smm) {
-    delete window.smm;
-  }
-  window.smm = smm;
+  exposeOnWindow(smm);
 
   const mainLibraryEl = await waitForElement<HTMLDivElement>(
     getSelectorByMode('mainLibrary')
@@ -58,6 +56,7 @@ const main = async () => {
   loadPluginBrowser(smm);
 
   await smm.loadPlugins();
+  await smm.SafeMode.showBanner();
 
   if (window.smmUIMode === 'deck') {
     document.addEventListener('keydown', (event) => {
@@ -1,6 +1,7 @@
 import { enableDeckMenuScroll } from '../menu-manager/deck-menu-enable-scroll';
 import { MENU_DECK_SELECTORS } from '../menu-manager/selectors';
-import { SMM } from '../smm';
+import { rpcRequest } from '../rpc';
+import { exposeOnWindow, SMM } from '../smm';
 import { info, waitForElement } from '../util';
 
 const main = async () => {
@@ -12,11 +13,8 @@ const main = async () => {
 
   waitForElement(MENU_DECK_SELECTORS.menuContainer);
 
-  const smm = new SMM('menu');
-  if (window.smm) {
-    delete window.smm;
-  }
-  window.smm = smm;
+  const smm = new SMM('menu', rpcRequest);
+  exposeOnWindow(smm);
 
   enableDeckMenuScroll();
 
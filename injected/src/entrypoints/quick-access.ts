@@ -1,5 +1,6 @@
 import { DECK_SELECTORS } from '../selectors';
-import { SMM } from '../smm';
+import { rpcRequest } from '../rpc';
+import { exposeOnWindow, SMM } from '../smm';
 import { info, waitForElement } from '../util';
 
 const main = async () => {
@@ -11,11 +12,8 @@ const main = async () => {
 
   waitForElement(DECK_SELECTORS.quickAccessContainer);
 
-  const smm = new SMM('quickAccess');
-  if (window.smm) {
-    delete window.smm;
-  }
-  window.smm = smm;
+  const smm = new SMM('quickAccess', rpcRequest);
+  exposeOnWindow(smm);
 
   await smm.loadPlugins();
 };
@@ -14,6 +14,18 @@ import {
 
 const gamepadRoot = (id: string) => `gamepad-root-${id}`;
 
+// Currently active gamepad handler
+// This should only be set by internal Crankshaft code
+let activeGamepadHandler: GamepadHandler | undefined;
+
+export const getActiveGamepadHandler = () => activeGamepadHandler;
+
+export const setActiveGamepadHandler = (
+  gamepadHandler: GamepadHandler | undefined
+) => {
+  activeGamepadHandler = gamepadHandler;
+};
+
 export class GamepadHandler {
   private readonly smm: SMM;
   private readonly id: string;
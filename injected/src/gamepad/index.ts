@@ -1,3 +1,7 @@
-export { GamepadHandler } from './gamepad';
+export {
+  GamepadHandler,
+  getActiveGamepadHandler,
+  setActiveGamepadHandler,
+} from './gamepad';
 
 export const GP_FOCUS_CLASS = 'cs-gp-focus';
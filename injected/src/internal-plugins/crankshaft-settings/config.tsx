@@ -0,0 +1,150 @@
+import { FunctionComponent } from 'preact';
+import { useCallback, useEffect, useState } from 'preact/hooks';
+import { Setting } from '.';
+import { ConfigSetting, ConfigSettingValue } from '../../services/config';
+import { SMM } from '../../smm';
+
+const useConfig = (smm: SMM) => {
+  const [settings, setSettings] = useState<ConfigSetting[] | undefined>(
+    undefined
+  );
+
+  useEffect(() => {
+    (async () => {
+      try {
+        setSettings(await smm.Config.get());
+      } catch (err) {
+        smm.Toast.addToast('Error loading Crankshaft settings.', 'error');
+      }
+    })();
+
+    // Settings can also change when config.toml is edited
+    smm.Config.onChanged((_, settings) => setSettings(settings));
+  }, [setSettings]);
+
+  const setValue = useCallback(
+    async (key: string, value: ConfigSettingValue) => {
+      try {
+        setSettings(await smm.Config.set({ [key]: value }));
+      } catch (err) {
+        smm.Toast.addToast(`Error saving setting: ${err}`, 'error');
+      }
+    },
+    [setSettings]
+  );
+
+  return { settings, setValue };
+};
+
+export const Config: FunctionComponent<{ smm: SMM }> = ({ smm }) => {
+  const { settings, setValue } = useConfig(smm);
+
+  if (!settings) {
+    return null;
+  }
+
+  return (
+    <>
+      {settings.map((setting) => (
+        <ConfigSettingSection
+          key={setting.key}
+          setting={setting}
+          setValue={setValue}
+        />
+      ))}
+    </>
+  );
+};
+
+// Text inputs hold string lists one item per line
+const toText = (setting: ConfigSetting) =>
+  Array.isArray(setting.value)
+    ? setting.value.join('\n')
+    : String(setting.value);
+
+const fromText = (
+  setting: ConfigSetting,
+  text: string
+): ConfigSettingValue => {
+  switch (setting.type) {
+    case 'number':
+      return Number(text);
+    case 'string-list':
+      return text
+        .split('\n')
+        .map((line) => line.trim())
+        .filter((line) => line !== '');
+    default:
+      return text;
+  }
+};
+
+const ConfigSettingSection: FunctionComponent<{
+  setting: ConfigSetting;
+  setValue: (key: string, value: ConfigSettingValue) => Promise<void>;
+}> = ({ setting, setValue }) => {
+  const [text, setText] = useState(toText(setting));
+
+  useEffect(() => {
+    setText(toText(setting));
+  }, [setting, setText]);
+
+  const gpGroupName = `config-${setting.key}`;
+
+  let input: JSX.Element;
+  if (setting.type === 'boolean') {
+    input = (
+      <button
+        className="cs-button"
+        onClick={() => setValue(setting.key, !setting.value)}
+        data-cs-gp-in-group={gpGroupName}
+        data-cs-gp-item={`${gpGroupName}__toggle`}
+      >
+        {setting.value ? 'Disable' : 'Enable'}
+      </button>
+    );
+  } else {
+    const inputProps = {
+      value: text,
+      onInput: (e: Event) => setText((e.target as HTMLInputElement).value),
+      style: { marginRight: 8, fontFamily: 'monospace' },
+      'data-cs-gp-in-group': gpGroupName,
+      'data-cs-gp-item': `${gpGroupName}__input`,
+    };
+    input = (
+      <div style={{ display: 'flex', alignItems: 'flex-start' }}>
+        {setting.type === 'string-list' ? (
+          <textarea rows={3} {...inputProps} />
+        ) : (
+          <input type="text" {...inputProps} />
+        )}
+        <button
+          className="cs-button"
+          onClick={() => setValue(setting.key, fromText(setting, text))}
+          disabled={text === toText(setting)}
+          data-cs-gp-in-group={gpGroupName}
+          data-cs-gp-item={`${gpGroupName}__save`}
+        >
+          Save
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <Setting name={setting.label} gpGroupName={gpGroupName}>
+      <p style={{ margin: '0 0 8px 0' }}>
+        {setting.type === 'boolean' && (
+          <b>{setting.value ? 'Enabled' : 'Disabled'}</b>
+        )}
+        {setting.description && (
+          <span style={{ display: 'block' }}>{setting.description}</span>
+        )}
+        {setting.requiresRestart && (
+          <i>Requires restarting Crankshaft to take effect.</i>
+        )}
+      </p>
+      {input}
+    </Setting>
+  );
+};
@@ -2,6 +2,7 @@ import { FunctionComponent, render } from 'preact';
 import { useCallback, useEffect, useState } from 'preact/hooks';
 import { SMM } from '../../smm';
 import { Autostart } from './autostart';
+import { Config } from './config';
 
 export const load = (smm: SMM) => {
   smm.MenuManager.addMenuItem({
@@ -30,6 +31,7 @@ const App: FunctionComponent<{ smm: SMM }> = ({ smm }) => (
     >
       <Info />
       <Autostart smm={smm} />
+      <Config smm={smm} />
       <Devtools smm={smm} />
       <CefDebugToggle />
     </ul>
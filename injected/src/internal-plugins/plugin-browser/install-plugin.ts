@@ -1,14 +1,8 @@
-import { basename, join } from 'path-browserify';
+import { basename } from 'path-browserify';
 import { useCallback } from 'preact/hooks';
-import {
-  NetworkDownloadCancelledError,
-  NetworkDownloadTimeoutError,
-} from '../../services/network';
 import { SMM } from '../../smm';
 import { FetchedPlugin } from './fetch-plugins';
 
-const PLUGINS_DIR = '$XDG_DATA/crankshaft/plugins';
-
 export const useInstallPlugin = (
   smm: SMM,
   plugin: FetchedPlugin,
@@ -16,84 +10,99 @@ export const useInstallPlugin = (
 ) =>
   useCallback(() => {
     (async () => {
-      // Ensure plugins directory exists
-      await smm.FS.mkDir(PLUGINS_DIR, true);
-
       const fileName = basename(plugin.archive);
 
-      const progressModal = smm.UI.createProgressModal({
+      const installModal = smm.UI.createProgressModal({
         displayName: `${plugin.name} ${plugin.version}`,
         fileName,
-        progress: true,
-        title: 'Downloading',
+        progress: false,
+        title: 'Installing',
       });
 
-      const { cancel, download, id } = smm.Network.download({
-        url: plugin.archive,
-        path: join(PLUGINS_DIR, fileName),
-        timeoutSeconds: 5 * 60,
-        progressCallback: (progress) => {
-          progressModal.update(progress);
-        },
+      installModal.open(() => {
+        // TODO: allow cancelling install
+        console.info('Install cancelled');
+        installModal.close();
       });
 
-      progressModal.open(cancel);
-
+      // The server downloads, verifies and extracts the plugin, and rolls back
+      // if any of those steps fail
+      let warning: string | undefined;
       try {
-        await download();
-      } catch (err) {
-        if (err instanceof NetworkDownloadCancelledError) {
-          return;
-        }
-        if (err instanceof NetworkDownloadTimeoutError) {
-          smm.Toast.addToast(`Download timed out: ${fileName}`, 'error');
-          return;
-        }
+        const installed = await smm.Plugins.install(
+          plugin.archive,
+          plugin.sha256,
+          plugin.signature,
+          false,
+          plugin.repository
+        );
+        warning = installed.warning;
+
+        if (installed.signatureRequired) {
+          installModal.close();
+
+          // Only install plugins that aren't signed by a trusted publisher if
+          // the user says so
+          try {
+            await smm.UI.confirm({
+              message: `${plugin.name} couldn't be verified: ${installed.signatureError}. Only install it if you trust its author.`,
+              confirmText: 'Install anyway',
+              confirmBackgroundColour: 'rgb(209, 28, 28)',
+            });
+          } catch (err) {
+            if (err instanceof smm.UI.errors.ConfirmModalCancelledError) {
+              return;
+            }
+            throw err;
+          }
 
+          installModal.open(() => {
+            console.info('Install cancelled');
+            installModal.close();
+          });
+          ({ warning } = await smm.Plugins.install(
+            plugin.archive,
+            plugin.sha256,
+            plugin.signature,
+            true,
+            plugin.repository
+          ));
+        }
+      } catch (err) {
         smm.Toast.addToast(
-          `Error downloading ${plugin.name} ${plugin.version}`,
+          `Error installing ${plugin.name} ${plugin.version}`,
           'error'
         );
-        console.error(`Error downloading ${plugin.name} ${plugin.version}`, {
+        console.error(`Error installing ${plugin.name} ${plugin.version}`, {
           plugin,
           err,
         });
         return;
       } finally {
-        progressModal.close();
+        installModal.close();
       }
 
-      const extractModal = smm.UI.createProgressModal({
-        displayName: `${plugin.name} ${plugin.version}`,
-        fileName,
-        progress: false,
-        title: 'Extracting',
-      });
-
-      extractModal.open(() => {
-        // TODO: allow cancelling untar
-        console.info('Extract cancelled');
-        extractModal.close();
-      });
-
-      try {
-        await smm.FS.untar(join(PLUGINS_DIR, fileName), PLUGINS_DIR).getRes();
-      } catch (err) {
-        smm.Toast.addToast(`Error extracting ${fileName}`, 'error');
-        extractModal.close();
+      // Plugins that don't support this platform are installed, but can't be
+      // enabled
+      if (warning) {
+        updatePlugins();
+        smm.Toast.addToast(
+          `${plugin.name} ${plugin.version} was installed, but can't be enabled: ${warning}`,
+          'info'
+        );
         return;
-      } finally {
-        // Whether the extraction suceeded or failed, remove the donwload tar file
-        smm.FS.removeFile(join(PLUGINS_DIR, fileName));
       }
 
-      extractModal.close();
-
       try {
-        await smm.Plugins.reloadPlugins();
+        await smm.Plugins.enable(plugin.id);
         await smm.Plugins.reloadPlugin(plugin.id);
-        await smm.Plugins.setEnabled(plugin.id, true);
       } catch (err) {
+        if (err instanceof smm.UI.errors.ConfirmModalCancelledError) {
+          // Plugin was installed, but the user didn't approve its permissions
+          updatePlugins();
+          return;
+        }
+
         smm.Toast.addToast(`Error loading plugin ${plugin.name}`, 'error');
         console.error(err);
         return;
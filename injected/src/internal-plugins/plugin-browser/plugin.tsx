@@ -124,6 +124,8 @@ export const Plugin: FunctionComponent<
             <br />
             Version {plugin.version}
             <br />
+            From {plugin.repository}
+            <br />
             <a
               href={plugin.source}
               data-cs-gp-in-group={plugin.id}
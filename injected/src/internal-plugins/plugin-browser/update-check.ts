@@ -1,10 +1,27 @@
+import { PluginUpdate } from '../../services/plugins';
 import { SMM } from '../../smm';
-import { fetchPlugins } from './fetch-plugins';
 
 const HOUR = 1000 * 60 * 60;
 const pluginId = '_cs-plugin-browser';
 
+const showUpdatesToast = (smm: SMM) =>
+  smm.Toast.addToast(
+    'Plugin updates are available! Check the plugin store to download updates.',
+    'success',
+    {
+      timeout: 5000,
+    }
+  );
+
 export const checkForUpdates = async (smm: SMM) => {
+  // The server checks for updates in the background, and lets us know when it
+  // finds new ones
+  smm.IPC.on<PluginUpdate[]>('csPluginUpdatesAvailable', ({ data }) => {
+    if (data.some(({ compatible }) => compatible)) {
+      showUpdatesToast(smm);
+    }
+  });
+
   let lastUpdateCheck: Date | undefined;
   const lastUpdateCheckString = await smm.Store.get(
     pluginId,
@@ -14,28 +31,15 @@ export const checkForUpdates = async (smm: SMM) => {
     lastUpdateCheck = new Date(Number(lastUpdateCheckString));
   }
 
+  // Remind the user about updates found earlier at most once a day
   if (
     !lastUpdateCheck ||
     Math.abs(new Date().getTime() - lastUpdateCheck.getTime()) / HOUR >= 24
   ) {
-    console.log('Checking for plugin updates...');
-
-    const plugins = await fetchPlugins(smm);
+    const { updates } = await smm.Plugins.checkUpdates();
 
-    // Show toast if any plugins have updates available
-    if (
-      plugins.some(
-        ({ installedPlugin, ...plugin }) =>
-          installedPlugin && plugin.version > installedPlugin.config.version
-      )
-    ) {
-      smm.Toast.addToast(
-        'Plugin updates are available! Check the plugin store to download updates.',
-        'success',
-        {
-          timeout: 5000,
-        }
-      );
+    if (updates.some(({ compatible }) => compatible)) {
+      showUpdatesToast(smm);
     }
 
     await smm.Store.set(
@@ -91,12 +91,19 @@ const Plugin: FunctionComponent<{
   reloadPlugins: () => Promise<void>;
   smm: SMM;
 }> = ({ first, plugin, reloadPlugins, smm }) => {
-  const { handleLoad, handleUnload, handleReload, handleRemove } =
-    usePluginActions({
-      plugin,
-      reloadPlugins,
-      smm,
-    });
+  const {
+    handleLoad,
+    handleUnload,
+    handleReload,
+    handleRemove,
+    handleRollback,
+  } = usePluginActions({
+    plugin,
+    reloadPlugins,
+    smm,
+  });
+
+  const previousVersion = plugin.backups?.[0]?.version;
 
   const description = useMemo(() => {
     if (!plugin.config.store.description) {
@@ -144,11 +151,25 @@ const Plugin: FunctionComponent<{
             <p style={{ marginTop: 0 }}>
               Version {plugin.config.version}
               <br />
-              {plugin.enabled ? 'Loaded' : 'Disabled'}
+              {plugin.status === 'errored'
+                ? 'Failed to load'
+                : plugin.status === 'unmet-requirements'
+                ? 'Requirements not met'
+                : plugin.status === 'unsupported'
+                ? 'Not supported on this platform'
+                : plugin.enabledModes[window.smmUIMode]
+                ? 'Loaded'
+                : 'Disabled'}
             </p>
 
+            {plugin.status !== 'ok' ? (
+              <p style={{ marginTop: 0, color: 'rgb(209, 28, 28)' }}>
+                {plugin.error}
+              </p>
+            ) : undefined}
+
             <div style={{ display: 'flex', gap: 8 }}>
-              {plugin.enabled ? (
+              {plugin.enabledModes[window.smmUIMode] ? (
                 <button
                   className="cs-button"
                   onClick={handleUnload}
@@ -187,6 +208,19 @@ const Plugin: FunctionComponent<{
                 Remove
               </button>
             </div>
+
+            {previousVersion ? (
+              <div style={{ display: 'flex', gap: 8, marginTop: 8 }}>
+                <button
+                  className="cs-button"
+                  onClick={() => handleRollback(previousVersion)}
+                  data-cs-gp-in-group={plugin.id}
+                  data-cs-gp-item={`${plugin.id}__rollback`}
+                >
+                  Roll back to {previousVersion}
+                </button>
+              </div>
+            ) : undefined}
           </div>
           {typeof description !== 'undefined' && Boolean(description) ? (
             <div
@@ -13,7 +13,13 @@ export const usePluginActions = ({
   smm: SMM;
 }) => {
   const handleLoad = useCallback(async () => {
-    await smm.Plugins.load(plugin.id);
+    try {
+      await smm.Plugins.load(plugin.id);
+    } catch (err) {
+      if (!(err instanceof ConfirmModalCancelledError)) {
+        throw err;
+      }
+    }
     reloadPlugins();
   }, [plugin, reloadPlugins, smm]);
 
@@ -29,7 +35,23 @@ export const usePluginActions = ({
         confirmText: 'Remove plugin',
         confirmBackgroundColour: 'rgb(209, 28, 28)',
       });
-      await smm.Plugins.remove(plugin.id);
+
+      let purgeData = true;
+      try {
+        await smm.UI.confirm({
+          message: `Do you also want to delete the data ${plugin.config.name} saved?`,
+          confirmText: 'Delete data',
+          cancelText: 'Keep data',
+          confirmBackgroundColour: 'rgb(209, 28, 28)',
+        });
+      } catch (err) {
+        if (!(err instanceof ConfirmModalCancelledError)) {
+          throw err;
+        }
+        purgeData = false;
+      }
+
+      await smm.Plugins.remove(plugin.id, purgeData);
       smm.Toast.addToast(`Plugin ${plugin.config.name} removed`, 'success');
     } catch (err) {
       if (err instanceof ConfirmModalCancelledError) {
@@ -56,10 +78,40 @@ export const usePluginActions = ({
     }
   }, [plugin, reloadPlugins, smm]);
 
+  const handleRollback = useCallback(
+    async (version: string) => {
+      try {
+        await smm.UI.confirm({
+          message: `Roll back ${plugin.config.name} to version ${version}?`,
+          confirmText: 'Roll back',
+        });
+        await smm.Plugins.rollback(plugin.id, version);
+        if (plugin.enabledModes[window.smmUIMode]) {
+          await smm.Plugins.reloadPlugin(plugin.id);
+        }
+        smm.Toast.addToast(
+          `${plugin.config.name} rolled back to ${version}`,
+          'success'
+        );
+      } catch (err) {
+        if (err instanceof ConfirmModalCancelledError) {
+          return;
+        }
+
+        console.error(err);
+        smm.Toast.addToast('Error rolling back plugin.', 'error');
+      } finally {
+        reloadPlugins();
+      }
+    },
+    [plugin, reloadPlugins, smm]
+  );
+
   return {
     handleLoad,
     handleUnload,
     handleReload,
     handleRemove,
+    handleRollback,
   };
 };
@@ -19,44 +19,67 @@ export class RpcRequestCancelledError extends Error {
   }
 }
 
-export const rpcRequest = <Params, Response>(
+export type RpcRequest = <Params, Response>(
   method: string,
   params: Params
 ) => {
-  const controller = new AbortController();
-  const cancel = () => controller.abort();
-
-  const getRes = async (): Promise<Response> => {
-    try {
-      const res = await fetch(`http://localhost:${window.smmServerPort}/rpc`, {
-        signal: controller.signal,
-        method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-          'X-Cs-Auth': window.csAuthToken,
-        },
-        body: JSON.stringify({
-          method,
-          params: [params],
-          id: uuidv4(),
-        }),
-      });
-
-      if (!res.ok) {
-        throw new RpcRequestError(res.status);
-      }
+  getRes: () => Promise<Response>;
+  cancel: () => void;
+};
+
+/**
+ * Creates a function that makes RPC requests with the given auth token.
+ */
+export const createRpcRequest =
+  (authToken: string): RpcRequest =>
+  <Params, Response>(method: string, params: Params) => {
+    const controller = new AbortController();
+    const cancel = () => controller.abort();
+
+    const getRes = async (): Promise<Response> => {
+      try {
+        const res = await fetch(
+          `http://localhost:${window.smmServerPort}/rpc`,
+          {
+            signal: controller.signal,
+            method: 'POST',
+            headers: {
+              'Content-Type': 'application/json',
+              'X-Cs-Auth': authToken,
+            },
+            body: JSON.stringify({
+              method,
+              params: [params],
+              id: uuidv4(),
+            }),
+          }
+        );
+
+        if (!res.ok) {
+          throw new RpcRequestError(res.status);
+        }
 
-      return (await res.json()).result;
-    } catch (err) {
-      if (err instanceof DOMException && err.name === 'AbortError') {
-        throw new RpcRequestCancelledError();
+        return (await res.json()).result;
+      } catch (err) {
+        if (err instanceof DOMException && err.name === 'AbortError') {
+          throw new RpcRequestCancelledError();
+        }
+        throw new RpcRequestError();
       }
-      throw new RpcRequestError();
-    }
-  };
+    };
 
-  return {
-    getRes,
-    cancel,
+    return {
+      getRes,
+      cancel,
+    };
   };
-};
+
+// The core token is only on window while the core script is being evaluated.
+// It's kept here and removed, so plugins can't read it.
+const coreAuthToken = window.csCoreAuthToken;
+delete (window as Partial<Window>).csCoreAuthToken;
+
+/**
+ * Makes RPC requests with the core Crankshaft token.
+ */
+export const rpcRequest = createRpcRequest(coreAuthToken);
@@ -0,0 +1,21 @@
+import { Service } from './service';
+
+export class Backend extends Service {
+  /**
+   * Calls `method` on the plugin's backend with JSON-RPC, and returns its
+   * result. The backend has to be declared in the plugin's plugin.toml, and
+   * only runs while the plugin is enabled.
+   */
+  async call<Result = unknown>(
+    pluginId: string,
+    method: string,
+    params?: unknown
+  ) {
+    const { getRes } = this.smm.rpcRequest<
+      { id: string; method: string; params?: unknown },
+      { result: Result }
+    >('BackendService.Call', { id: pluginId, method, params });
+
+    return (await getRes()).result;
+  }
+}
@@ -0,0 +1,56 @@
+import { Service } from './service';
+
+export type ConfigSettingValue = boolean | number | string | string[];
+
+export interface ConfigSetting {
+  key: string;
+  /**
+   * Numbers are whole numbers of at least zero, durations are strings like
+   * "12h"
+   */
+  type: 'boolean' | 'number' | 'duration' | 'string-list';
+  label: string;
+  description?: string;
+  value: ConfigSettingValue;
+  /** The setting only takes effect once Crankshaft restarts */
+  requiresRestart: boolean;
+}
+
+/**
+ * Crankshaft's own settings, stored in its config.toml.
+ */
+export class Config extends Service {
+  async get() {
+    const { getRes } = this.smm.rpcRequest<{}, { settings: ConfigSetting[] }>(
+      'ConfigService.Get',
+      {}
+    );
+    return (await getRes()).settings;
+  }
+
+  /**
+   * Changes some of Crankshaft's settings. Throws without changing anything
+   * if any value is invalid.
+   */
+  async set(settings: Record<string, ConfigSettingValue>) {
+    const { getRes } = this.smm.rpcRequest<
+      { settings: Record<string, ConfigSettingValue> },
+      { settings: ConfigSetting[] }
+    >('ConfigService.Set', { settings });
+    return (await getRes()).settings;
+  }
+
+  /**
+   * Calls `handler` with the keys of the settings that changed and the new
+   * settings whenever they're changed, including when config.toml is edited
+   * outside of Crankshaft.
+   */
+  onChanged(
+    handler: (changed: string[], settings: ConfigSetting[]) => void
+  ) {
+    this.smm.IPC.on<{ changed: string[]; settings: ConfigSetting[] }>(
+      'csConfigChanged',
+      ({ data }) => handler(data.changed, data.settings)
+    );
+  }
+}
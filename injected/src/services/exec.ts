@@ -1,9 +1,8 @@
-import { rpcRequest } from '../rpc';
 import { Service } from './service';
 
 export class Exec extends Service {
   async run(command: string, args: string[]) {
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       {
         command: string;
         args: string[];
@@ -18,7 +17,7 @@ export class Exec extends Service {
   }
 
   async start(command: string, args: string[]) {
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       {
         command: string;
         args: string[];
@@ -31,7 +30,7 @@ export class Exec extends Service {
   }
 
   async stop(pid: number, kill: boolean = false) {
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       {
         pid: number;
         kill: boolean;
@@ -1,4 +1,3 @@
-import { rpcRequest } from '../rpc';
 import { info } from '../util';
 import { Service } from './service';
 
@@ -6,7 +5,7 @@ export class FS extends Service {
   async listDir(path: string) {
     info('listDir', path);
 
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       { path: string },
       {
         contents: {
@@ -22,17 +21,17 @@ export class FS extends Service {
   async mkDir(path: string, parents: boolean = false) {
     info('mkDir', path, parents);
 
-    const { getRes } = rpcRequest<{ path: string; parents: boolean }, {}>(
-      'FSService.MkDir',
-      { path, parents }
-    );
+    const { getRes } = this.smm.rpcRequest<
+      { path: string; parents: boolean },
+      {}
+    >('FSService.MkDir', { path, parents });
     return getRes();
   }
 
   async readFile(path: string) {
     info('readFile', path);
 
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       { path: string },
       {
         data: string;
@@ -45,7 +44,7 @@ export class FS extends Service {
   removeFile(path: string) {
     info('removeFile', path);
 
-    const { getRes } = rpcRequest<{ path: string }, {}>(
+    const { getRes } = this.smm.rpcRequest<{ path: string }, {}>(
       'FSService.RemoveFile',
       { path }
     );
@@ -56,14 +55,14 @@ export class FS extends Service {
   untar(tarPath: string, destPath: string) {
     info('untar', { tarPath, destPath });
 
-    return rpcRequest<{ tarPath: string; destPath: string }, void>(
+    return this.smm.rpcRequest<{ tarPath: string; destPath: string }, void>(
       'FSService.Untar',
       { tarPath, destPath }
     );
   }
 
   async getPluginsPath() {
-    const { getRes } = rpcRequest<{}, { path: string }>(
+    const { getRes } = this.smm.rpcRequest<{}, { path: string }>(
       'FSService.GetPluginsPath',
       {}
     );
@@ -1,10 +1,9 @@
-import { rpcRequest } from '../rpc';
 import { AppPropsApp } from '../types/global';
 import { Service } from './service';
 
 export class Inject extends Service {
   async injectAppProperties(app: AppPropsApp, title: string) {
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       {
         app: string;
         title: string;
@@ -13,4 +12,16 @@ export class Inject extends Service {
     >('InjectService.InjectAppProperties', { app: JSON.stringify(app), title });
     return getRes();
   }
+
+  /**
+   * Calls the plugin's `unload` hook in every target it was injected into, and
+   * removes it so it can be injected again.
+   */
+  async unloadPlugin(pluginId: string) {
+    const { getRes } = this.smm.rpcRequest<{ pluginId: string }, {}>(
+      'InjectService.UnloadPlugin',
+      { pluginId }
+    );
+    return getRes();
+  }
 }
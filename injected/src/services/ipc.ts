@@ -1,4 +1,3 @@
-import { rpcRequest } from '../rpc';
 import { Service } from './service';
 
 type Handler<T extends any> = (event: { name: string; data: T }) => void;
@@ -24,12 +23,15 @@ export class IPC extends Service {
   }
 
   async send<T extends any>(name: string, data: T) {
-    const { getRes } = rpcRequest<{ message: string }, {}>('IPCService.Send', {
-      message: JSON.stringify({
-        name,
-        data,
-      }),
-    });
+    const { getRes } = this.smm.rpcRequest<{ message: string }, {}>(
+      'IPCService.Send',
+      {
+        message: JSON.stringify({
+          name,
+          data,
+        }),
+      }
+    );
     return getRes();
   }
 
@@ -43,4 +45,12 @@ export class IPC extends Service {
   off(name: string) {
     this.listeners[name] = [];
   }
+
+  /**
+   * @internal
+   */
+  close() {
+    this.listeners = {};
+    this.ws.close();
+  }
 }
@@ -1,4 +1,4 @@
-import { rpcRequest, RpcRequestCancelledError } from '../rpc';
+import { RpcRequestCancelledError } from '../rpc';
 import { info, uuidv4 } from '../util';
 import { Service } from './service';
 
@@ -47,7 +47,7 @@ export class Network extends Service {
   async get<T>(url: string) {
     info('get', url);
 
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       { url: string },
       { data: string; status: number }
     >('NetworkService.Get', { url });
@@ -75,7 +75,7 @@ export class Network extends Service {
 
     const id = uuidv4();
 
-    const { cancel, getRes } = rpcRequest<
+    const { cancel, getRes } = this.smm.rpcRequest<
       DownloadArgs,
       { status: 'success' | 'timeout' }
     >('NetworkService.Download', {
@@ -124,7 +124,7 @@ export class Network extends Service {
   }
 
   checkDownloadProgress(id: string) {
-    const { getRes } = rpcRequest<{ id: string }, DownloadProgress>(
+    const { getRes } = this.smm.rpcRequest<{ id: string }, DownloadProgress>(
       'NetworkService.CheckDownloadProgress',
       {
         id,
@@ -1,4 +1,3 @@
-import { rpcRequest } from '../rpc';
 import { Entry } from '../smm';
 import { Service } from './service';
 
@@ -17,6 +16,33 @@ export interface Platform {
   supported: boolean;
 }
 
+export interface PluginPermissions {
+  exec?: string[];
+  fs?: string[];
+  network?: string[];
+  sharedStore?: boolean;
+  backend?: boolean;
+}
+
+export interface PluginBackup {
+  version: string;
+  backedUpAt: string;
+}
+
+export type PluginSettingValue = boolean | number | string;
+
+export type PluginSettings = Record<string, PluginSettingValue>;
+
+export interface PluginSetting {
+  type: 'boolean' | 'number' | 'enum' | 'string';
+  label: string;
+  description?: string;
+  default: PluginSettingValue;
+  min?: number;
+  max?: number;
+  options?: string[];
+}
+
 export interface Plugin {
   id: string;
   dir: string;
@@ -40,13 +66,59 @@ export interface Plugin {
       description?: string;
       platforms: StorePlatforms;
     };
+
+    permissions: PluginPermissions;
+
+    build: {
+      entry: string;
+    };
+
+    requires: {
+      crankshaft: string;
+      plugins?: Record<string, string>;
+    };
+
+    backend: {
+      command: string;
+      args?: string[];
+    };
+
+    settings?: Record<string, PluginSetting>;
   };
+  /** True if the plugin is enabled in any UI mode */
   enabled: boolean;
+  enabledModes: Record<UIMode, boolean>;
+  permissionsApproved: boolean;
+  status: 'ok' | 'errored' | 'unmet-requirements' | 'unsupported';
+  error?: string;
+  /** Name of the repository the plugin was installed from, if any */
+  repository?: string;
+  backups?: PluginBackup[];
+}
+
+export type UIMode = 'desktop' | 'deck';
+
+export interface PluginProfile {
+  name: string;
+  /** IDs of the plugins the profile enables, by UI mode */
+  plugins: Record<UIMode, string[]>;
+}
+
+export interface PluginUpdate {
+  pluginId: string;
+  installedVersion: string;
+  version: string;
+  /** Name of the repository the update comes from */
+  repository: string;
+  archive: string;
+  sha256: string;
+  signature?: string;
+  minCrankshaftVersion?: string;
+  compatible: boolean;
 }
 
 enum ipcNames {
   load = 'csPluginsLoad',
-  unload = 'csPluginsUnload',
   injectPlugin = 'csPluginsInjectPlugin',
   reloadPlugin = 'csPluginsReloadPlugin',
 }
@@ -66,7 +138,6 @@ export class Plugins extends Service {
   private attachIPCListeners() {
     const ipcMethods = {
       [ipcNames.load]: '_load',
-      [ipcNames.unload]: '_unload',
       [ipcNames.injectPlugin]: '_injectPlugin',
       [ipcNames.reloadPlugin]: '_reloadPlugin',
     } as const;
@@ -84,35 +155,303 @@ export class Plugins extends Service {
     }
   }
 
+  /**
+   * Returns the plugin's scoped auth token, which only grants the permissions
+   * it was approved for.
+   * @internal
+   */
+  async authToken(pluginId: string) {
+    const { getRes } = this.smm.rpcRequest<
+      { pluginId: string },
+      { token: string }
+    >('InjectService.PluginAuthToken', { pluginId });
+    return (await getRes()).token;
+  }
+
   async list() {
-    const { getRes } = rpcRequest<{}, { plugins: Record<string, Plugin> }>(
-      'PluginsService.List',
-      {}
-    );
+    const { getRes } = this.smm.rpcRequest<
+      {},
+      { plugins: Record<string, Plugin> }
+    >('PluginsService.List', {});
     return (await getRes()).plugins;
   }
 
   async injectPlugins(entry: Entry) {
-    const { getRes } = rpcRequest<{ entryPoint: Entry; title: string }, {}>(
-      'InjectService.InjectPlugins',
-      { entryPoint: entry, title: document.title }
+    const { getRes } = this.smm.rpcRequest<
+      { entryPoint: Entry; title: string },
+      {}
+    >('InjectService.InjectPlugins', {
+      entryPoint: entry,
+      title: document.title,
+    });
+    return getRes();
+  }
+
+  /**
+   * Enables or disables the plugin in the current UI mode, or in every UI mode
+   * if `uiMode` is empty.
+   */
+  async setEnabled(
+    id: string,
+    enabled: boolean,
+    approvePermissions: boolean = false,
+    uiMode: UIMode | '' = window.smmUIMode
+  ) {
+    const { getRes } = this.smm.rpcRequest<
+      {
+        id: string;
+        enabled: boolean;
+        approvePermissions: boolean;
+        uiMode: UIMode | '';
+      },
+      { permissionsRequired: boolean; permissions: PluginPermissions }
+    >('PluginsService.SetEnabled', { id, enabled, approvePermissions, uiMode });
+    return getRes();
+  }
+
+  /**
+   * Enables the plugin in the current UI mode, asking the user to approve its
+   * permissions first if it requests any that haven't been approved.
+   */
+  async enable(pluginId: string) {
+    const { permissionsRequired, permissions } = await this.setEnabled(
+      pluginId,
+      true
+    );
+    if (!permissionsRequired) {
+      return;
+    }
+
+    const requested = [
+      ...(permissions.exec ?? []).map((exec) => `Run ${exec}`),
+      ...(permissions.fs ?? []).map((fs) => `Access files in ${fs}`),
+      ...(permissions.network ?? []).map((host) => `Connect to ${host}`),
+      ...(permissions.sharedStore
+        ? ['Share stored data with other plugins']
+        : []),
+      ...(permissions.backend ? ['Run its own background program'] : []),
+    ];
+
+    await this.smm.UI.confirm({
+      message: `${pluginId} is requesting permission to: ${requested.join(
+        ', '
+      )}`,
+      confirmText: 'Allow',
+    });
+
+    await this.setEnabled(pluginId, true, true);
+  }
+
+  /**
+   * @param repository Name of the repository the archive is listed in. Its
+   * trusted key can sign the archive, and the plugin's updates only come from
+   * it.
+   */
+  async install(
+    url: string,
+    sha256: string,
+    signature: string = '',
+    allowUnsigned: boolean = false,
+    repository: string = ''
+  ) {
+    const { getRes } = this.smm.rpcRequest<
+      {
+        url: string;
+        sha256: string;
+        signature: string;
+        allowUnsigned: boolean;
+        repository: string;
+      },
+      {
+        id: string;
+        signatureRequired: boolean;
+        signatureError?: string;
+        /** Set if the plugin was installed but doesn't support this platform */
+        warning?: string;
+      }
+    >('PluginsService.Install', {
+      url,
+      sha256,
+      signature,
+      allowUnsigned,
+      repository,
+    });
+    return getRes();
+  }
+
+  /**
+   * Installs a plugin archive that's already on this device, for devices
+   * without network access.
+   */
+  async installFromFile(
+    path: string,
+    signature: string = '',
+    allowUnsigned: boolean = false
+  ) {
+    const { getRes } = this.smm.rpcRequest<
+      {
+        path: string;
+        signature: string;
+        allowUnsigned: boolean;
+      },
+      {
+        id: string;
+        signatureRequired: boolean;
+        signatureError?: string;
+        /** Set if the plugin was installed but doesn't support this platform */
+        warning?: string;
+      }
+    >('PluginsService.InstallFromFile', { path, signature, allowUnsigned });
+    return getRes();
+  }
+
+  /**
+   * Restores a previously installed version of the plugin. The current version
+   * is backed up, so the rollback can be undone.
+   */
+  async rollback(pluginId: string, version: string) {
+    const { getRes } = this.smm.rpcRequest<{ id: string; version: string }, {}>(
+      'PluginsService.Rollback',
+      { id: pluginId, version }
     );
+    await getRes();
+  }
+
+  /**
+   * Returns the plugin updates found by the server's background update check,
+   * or checks the registry now if `refresh` is true.
+   */
+  async checkUpdates(refresh: boolean = false) {
+    const { getRes } = this.smm.rpcRequest<
+      { refresh: boolean },
+      { updates: PluginUpdate[]; lastChecked: string }
+    >('PluginsService.CheckUpdates', { refresh });
     return getRes();
   }
 
-  async setEnabled(id: string, enabled: boolean) {
-    const { getRes } = rpcRequest<{ id: string; enabled: boolean }, {}>(
-      'PluginsService.SetEnabled',
-      { id, enabled }
+  /**
+   * Returns the URL of a file in the plugin's `assets` directory, for use in
+   * `<img>` tags, CSS and so on.
+   */
+  assetUrl(pluginId: string, assetPath: string) {
+    const encodedPath = assetPath
+      .replace(/^\/+/, '')
+      .split('/')
+      .map(encodeURIComponent)
+      .join('/');
+    const { serverPort } = this.smm;
+    const id = encodeURIComponent(pluginId);
+    return `http://localhost:${serverPort}/plugins/${id}/assets/${encodedPath}`;
+  }
+
+  /**
+   * Returns the plugin's settings, with defaults for settings that haven't
+   * been changed.
+   */
+  async getSettings(pluginId: string) {
+    const { getRes } = this.smm.rpcRequest<
+      { id: string },
+      { settings: PluginSettings }
+    >('PluginsService.GetSettings', { id: pluginId });
+    return (await getRes()).settings;
+  }
+
+  /**
+   * Changes some of the plugin's settings. Throws without changing anything if
+   * any value doesn't match the settings declared in plugin.toml.
+   */
+  async setSettings(pluginId: string, settings: Partial<PluginSettings>) {
+    const { getRes } = this.smm.rpcRequest<
+      { id: string; settings: Partial<PluginSettings> },
+      { settings: PluginSettings }
+    >('PluginsService.SetSettings', { id: pluginId, settings });
+    return (await getRes()).settings;
+  }
+
+  /**
+   * Calls `handler` with the plugin's new settings whenever they're changed.
+   */
+  onSettingsChanged(
+    pluginId: string,
+    handler: (settings: PluginSettings) => void
+  ) {
+    this.smm.IPC.on<{ pluginId: string; settings: PluginSettings }>(
+      'csPluginSettingsChanged',
+      ({ data }) => {
+        if (data.pluginId === pluginId) {
+          handler(data.settings);
+        }
+      }
     );
+  }
+
+  async listProfiles() {
+    const { getRes } = this.smm.rpcRequest<
+      {},
+      { profiles: PluginProfile[]; active: string }
+    >('PluginsService.ListProfiles', {});
     return getRes();
   }
 
-  async remove(pluginId: string) {
+  /**
+   * Saves the plugins currently enabled in each UI mode as the profile, and
+   * makes it the active profile.
+   */
+  async saveProfile(name: string) {
+    const { getRes } = this.smm.rpcRequest<{ name: string }, {}>(
+      'PluginsService.SaveProfile',
+      { name }
+    );
+    await getRes();
+  }
+
+  /**
+   * Enables the profile's plugins and disables every other plugin, loading
+   * plugins in the current UI mode to match. The server unloads the plugins
+   * that were disabled.
+   */
+  async activateProfile(name: string) {
+    const before = await this.list();
+
+    const { getRes } = this.smm.rpcRequest<{ name: string }, {}>(
+      'PluginsService.ActivateProfile',
+      { name }
+    );
+    await getRes();
+
+    const after = await this.list();
+    for (const id of Object.keys(after)) {
+      const wasEnabled = before[id]?.enabledModes[window.smmUIMode] ?? false;
+      if (wasEnabled || !after[id].enabledModes[window.smmUIMode]) {
+        continue;
+      }
+
+      this.smm.IPC.send<PluginsIPCData>(ipcNames.load, {
+        entrypoint: this.smm.entry,
+        pluginId: id,
+      });
+      await this._load(id);
+    }
+  }
+
+  async deleteProfile(name: string) {
+    const { getRes } = this.smm.rpcRequest<{ name: string }, {}>(
+      'PluginsService.DeleteProfile',
+      { name }
+    );
+    await getRes();
+  }
+
+  /**
+   * Removes the plugin. If `purgeData` is true, the data it saved in the store
+   * is deleted too.
+   */
+  async remove(pluginId: string, purgeData: boolean = false) {
     this.unload(pluginId);
-    const { getRes } = rpcRequest<{ id: string }, {}>('PluginsService.Remove', {
-      id: pluginId,
-    });
+    const { getRes } = this.smm.rpcRequest<
+      { id: string; purgeData: boolean },
+      {}
+    >('PluginsService.Remove', { id: pluginId, purgeData });
     return getRes();
   }
 
@@ -126,30 +465,26 @@ export class Plugins extends Service {
   }
 
   async load(pluginId: string) {
+    await this.enable(pluginId);
+
     this.smm.IPC.send<PluginsIPCData>(ipcNames.load, {
       entrypoint: this.smm.entry,
       pluginId,
     });
 
-    await this.setEnabled(pluginId, true);
     return this._load(pluginId);
   }
 
-  private async _unload(pluginId: string) {
-    await this.smm.unloadPlugin(pluginId);
-  }
-
+  /**
+   * Disables the plugin in the current UI mode. The server unloads it from
+   * every target it was injected into.
+   */
   async unload(pluginId: string) {
-    this.smm.IPC.send<PluginsIPCData>(ipcNames.unload, {
-      entrypoint: this.smm.entry,
-      pluginId,
-    });
     await this.setEnabled(pluginId, false);
-    return this._unload(pluginId);
   }
 
   private async _injectPlugin(pluginId: string) {
-    const { getRes } = rpcRequest<
+    const { getRes } = this.smm.rpcRequest<
       { pluginId: string; entrypoint: Entry; title: string },
       {}
     >('InjectService.InjectPlugin', {
@@ -183,7 +518,7 @@ export class Plugins extends Service {
   }
 
   async rebuildPlugin(pluginId: string) {
-    const { getRes: rebuildGetRes } = rpcRequest<{ id: string }, {}>(
+    const { getRes: rebuildGetRes } = this.smm.rpcRequest<{ id: string }, {}>(
       'PluginsService.Rebuild',
       { id: pluginId }
     );
@@ -191,7 +526,7 @@ export class Plugins extends Service {
   }
 
   async reloadPlugins() {
-    const { getRes } = rpcRequest<{}, {}>('PluginsService.Reload', {});
+    const { getRes } = this.smm.rpcRequest<{}, {}>('PluginsService.Reload', {});
 
     await getRes();
   }
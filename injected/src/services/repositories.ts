@@ -0,0 +1,70 @@
+import { StorePlatforms } from './plugins';
+import { Service } from './service';
+
+export interface Repository {
+  name: string;
+  url: string;
+  priority: number;
+  /** True if the repository has a key trusted to sign its plugin archives */
+  trusted: boolean;
+  /** When the index was last fetched, the zero time if it hasn't been yet */
+  lastFetched: string;
+  /** Why the last fetch failed, if it did */
+  error?: string;
+}
+
+export interface RepositoryPlugin {
+  id: string;
+
+  name: string;
+  version: string;
+  link: string;
+  source: string;
+  minCrankshaftVersion?: string;
+
+  author: {
+    name: string;
+    link?: string;
+  };
+
+  store: {
+    description?: string;
+    platforms: StorePlatforms;
+  };
+
+  archive: string;
+  sha256: string;
+  signature?: string;
+
+  /** Name of the repository the plugin comes from */
+  repository: string;
+}
+
+export class Repositories extends Service {
+  /**
+   * Returns the repositories configured in the Crankshaft config, in priority
+   * order.
+   */
+  async list() {
+    const { getRes } = this.smm.rpcRequest<{}, { repositories: Repository[] }>(
+      'RepositoryService.List',
+      {}
+    );
+    return (await getRes()).repositories;
+  }
+
+  /**
+   * Returns the plugins listed in every repository. When more than one
+   * repository lists a plugin, the server picks which one it comes from.
+   *
+   * @param refresh Fetch the repositories now instead of using the indexes
+   * fetched last
+   */
+  async plugins(refresh: boolean = false) {
+    const { getRes } = this.smm.rpcRequest<
+      { refresh: boolean },
+      { plugins: Record<string, RepositoryPlugin>; lastFetched: string }
+    >('RepositoryService.Plugins', { refresh });
+    return getRes();
+  }
+}
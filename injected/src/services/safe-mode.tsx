@@ -0,0 +1,136 @@
+import { FunctionComponent, render } from 'preact';
+import { useCallback, useState } from 'preact/hooks';
+import { deleteAll } from '../util';
+import { Service } from './service';
+
+export interface SafeModeStatus {
+  active: boolean;
+  forced: boolean;
+  failedStartups: number;
+  pluginErrors: number;
+  lastPlugin?: string;
+  lastError?: string;
+}
+
+export class SafeMode extends Service {
+  async status() {
+    const { getRes } = this.smm.rpcRequest<{}, { status: SafeModeStatus }>(
+      'SafeModeService.Status',
+      {}
+    );
+    return (await getRes()).status;
+  }
+
+  /**
+   * @internal
+   */
+  async reportPluginError(pluginId: string, error: string) {
+    const { getRes } = this.smm.rpcRequest<
+      { pluginId: string; error: string },
+      {}
+    >('SafeModeService.ReportPluginError', { pluginId, error });
+    await getRes();
+  }
+
+  /**
+   * @internal
+   */
+  async pluginsLoaded() {
+    const { getRes } = this.smm.rpcRequest<{}, {}>(
+      'SafeModeService.PluginsLoaded',
+      {}
+    );
+    await getRes();
+  }
+
+  /**
+   * Turns off safe mode and loads plugins again.
+   */
+  async exit() {
+    const { getRes } = this.smm.rpcRequest<{}, {}>('SafeModeService.Exit', {});
+    await getRes();
+
+    deleteAll('[data-smm-safe-mode-banner]');
+    await this.smm.loadPlugins();
+  }
+
+  /**
+   * @internal
+   * Shows a banner explaining why plugins weren't loaded if Crankshaft is in
+   * safe mode.
+   */
+  async showBanner() {
+    const status = await this.status();
+
+    deleteAll('[data-smm-safe-mode-banner]');
+    if (!status.active) {
+      return;
+    }
+
+    render(
+      <SafeModeBanner status={status} onExit={() => this.exit()} />,
+      document.body.appendChild(document.createElement('div'))
+    );
+  }
+}
+
+const SafeModeBanner: FunctionComponent<{
+  status: SafeModeStatus;
+  onExit: () => Promise<void>;
+}> = ({ status, onExit }) => {
+  const [exiting, setExiting] = useState(false);
+
+  const handleExit = useCallback(async () => {
+    setExiting(true);
+    try {
+      await onExit();
+    } finally {
+      setExiting(false);
+    }
+  }, [onExit, setExiting]);
+
+  let reason = 'Crankshaft was started with -safe-mode.';
+  if (!status.forced) {
+    reason = 'Steam failed to start with plugins several times.';
+    if (status.lastPlugin) {
+      reason += ` The last plugin loaded was ${status.lastPlugin}`;
+      reason += status.lastError ? `: ${status.lastError}` : '.';
+    }
+  }
+
+  return (
+    <div
+      data-smm-safe-mode-banner={true}
+      style={{
+        position: 'absolute',
+        bottom: 0,
+        left: 0,
+        right: 0,
+        zIndex: 999999,
+
+        display: 'flex',
+        justifyContent: 'center',
+        alignItems: 'center',
+        gap: 12,
+
+        backgroundColor: '#d9a300',
+        padding: '6px 12px',
+
+        color: 'black',
+        fontSize: 12,
+      }}
+    >
+      <span>
+        Crankshaft is in safe mode, plugins haven't been loaded. {reason}
+      </span>
+      <button
+        className="cs-button"
+        onClick={handleExit}
+        disabled={exiting}
+        style={{ flexShrink: 0 }}
+      >
+        Exit safe mode
+      </button>
+    </div>
+  );
+};
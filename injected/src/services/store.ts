@@ -1,15 +1,57 @@
-import { rpcRequest } from '../rpc';
 import { Service } from './service';
 
+interface StoreNamespace {
+  // Plugin ID of the namespace. Plugins can only use their own, which the
+  // server knows from their auth token.
+  bucket: string;
+  // Use the namespace shared between plugins instead, this requires the
+  // `sharedStore` permission
+  shared: boolean;
+}
+
 export class Store extends Service {
   async get(pluginId: string, key: string) {
-    const { getRes } = rpcRequest<
-      { bucket: string; key: string },
+    return this._get({ bucket: pluginId, shared: false }, key);
+  }
+
+  async set(pluginId: string, key: string, value: string) {
+    return this._set({ bucket: pluginId, shared: false }, key, value);
+  }
+
+  async delete(pluginId: string, key: string) {
+    return this._delete({ bucket: pluginId, shared: false }, key);
+  }
+
+  /**
+   * Returns how many bytes the plugin has stored, and its quota in bytes. A
+   * quota of 0 means there's no limit.
+   */
+  async usage(pluginId: string) {
+    const { getRes } = this.smm.rpcRequest<
+      StoreNamespace,
+      { size: number; quota: number }
+    >('StoreService.Usage', { bucket: pluginId, shared: false });
+
+    return getRes();
+  }
+
+  async getShared(key: string) {
+    return this._get({ bucket: '', shared: true }, key);
+  }
+
+  async setShared(key: string, value: string) {
+    return this._set({ bucket: '', shared: true }, key, value);
+  }
+
+  async deleteShared(key: string) {
+    return this._delete({ bucket: '', shared: true }, key);
+  }
+
+  private async _get(namespace: StoreNamespace, key: string) {
+    const { getRes } = this.smm.rpcRequest<
+      StoreNamespace & { key: string },
       { found: boolean; value: string }
-    >('StoreService.Get', {
-      bucket: pluginId,
-      key,
-    });
+    >('StoreService.Get', { ...namespace, key });
 
     const res = await getRes();
 
@@ -20,15 +62,20 @@ export class Store extends Service {
     return undefined;
   }
 
-  async set(pluginId: string, key: string, value: string) {
-    const { getRes } = rpcRequest<
-      {
-        bucket: string;
-        key: string;
-        value: string;
-      },
+  private async _set(namespace: StoreNamespace, key: string, value: string) {
+    const { getRes } = this.smm.rpcRequest<
+      StoreNamespace & { key: string; value: string },
+      {}
+    >('StoreService.Set', { ...namespace, key, value });
+
+    return getRes();
+  }
+
+  private async _delete(namespace: StoreNamespace, key: string) {
+    const { getRes } = this.smm.rpcRequest<
+      StoreNamespace & { key: string },
       {}
-    >('StoreService.Set', { bucket: pluginId, key, value });
+    >('StoreService.Delete', { ...namespace, key });
 
     return getRes();
   }
@@ -1,6 +1,6 @@
 import classNames from 'classnames';
 import { dcCreateElement } from '../../dom-chef';
-import { GP_FOCUS_CLASS } from '../../gamepad';
+import { getActiveGamepadHandler, GP_FOCUS_CLASS } from '../../gamepad';
 import { BTN_CODE } from '../../gamepad/buttons';
 
 // @use-dom-chef
@@ -58,7 +58,7 @@ export const createConfirmModal = ({
 }) => {
   const uiMode = window.smmUIMode;
 
-  const gamepadEnabled = Boolean(window.smm?.activeGamepadHandler);
+  const gamepadEnabled = Boolean(getActiveGamepadHandler());
 
   const confirmButton = dcCreateElement<HTMLButtonElement>(
     <button
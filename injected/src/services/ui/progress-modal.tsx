@@ -1,6 +1,6 @@
 import classNames from 'classnames';
 import { dcCreateElement } from '../../dom-chef';
-import { GP_FOCUS_CLASS } from '../../gamepad';
+import { getActiveGamepadHandler, GP_FOCUS_CLASS } from '../../gamepad';
 import { BTN_CODE } from '../../gamepad/buttons';
 import { formatBytes } from '../../util';
 import { DownloadProgress } from '../network';
@@ -22,7 +22,7 @@ export const createProgressModal = ({
 }) => {
   const uiMode = window.smmUIMode;
 
-  const gamepadEnabled = Boolean(window.smm?.activeGamepadHandler);
+  const gamepadEnabled = Boolean(getActiveGamepadHandler());
 
   const progressText = dcCreateElement<HTMLHeadingElement>(
     <h3
@@ -1,9 +1,16 @@
 import { AppPropertiesMenu } from './app-properties-menu';
-import { GamepadHandler } from './gamepad';
+import {
+  GamepadHandler,
+  getActiveGamepadHandler,
+  setActiveGamepadHandler,
+} from './gamepad';
 import { ButtonInterceptors } from './gamepad/button-interceptors';
 import { InGameMenu } from './in-game-menu';
 import { MenuManager } from './menu-manager';
+import { createRpcRequest, RpcRequest } from './rpc';
 import { Apps } from './services/apps';
+import { Backend } from './services/backend';
+import { Config } from './services/config';
 import { Exec } from './services/exec';
 import { FS } from './services/fs';
 import { Inject } from './services/inject';
@@ -11,6 +18,8 @@ import { IPC } from './services/ipc';
 import { Network } from './services/network';
 import { Patch } from './services/patch';
 import { Plugins } from './services/plugins';
+import { Repositories } from './services/repositories';
+import { SafeMode } from './services/safe-mode';
 import { Store } from './services/store';
 import { Toast } from './services/toast';
 import { UI } from './services/ui';
@@ -91,6 +100,56 @@ class EventLockScreenClosed extends CustomEvent<void> {
   }
 }
 
+// Plugin instances keep their core instance and the event target they share
+// with it here, rather than in properties a plugin could read
+const coreInstances = new WeakMap<SMM, SMM>();
+const eventTargets = new WeakMap<SMM, EventTarget>();
+
+/**
+ * Returns an object with the methods of an object the core instance owns,
+ * without the object itself, which holds a reference to the core instance.
+ * Render callbacks passed to the methods get the plugin's instance.
+ */
+const exposeMethods = <T extends object>(target: T, smm: SMM): T => {
+  const exposed: Record<string, unknown> = {};
+  for (
+    let obj: object | null = target;
+    obj && obj !== Object.prototype;
+    obj = Object.getPrototypeOf(obj)
+  ) {
+    for (const name of Object.getOwnPropertyNames(obj)) {
+      const { value } = Object.getOwnPropertyDescriptor(obj, name)!;
+      if (
+        name === 'constructor' ||
+        name.startsWith('_') ||
+        name in exposed ||
+        typeof value !== 'function'
+      ) {
+        continue;
+      }
+
+      exposed[name] = (...args: unknown[]) =>
+        value.apply(
+          target,
+          args.map((arg) => withPluginRender(arg, smm))
+        );
+    }
+  }
+  return exposed as T;
+};
+
+const withPluginRender = (arg: unknown, smm: SMM) => {
+  if (typeof (arg as { render?: unknown })?.render !== 'function') {
+    return arg;
+  }
+
+  const { render } = arg as { render: (...args: unknown[]) => unknown };
+  return {
+    ...(arg as object),
+    render: (_: SMM, ...args: unknown[]) => render(smm, ...args),
+  };
+};
+
 /**
  * @public
  */
@@ -120,6 +179,7 @@ export class SMM extends EventTarget {
   readonly AppPropertiesMenu!: AppPropertiesMenu;
   readonly FS: FS;
   readonly Plugins: Plugins;
+  readonly Repositories: Repositories;
   readonly IPC: IPC;
   readonly UI: UI;
   readonly Exec: Exec;
@@ -127,29 +187,40 @@ export class SMM extends EventTarget {
   readonly Store: Store;
   readonly ButtonInterceptors: ButtonInterceptors;
   readonly Apps: Apps;
+  readonly Backend: Backend;
   readonly Patch: Patch;
+  readonly SafeMode: SafeMode;
+  readonly Config: Config;
 
   readonly serverPort: string;
 
-  // The current plugin that we're loading
-  // This is set before calling a plugin's load method, so that we can keep
-  // track of which events the plugin attaches, and remove them when we unload
-  // that plugin.
-  private currentPlugin?: string;
-  // Events attached by plugins
-  private attachedEvents: Record<
-    PluginId,
-    {
-      type: AddEventListenerArgs[0];
-      callback: AddEventListenerArgs[1];
-      options: AddEventListenerArgs[2];
-    }[]
-  >;
-
-  constructor(entry: Entry) {
+  /**
+   * Makes RPC requests with this instance's auth token. Plugins get their own
+   * instance, where this uses the plugin's scoped token.
+   * @internal
+   */
+  readonly rpcRequest: RpcRequest;
+
+  // Events attached through this instance, so they can be removed when the
+  // plugin it was created for is unloaded
+  private attachedEvents: {
+    type: AddEventListenerArgs[0];
+    callback: AddEventListenerArgs[1];
+    options: AddEventListenerArgs[2];
+  }[];
+  // Instances passed to plugins
+  private pluginInstances: Record<PluginId, SMM>;
+
+  /**
+   * Plugins get their own instance created from the core one, with their own
+   * RPC services. Everything else is shared with the core instance through
+   * objects that don't refer to it.
+   */
+  constructor(entry: Entry, rpcRequest: RpcRequest, core?: SMM) {
     super();
 
     this.entry = entry;
+    this.rpcRequest = rpcRequest;
 
     this._currentTab = undefined;
     this._currentAppId = undefined;
@@ -157,14 +228,46 @@ export class SMM extends EventTarget {
     this._onLockScreen = false;
 
     this.Network = new Network(this);
-    this.Toast = new Toast(this);
     this.FS = new FS(this);
     this.IPC = new IPC(this);
     this.Plugins = new Plugins(this);
+    this.Repositories = new Repositories(this);
     this.UI = new UI(this);
     this.Exec = new Exec(this);
     this.Inject = new Inject(this);
     this.Store = new Store(this);
+    this.Backend = new Backend(this);
+    this.SafeMode = new SafeMode(this);
+    this.Config = new Config(this);
+
+    this.serverPort = window.smmServerPort;
+
+    this.attachedEvents = [];
+    this.pluginInstances = {};
+
+    if (core) {
+      coreInstances.set(this, core);
+      eventTargets.set(this, eventTargets.get(core)!);
+
+      this.Toast = exposeMethods(core.Toast, this);
+      this.ButtonInterceptors = exposeMethods(core.ButtonInterceptors, this);
+      this.Apps = exposeMethods(core.Apps, this);
+      this.Patch = exposeMethods(core.Patch, this);
+      if (core.MenuManager) {
+        this.MenuManager = exposeMethods(core.MenuManager, this);
+      }
+      if (core.InGameMenu) {
+        this.InGameMenu = exposeMethods(core.InGameMenu, this);
+      }
+      if (core.AppPropertiesMenu) {
+        this.AppPropertiesMenu = exposeMethods(core.AppPropertiesMenu, this);
+      }
+      return;
+    }
+
+    eventTargets.set(this, new EventTarget());
+
+    this.Toast = new Toast(this);
     this.ButtonInterceptors = new ButtonInterceptors(this);
     this.Apps = new Apps(this);
     this.Patch = new Patch(this);
@@ -183,22 +286,18 @@ export class SMM extends EventTarget {
     ) {
       this.AppPropertiesMenu = new AppPropertiesMenu(this);
     }
-
-    this.serverPort = window.smmServerPort;
-
-    this.attachedEvents = {};
   }
 
   get currentTab() {
-    return this._currentTab;
+    return (coreInstances.get(this) ?? this)._currentTab;
   }
 
   get currentAppId() {
-    return this._currentAppId;
+    return (coreInstances.get(this) ?? this)._currentAppId;
   }
 
   get onLockScreen() {
-    return this._onLockScreen;
+    return (coreInstances.get(this) ?? this)._onLockScreen;
   }
 
   /**
@@ -333,10 +432,21 @@ export class SMM extends EventTarget {
     for (const [name, { load, unload }] of Object.entries(
       window.smmPlugins ?? {}
     )) {
-      if (plugins[name]?.enabled) {
-        await this.loadPlugin(name);
+      if (plugins[name]?.enabledModes[window.smmUIMode]) {
+        // Keep going so one broken plugin doesn't stop the rest from loading
+        try {
+          await this.loadPlugin(name);
+        } catch (err) {
+          this.Toast.addToast(`Error loading ${name}: ${err}`, 'error');
+          await this.SafeMode.reportPluginError(name, String(err));
+        }
       }
     }
+
+    // Once the library has loaded plugins, Steam started successfully
+    if (this.entry === 'library') {
+      await this.SafeMode.pluginsLoaded();
+    }
   }
 
   /**
@@ -349,10 +459,15 @@ export class SMM extends EventTarget {
       return;
     }
 
+    const authToken = await this.Plugins.authToken(pluginId);
+    this.pluginInstances[pluginId] = new SMM(
+      this.entry,
+      createRpcRequest(authToken),
+      this
+    );
+
     info(`Loading plugin ${pluginId}...`);
-    this.currentPlugin = pluginId;
-    await window.smmPlugins[pluginId].load(this);
-    this.currentPlugin = undefined;
+    await window.smmPlugins[pluginId].load(this.pluginInstances[pluginId]);
   }
 
   /**
@@ -364,13 +479,15 @@ export class SMM extends EventTarget {
     }
 
     info(`Unloading plugin ${pluginId}...`);
-    await window.smmPlugins[pluginId]?.unload?.(this);
+    const instance = this.pluginInstances[pluginId];
+    if (instance) {
+      await window.smmPlugins[pluginId]?.unload?.(instance);
+      delete this.pluginInstances[pluginId];
 
-    if (this.attachedEvents[pluginId]) {
-      for (const { type, callback, options } of this.attachedEvents[pluginId]) {
-        this.removeEventListener(type, callback, options);
+      for (const { type, callback, options } of instance.attachedEvents) {
+        instance.removeEventListener(type, callback, options);
       }
-      delete this.attachedEvents[pluginId];
+      instance.IPC.close();
     }
   }
 
@@ -379,19 +496,23 @@ export class SMM extends EventTarget {
     callback: EventListenerOrEventListenerObject | null,
     options?: boolean | AddEventListenerOptions
   ): void {
-    if (this.currentPlugin) {
-      if (!this.attachedEvents[this.currentPlugin]) {
-        this.attachedEvents[this.currentPlugin] = [];
-      }
-
-      this.attachedEvents[this.currentPlugin].push({ type, callback, options });
-    }
+    this.attachedEvents.push({ type, callback, options });
+    eventTargets.get(this)!.addEventListener(type, callback, options);
+  }
 
-    super.addEventListener(type, callback, options);
+  removeEventListener(
+    type: SMMEventType,
+    callback: EventListenerOrEventListenerObject | null,
+    options?: boolean | EventListenerOptions
+  ): void {
+    this.attachedEvents = this.attachedEvents.filter(
+      (event) => event.type !== type || event.callback !== callback
+    );
+    eventTargets.get(this)!.removeEventListener(type, callback, options);
   }
 
   dispatchEvent(event: SMMEvent): boolean {
-    return super.dispatchEvent(event);
+    return eventTargets.get(this)!.dispatchEvent(event);
   }
 
   /**
@@ -403,17 +524,38 @@ export class SMM extends EventTarget {
   }
 
   // Currently active gamepad handler
-  // This should only be set by internal Crankshaft code
-  private _activeGamepadHandler?: GamepadHandler;
-
+  // Handlers hold the core instance, so this only exposes their methods
   public get activeGamepadHandler() {
-    return this._activeGamepadHandler;
+    const handler = getActiveGamepadHandler();
+    return handler && exposeMethods(handler, this);
   }
 
   /**
    * @internal
    */
   _setActiveGamepadHandler(gamepadHandler: GamepadHandler | undefined) {
-    this._activeGamepadHandler = gamepadHandler;
+    setActiveGamepadHandler(gamepadHandler);
   }
 }
+
+/**
+ * The methods Crankshaft calls on window.smm, from the scripts it evaluates and
+ * from patched Steam code
+ */
+export type SMMHooks = Pick<
+  SMM,
+  'loadPlugin' | 'unloadPlugin' | 'closeActivePluginPage'
+>;
+
+/**
+ * Sets window.smm for an entrypoint's instance. Plugins can reach window too,
+ * so it only gets the hooks and not the instance itself.
+ */
+export const exposeOnWindow = (smm: SMM) => {
+  const hooks: SMMHooks = {
+    loadPlugin: (pluginId) => smm.loadPlugin(pluginId),
+    unloadPlugin: (pluginId) => smm.unloadPlugin(pluginId),
+    closeActivePluginPage: () => smm.closeActivePluginPage(),
+  };
+  window.smm = hooks;
+};
@@ -1,4 +1,4 @@
-import type { SMM } from '../smm';
+import type { SMM, SMMHooks } from '../smm';
 
 export interface AppPropsApp {
   app_type: number;
@@ -43,7 +43,7 @@ export interface AppPropsApp {
 
 declare global {
   export interface Window {
-    smm?: SMM;
+    smm?: SMMHooks;
     smmTabObserver?: MutationObserver;
     smmServerPort: string;
     smmUIMode: 'desktop' | 'deck';
@@ -53,10 +53,15 @@ declare global {
       string,
       {
         load: (smm: SMM) => void | Promise<void>;
+        /**
+         * Called in every target the plugin was loaded into when it's disabled
+         * or reinjected, it should undo anything `load` did.
+         */
         unload?: (smm: SMM) => void | Promise<void>;
       }
     >;
-    csAuthToken: string;
+    // Only set while the core script is being evaluated, see rpc.ts
+    csCoreAuthToken: string;
 
     csPluginsLoaded?: () => void;
 
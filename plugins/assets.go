@@ -0,0 +1,56 @@
+package plugins
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrAssetNotFound is returned by AssetPath when the plugin or asset doesn't
+// exist, or the asset path points outside of the plugin's assets directory.
+var ErrAssetNotFound = errors.New("Asset not found")
+
+// AssetPath returns the path of a file in the plugin's assets directory.
+// assetPath is slash separated and relative to the assets directory. Paths
+// that would leave the assets directory, including through symlinks, and
+// directories return ErrAssetNotFound.
+func (p *Plugins) AssetPath(pluginId, assetPath string) (string, error) {
+	plugin, ok := p.Get(pluginId)
+	if !ok {
+		return "", ErrAssetNotFound
+	}
+
+	assetsDir, err := filepath.EvalSymlinks(filepath.Join(plugin.Dir, "assets"))
+	if err != nil {
+		return "", ErrAssetNotFound
+	}
+
+	// Cleaning the path as if it was absolute removes any ".." that would
+	// leave the assets directory
+	cleanPath := filepath.FromSlash(strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+assetPath)), "/"))
+	if cleanPath == "" {
+		return "", ErrAssetNotFound
+	}
+
+	filePath, err := filepath.EvalSymlinks(filepath.Join(assetsDir, cleanPath))
+	if err != nil {
+		return "", ErrAssetNotFound
+	}
+
+	rel, err := filepath.Rel(assetsDir, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrAssetNotFound
+	}
+
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return "", ErrAssetNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return filePath, nil
+}
@@ -0,0 +1,45 @@
+package plugins
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetPath(t *testing.T) {
+	plugins := newTestPlugins(t)
+	writeTestPlugin(t, plugins.pluginsDir, "with-assets", map[string]string{
+		"plugin.toml":          testPluginToml,
+		"dist/index.js":        "export const load = () => {};",
+		"assets/logo.png":      "png",
+		"assets/fonts/a.woff2": "woff2",
+		"secret.txt":           "secret",
+	})
+	if err := os.Symlink(filepath.Join(plugins.pluginsDir, "with-assets", "secret.txt"), filepath.Join(plugins.pluginsDir, "with-assets", "assets", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, assetPath := range []string{"logo.png", "fonts/a.woff2", "fonts/../logo.png"} {
+		filePath, err := plugins.AssetPath("with-assets", assetPath)
+		if err != nil {
+			t.Fatalf("AssetPath(%q) returned error: %v", assetPath, err)
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, assetPath := range []string{"", "fonts", "missing.png", "../secret.txt", "../../with-assets/secret.txt", "link.txt"} {
+		if _, err := plugins.AssetPath("with-assets", assetPath); !errors.Is(err, ErrAssetNotFound) {
+			t.Fatalf("Expected AssetPath(%q) to return ErrAssetNotFound, got %v", assetPath, err)
+		}
+	}
+
+	if _, err := plugins.AssetPath("missing-plugin", "logo.png"); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("Expected ErrAssetNotFound for missing plugin, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/semver"
+)
+
+// PluginBackup is a previously installed version of a plugin that can be
+// restored with Rollback.
+type PluginBackup struct {
+	Version string `json:"version"`
+	// When the version was replaced
+	BackedUpAt time.Time `json:"backedUpAt"`
+}
+
+// Backups returns the backed up versions of the plugin, newest first.
+func (p *Plugins) Backups(pluginId string) ([]PluginBackup, error) {
+	d, err := os.ReadDir(path.Join(p.backupsDir, pluginId))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []PluginBackup{}, nil
+		}
+		return nil, fmt.Errorf(`Error reading backups for plugin "%s": %v`, pluginId, err)
+	}
+
+	backups := []PluginBackup{}
+	for _, entry := range d {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, PluginBackup{
+			Version:    entry.Name(),
+			BackedUpAt: info.ModTime(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].BackedUpAt.Equal(backups[j].BackedUpAt) {
+			return backups[i].BackedUpAt.After(backups[j].BackedUpAt)
+		}
+		// Fall back to the version for backups made at the same time, in case
+		// the filesystem's timestamps aren't precise enough
+		a, aErr := semver.Parse(backups[i].Version)
+		b, bErr := semver.Parse(backups[j].Version)
+		if aErr != nil || bErr != nil {
+			return backups[i].Version > backups[j].Version
+		}
+		return a.Compare(b) > 0
+	})
+
+	return backups, nil
+}
+
+// Rollback restores a backed up version of the plugin. The version being
+// replaced is backed up in turn, so the rollback can be undone.
+func (p *Plugins) Rollback(pluginId, version string) error {
+	if !isValidBackupName(pluginId) || !isValidBackupName(version) {
+		return fmt.Errorf(`Invalid plugin backup "%s" version "%s"`, pluginId, version)
+	}
+
+	p.installMu.Lock()
+	defer p.installMu.Unlock()
+
+	backupDir := path.Join(p.backupsDir, pluginId, version)
+	if _, err := os.Lstat(backupDir); err != nil {
+		return fmt.Errorf(`Plugin "%s" doesn't have a backup of version "%s"`, pluginId, version)
+	}
+
+	stagingDir, err := os.MkdirTemp(p.pluginsDir, ".rollback-")
+	if err != nil {
+		return fmt.Errorf("Error creating staging directory: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Printf(`Error removing staging directory "%s": %v`, stagingDir, err)
+		}
+	}()
+
+	log.Printf("Rolling back plugin \"%s\" to version %s...\n", pluginId, version)
+
+	restoredDir := path.Join(stagingDir, "restored")
+	if err := moveDir(backupDir, restoredDir); err != nil {
+		return fmt.Errorf(`Error restoring backup of plugin "%s": %v`, pluginId, err)
+	}
+	putBackup := func(dir string) {
+		if err := moveDir(dir, backupDir); err != nil {
+			log.Printf("Error putting back backup of plugin \"%s\": %v\n", pluginId, err)
+		}
+	}
+
+	rollback, err := p.movePluginIntoPlace(pluginId, restoredDir, stagingDir)
+	if err != nil {
+		putBackup(restoredDir)
+		return err
+	}
+
+	if err := p.Reload(); err != nil {
+		log.Printf("Error reloading plugins after rolling back \"%s\", undoing: %v\n", pluginId, err)
+		// Undoing removes the restored version, so put it back in the backups
+		// first
+		putBackup(path.Join(p.pluginsDir, pluginId))
+		if rollbackErr := rollback(); rollbackErr != nil {
+			log.Printf("Error undoing rollback of \"%s\": %v\n", pluginId, rollbackErr)
+		}
+		if reloadErr := p.Reload(); reloadErr != nil {
+			log.Println(reloadErr)
+		}
+		return fmt.Errorf(`Error loading restored plugin "%s": %v`, pluginId, err)
+	}
+
+	p.backupPrevious(pluginId, stagingDir)
+
+	return nil
+}
+
+// backupPrevious backs up the previous version of a plugin that
+// movePluginIntoPlace moved into the staging directory, if there was one.
+// Errors are logged rather than returned, since the new version is already in
+// place by the time it's called.
+func (p *Plugins) backupPrevious(pluginId, stagingDir string) {
+	previousDir := path.Join(stagingDir, "previous")
+	if _, err := os.Lstat(previousDir); err != nil {
+		return
+	}
+
+	if err := p.backupPlugin(pluginId, previousDir); err != nil {
+		log.Printf("Error backing up previous version of plugin \"%s\": %v\n", pluginId, err)
+	}
+}
+
+// backupPlugin moves a plugin directory into the plugin's backups, replacing
+// any existing backup of the same version, and removes the oldest backups
+// over the limit.
+func (p *Plugins) backupPlugin(pluginId, pluginDir string) error {
+	version := "unknown"
+	if config, err := NewPluginConfig(pluginDir); err == nil && isValidBackupName(config.Version) {
+		version = config.Version
+	}
+
+	pluginBackupsDir := path.Join(p.backupsDir, pluginId)
+	if err := os.MkdirAll(pluginBackupsDir, 0755); err != nil {
+		return err
+	}
+
+	backupDir := path.Join(pluginBackupsDir, version)
+	if err := os.RemoveAll(backupDir); err != nil {
+		return err
+	}
+	if err := moveDir(pluginDir, backupDir); err != nil {
+		return err
+	}
+
+	// Backups are ordered by modification time, so mark this one as the newest
+	now := time.Now()
+	if err := os.Chtimes(backupDir, now, now); err != nil {
+		log.Printf("Error updating backup time of plugin \"%s\": %v\n", pluginId, err)
+	}
+
+	log.Printf("Backed up plugin \"%s\" version %s\n", pluginId, version)
+
+	return p.pruneBackups(pluginId)
+}
+
+// pruneBackups removes the plugin's oldest backups over the limit set in the
+// Crankshaft config.
+func (p *Plugins) pruneBackups(pluginId string) error {
+	p.mu.RLock()
+	keep := p.crksftConfig.GetKeepPluginBackups()
+	p.mu.RUnlock()
+
+	backups, err := p.Backups(pluginId)
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(backups); i++ {
+		if err := os.RemoveAll(path.Join(p.backupsDir, pluginId, backups[i].Version)); err != nil {
+			return fmt.Errorf(`Error removing old backup of plugin "%s": %v`, pluginId, err)
+		}
+	}
+
+	return nil
+}
+
+// isValidBackupName returns if name can safely be used as a single path
+// component in the backups directory.
+func isValidBackupName(name string) bool {
+	return name != "" &&
+		!strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, `/\`) &&
+		filepath.Base(name) == name
+}
+
+// moveDir moves a directory, falling back to copying it if it's moved to a
+// different filesystem.
+func moveDir(src, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyDir(src, dest); err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyDir recursively copies a directory, keeping symlinks as they are.
+func copyDir(src, dest string) error {
+	return filepath.WalkDir(src, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, destPath)
+		case entry.IsDir():
+			return os.MkdirAll(destPath, info.Mode().Perm())
+		}
+
+		in, err := os.Open(srcPath)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	})
+}
@@ -0,0 +1,90 @@
+package plugins
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+// installTestVersion installs a version of test-plugin whose script logs the
+// version.
+func installTestVersion(t *testing.T, plugins *Plugins, version string) {
+	t.Helper()
+
+	url, sum := serveTestArchive(t, makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginTomlRequiring(version, ""),
+		"test-plugin/dist/index.js": fmt.Sprintf("export const load = () => console.log(%q);", version),
+	}))
+	if _, err := plugins.Install(url, sum, "", true); err != nil {
+		t.Fatalf("Install of version %v returned error: %v", version, err)
+	}
+}
+
+func TestInstallKeepsBackups(t *testing.T) {
+	plugins := newTestPlugins(t)
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+		installTestVersion(t, plugins, version)
+	}
+
+	backups, err := plugins.Backups("test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the newest backups should be kept
+	expected := []string{"1.3.0", "1.2.0", "1.1.0"}
+	if len(backups) != len(expected) {
+		t.Fatalf("Expected %v backups, got %v", len(expected), backups)
+	}
+	for i, backup := range backups {
+		if backup.Version != expected[i] {
+			t.Fatalf(`Expected backup %d to be version "%v", got "%v"`, i, expected[i], backup.Version)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	plugins := newTestPlugins(t)
+
+	installTestVersion(t, plugins, "1.0.0")
+	installTestVersion(t, plugins, "2.0.0")
+
+	if err := plugins.Rollback("test-plugin", "1.0.0"); err != nil {
+		t.Fatalf("Rollback returned error: %v", err)
+	}
+
+	plugin, _ := plugins.Get("test-plugin")
+	if plugin.Config.Version != "1.0.0" {
+		t.Fatalf(`Expected version "1.0.0" after rollback, got "%v"`, plugin.Config.Version)
+	}
+
+	// The version that was rolled back should be kept so it can be restored
+	backups, err := plugins.Backups("test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Version != "2.0.0" {
+		t.Fatalf(`Expected only a backup of "2.0.0" after rollback, got %v`, backups)
+	}
+
+	if err := plugins.Rollback("test-plugin", "3.0.0"); err == nil {
+		t.Fatal("Rollback expected error for missing version, got nil")
+	}
+	if err := plugins.Rollback("test-plugin", "../test-plugin"); err == nil {
+		t.Fatal("Rollback expected error for invalid version, got nil")
+	}
+
+	// Failed rollbacks shouldn't leave anything behind in the plugins directory
+	entries, err := os.ReadDir(plugins.pluginsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the installed plugin in plugins directory, got %v entries", len(entries))
+	}
+	if _, err := os.Stat(path.Join(plugins.pluginsDir, "test-plugin", "plugin.toml")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,39 +1,185 @@
 package plugins
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"git.sr.ht/~avery/crankshaft/build"
 	"github.com/evanw/esbuild/pkg/api"
 )
 
-func buildPluginScript(pluginName, pluginDir string) (string, error) {
+// buildPluginScript builds the script to inject for the plugin and its source
+// map. If the plugin's config declares a build entry point, the plugin is
+// bundled from source and sourceFiles lists the files the bundle was built
+// from, otherwise its prebuilt dist/index.js is used.
+func buildPluginScript(pluginName, pluginDir string, config *pluginConfig) (script, sourceMap string, sourceFiles []string, err error) {
+	if config.Build.Entry != "" {
+		return bundlePluginScript(pluginName, pluginDir, config.Build.Entry)
+	}
+
 	indexJsPath := path.Join(pluginDir, "dist", "index.js")
 	data, err := os.ReadFile(indexJsPath)
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
 		if errors.Is(err, fs.ErrNotExist) {
-			return "", fmt.Errorf(`[Plugin %s]: index.js not found at "%s" - %v`, pluginName, indexJsPath, err)
+			return "", "", nil, fmt.Errorf(`[Plugin %s]: index.js not found at "%s" - %v`, pluginName, indexJsPath, err)
 		}
-		return "", err
+		return "", "", nil, err
 	}
 
-	script := string(data)
-
-	res := api.Transform(script, api.TransformOptions{
+	res := api.Transform(string(data), api.TransformOptions{
 		Format:     api.FormatIIFE,
-		GlobalName: "smmPlugins['" + pluginName + "']",
+		GlobalName: pluginGlobalName(pluginName),
 		Target:     build.Target,
 		Engines:    build.Engines,
+		Sourcemap:  api.SourceMapExternal,
+		SourceRoot: pluginSourceRoot(pluginName),
+		Sourcefile: path.Join("dist", "index.js"),
 	})
 	if len(res.Errors) > 0 {
-		log.Println(res.Errors)
-		return "", fmt.Errorf("Error transforming plugin script.")
+		return "", "", nil, fmt.Errorf("[Plugin %s]: Error transforming plugin script:\n%s", pluginName, formatBuildMessages(pluginDir, res.Errors))
+	}
+
+	return string(res.Code), string(res.Map), nil, nil
+}
+
+// bundlePluginScript bundles the plugin from its source entry point. Imports
+// are resolved relative to the plugin, including packages vendored in its
+// node_modules. Plugins are built the same way as the injected scripts, with
+// the Preact shim and dom-chef support.
+func bundlePluginScript(pluginName, pluginDir, entry string) (script, sourceMap string, sourceFiles []string, err error) {
+	entryPath := filepath.Join(pluginDir, entry)
+	if _, err := os.Stat(entryPath); err != nil {
+		return "", "", nil, fmt.Errorf(`[Plugin %s]: build entry not found at "%s" - %v`, pluginName, entryPath, err)
+	}
+
+	// The Preact shim is only injected for plugins that vendor Preact, plugins
+	// that don't use JSX shouldn't need it to build
+	inject := []string{}
+	if _, err := os.Stat(filepath.Join(pluginDir, "node_modules", "preact")); err == nil {
+		inject = append(inject, build.PreactShimPath(pluginDir))
+	}
+
+	res := api.Build(api.BuildOptions{
+		EntryPoints:   []string{entryPath},
+		AbsWorkingDir: pluginDir,
+		Bundle:        true,
+		Format:        api.FormatIIFE,
+		GlobalName:    pluginGlobalName(pluginName),
+		Target:        build.Target,
+		Engines:       build.Engines,
+		JSXFactory:    "h",
+		JSXFragment:   "DocumentFragment",
+		Inject:        inject,
+		Loader: map[string]api.Loader{
+			".svg": api.LoaderDataURL,
+			".css": api.LoaderText,
+		},
+		Define: map[string]string{
+			"process": `{"env":{"NODE_ENV":"production"}}`,
+		},
+		Plugins: []api.Plugin{
+			build.PreactShimPlugin(pluginDir),
+			build.DomChefPlugin(),
+		},
+		Sourcemap:  api.SourceMapExternal,
+		SourceRoot: pluginSourceRoot(pluginName),
+		// Nothing is written, the output directory only makes the paths in the
+		// source map relative to the plugin
+		Outdir:   pluginDir,
+		Write:    false,
+		Metafile: true,
+	})
+	if len(res.Errors) > 0 {
+		return "", "", nil, fmt.Errorf("[Plugin %s]: Error building plugin script:\n%s", pluginName, formatBuildMessages(pluginDir, res.Errors))
+	}
+	for _, warning := range res.Warnings {
+		log.Printf("[Plugin %s]: [WARN] %s\n", pluginName, formatBuildMessage(pluginDir, warning))
+	}
+
+	for _, file := range res.OutputFiles {
+		if strings.HasSuffix(file.Path, ".map") {
+			sourceMap = string(file.Contents)
+		} else {
+			script = string(file.Contents)
+		}
+	}
+	if script == "" {
+		return "", "", nil, fmt.Errorf("[Plugin %s]: Build didn't produce a script", pluginName)
+	}
+
+	sourceFiles, err = metafileInputs(pluginDir, res.Metafile)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("[Plugin %s]: Error reading build metafile: %v", pluginName, err)
+	}
+
+	return script, sourceMap, sourceFiles, nil
+}
+
+// metafileInputs returns the files in the plugin directory that esbuild read
+// to build the bundle, according to its metafile.
+func metafileInputs(pluginDir, metafile string) ([]string, error) {
+	var meta struct {
+		Inputs map[string]json.RawMessage `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(metafile), &meta); err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(meta.Inputs))
+	for input := range meta.Inputs {
+		file := filepath.Join(pluginDir, filepath.FromSlash(input))
+		rel, err := filepath.Rel(pluginDir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		// Inputs from plugins, like the Preact shim, don't exist on disk
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func pluginGlobalName(pluginName string) string {
+	return "smmPlugins['" + pluginName + "']"
+}
+
+// pluginSourceRoot groups the plugin's sources under its own name in devtools.
+func pluginSourceRoot(pluginName string) string {
+	return "crankshaft://plugins/" + url.PathEscape(pluginName) + "/"
+}
+
+// formatBuildMessages formats esbuild messages as one "file:line:column:
+// message" line per message, with paths relative to the plugin directory.
+func formatBuildMessages(pluginDir string, messages []api.Message) string {
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		lines = append(lines, formatBuildMessage(pluginDir, message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatBuildMessage(pluginDir string, message api.Message) string {
+	if message.Location == nil {
+		return message.Text
+	}
+
+	file := message.Location.File
+	if rel, err := filepath.Rel(pluginDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
 	}
 
-	return string(res.Code), nil
+	return fmt.Sprintf("%s:%d:%d: %s", file, message.Location.Line, message.Location.Column, message.Text)
 }
@@ -0,0 +1,112 @@
+package plugins
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// Minimal stand-in for Preact, vendored into test plugins
+const testPreactModule = `
+export const h = (type, props, ...children) => ({ type, props, children });
+export const Fragment = 'fragment';
+`
+
+func TestBuildPluginScriptBundlesEntry(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "bundled", map[string]string{
+		"plugin.toml":                      testPluginToml + "\n[build]\nentry = \"src/index.tsx\"\n",
+		"node_modules/preact/index.js":     testPreactModule,
+		"node_modules/preact/package.json": `{"name": "preact", "main": "index.js"}`,
+		"src/greeting.ts":                  "export const greeting: string = 'hello from bundled';",
+		"src/index.tsx": `
+import { greeting } from './greeting';
+
+export const load = () => <div>{greeting}</div>;
+`,
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin, ok := plugins.Get("bundled")
+	if !ok {
+		t.Fatal("Plugin was not loaded")
+	}
+	if plugin.Status != PluginStatusOk {
+		t.Fatalf("Expected plugin to build, got error: %v", plugin.Error)
+	}
+
+	for _, expected := range []string{"smmPlugins.bundled =", "hello from bundled", "type, props, ...children"} {
+		if !strings.Contains(plugin.Script, expected) {
+			t.Fatalf("Expected bundled script to contain %q, got:\n%s", expected, plugin.Script)
+		}
+	}
+}
+
+func TestBuildPluginScriptReportsErrorLocation(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "broken", map[string]string{
+		"plugin.toml":  testPluginToml + "\n[build]\nentry = \"src/index.ts\"\n",
+		"src/index.ts": "export const load = () => {\n  const x = ;\n};\n",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin, _ := plugins.Get("broken")
+	if plugin.Status != PluginStatusErrored {
+		t.Fatal("Expected plugin with a syntax error to be errored")
+	}
+	if !strings.Contains(plugin.Error, "src/index.ts:2:12") {
+		t.Fatalf("Expected error to include file, line and column, got: %v", plugin.Error)
+	}
+}
+
+func TestBuildPluginScriptSourceMap(t *testing.T) {
+	pluginDir := t.TempDir()
+	writeTestPlugin(t, pluginDir, "mapped", map[string]string{
+		"plugin.toml":  testPluginToml + "\n[build]\nentry = \"src/index.ts\"\n",
+		"src/index.ts": "export const load = (): void => console.log('mapped');\n",
+	})
+	config, err := NewPluginConfig(path.Join(pluginDir, "mapped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, sourceMap, _, err := buildPluginScript("mapped", path.Join(pluginDir, "mapped"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		SourceRoot string   `json:"sourceRoot"`
+		Sources    []string `json:"sources"`
+	}
+	if err := json.Unmarshal([]byte(sourceMap), &decoded); err != nil {
+		t.Fatalf("Expected a valid source map, got %v:\n%s", err, sourceMap)
+	}
+	if decoded.SourceRoot != "crankshaft://plugins/mapped/" {
+		t.Fatalf("Expected sources to be rooted at the plugin, got %q", decoded.SourceRoot)
+	}
+	if len(decoded.Sources) != 1 || decoded.Sources[0] != "src/index.ts" {
+		t.Fatalf("Expected sources relative to the plugin, got %v", decoded.Sources)
+	}
+}
@@ -0,0 +1,119 @@
+package plugins
+
+import (
+	"log"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+	"git.sr.ht/~avery/crankshaft/watcher"
+)
+
+// CrankshaftSettings returns Crankshaft's own settings with their current
+// values.
+func (p *Plugins) CrankshaftSettings() []config.Setting {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.crksftConfig.Settings()
+}
+
+// SetCrankshaftSettings changes Crankshaft's own settings and returns the keys
+// of the settings that changed. Nothing is changed if any value is invalid.
+func (p *Plugins) SetCrankshaftSettings(values map[string]interface{}) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.crksftConfig.SetSettings(values)
+}
+
+// ReloadConfig reads the Crankshaft config again after it was edited outside
+// of Crankshaft and applies it to the plugins, publishing an event for each
+// plugin that was enabled or disabled. It returns the keys of the Crankshaft
+// settings that changed.
+func (p *Plugins) ReloadConfig() ([]string, error) {
+	p.mu.Lock()
+
+	changed, err := p.crksftConfig.Reload()
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	events := p.reapplyConfig()
+
+	p.mu.Unlock()
+
+	for _, event := range events {
+		p.publish(event)
+	}
+
+	return changed, nil
+}
+
+// reapplyConfig applies the Crankshaft config to every plugin again after
+// it changed, in load order so plugins see the new state of the plugins they
+// require. It returns an event for each plugin that was enabled or disabled in
+// any UI mode, which the caller should publish after releasing the lock.
+// p.mu must be held by the caller.
+func (p *Plugins) reapplyConfig() []Event {
+	events := []Event{}
+	for _, pluginId := range p.loadOrder {
+		plugin := p.pluginMap[pluginId]
+		prevEnabledModes := plugin.EnabledModes
+		p.applyConfig(&plugin, p.pluginMap)
+		p.pluginMap[pluginId] = plugin
+
+		if reflect.DeepEqual(prevEnabledModes, plugin.EnabledModes) {
+			continue
+		}
+		if plugin.Enabled {
+			events = append(events, Event{Type: EventEnabled, PluginId: pluginId})
+		} else {
+			events = append(events, Event{Type: EventDisabled, PluginId: pluginId})
+		}
+	}
+	return events
+}
+
+// WatchConfig reloads the Crankshaft config whenever config.toml changes, and
+// calls onReload with the keys of the Crankshaft settings that changed.
+// Crankshaft's own writes are noticed too, but don't change anything. Bursts
+// of changes within the debounce duration only trigger one reload. Call the
+// returned function to stop watching.
+func (p *Plugins) WatchConfig(debounce time.Duration, onReload func(changed []string)) (stop func(), err error) {
+	w, err := watcher.New()
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory is watched since config.toml is replaced rather than
+	// written in place
+	configPath := p.crksftConfig.FilePath()
+	if err := w.Add(filepath.Dir(configPath)); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	debouncer := watcher.NewDebouncer(debounce)
+	go func() {
+		for path := range w.Events {
+			if path != configPath {
+				continue
+			}
+			debouncer.Call(configPath, func() {
+				changed, err := p.ReloadConfig()
+				if err != nil {
+					log.Printf("Error reloading Crankshaft config, keeping the current config: %v\n", err)
+					return
+				}
+				onReload(changed)
+			})
+		}
+	}()
+
+	return func() {
+		w.Close()
+		debouncer.Stop()
+	}, nil
+}
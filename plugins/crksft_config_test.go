@@ -0,0 +1,156 @@
+package plugins
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+func TestReloadConfig(t *testing.T) {
+	plugins := newProfilesTestPlugins(t, "edited")
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	// Enable the plugin and change a setting by editing config.toml
+	contents := `version = 1
+keep-plugin-backups = 5
+
+[plugins.edited]
+enabled = true
+`
+	if err := os.WriteFile(plugins.crksftConfig.FilePath(), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := plugins.ReloadConfig()
+	if err != nil {
+		t.Fatalf("ReloadConfig returned error: %v", err)
+	}
+	if len(changed) != 1 || changed[0] != "keep-plugin-backups" {
+		t.Fatalf("Expected keep-plugin-backups to change, got %v", changed)
+	}
+
+	plugin, _ := plugins.Get("edited")
+	if !plugin.Enabled {
+		t.Fatal("Expected plugin to be enabled after reloading the config")
+	}
+	if event := <-events; event.Type != EventEnabled || event.PluginId != "edited" {
+		t.Fatalf("Expected enabled event for edited, got %v", event)
+	}
+
+	// A config that can't be decoded is ignored
+	if err := os.WriteFile(plugins.crksftConfig.FilePath(), []byte("enabled = ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plugins.ReloadConfig(); err == nil {
+		t.Fatal("ReloadConfig expected error, got nil")
+	}
+	if plugin, _ := plugins.Get("edited"); !plugin.Enabled {
+		t.Fatal("Expected plugin to stay enabled after a failed reload")
+	}
+}
+
+// The config is replaced by Reload while it's read and updated, run with -race
+// (make test-race) to catch unlocked access
+func TestReloadConfigWhileInUse(t *testing.T) {
+	plugins := newProfilesTestPlugins(t, "busy")
+	// Reload leaves the config alone until config.toml exists
+	if err := plugins.crksftConfig.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	run := func(f func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := f(i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	run(func(i int) error {
+		_, err := plugins.ReloadConfig()
+		return err
+	})
+	run(func(i int) error {
+		return plugins.SetEnabled("busy", i%2 == 0, false)
+	})
+	run(func(i int) error {
+		_, err := plugins.GetSettings("busy")
+		return err
+	})
+	run(func(i int) error {
+		plugins.crksftConfig.GetRepositories()
+		plugins.StoreQuota("busy")
+		_, err := plugins.crksftConfig.GetUpdateCheckInterval()
+		return err
+	})
+	wg.Wait()
+}
+
+func TestSetCrankshaftSettings(t *testing.T) {
+	plugins := newProfilesTestPlugins(t)
+
+	changed, err := plugins.SetCrankshaftSettings(map[string]interface{}{
+		"store-quota":   float64(2048),
+		"watch-plugins": true,
+	})
+	if err != nil {
+		t.Fatalf("SetCrankshaftSettings returned error: %v", err)
+	}
+	if len(changed) != 2 {
+		t.Fatalf("Expected 2 changed settings, got %v", changed)
+	}
+	if quota := plugins.StoreQuota("any"); quota != 2048 {
+		t.Fatalf("Expected store quota 2048, got %d", quota)
+	}
+
+	// Nothing changes if any value is invalid
+	_, err = plugins.SetCrankshaftSettings(map[string]interface{}{
+		"store-quota":   float64(4096),
+		"watch-plugins": "yes",
+	})
+	if _, ok := err.(*config.SettingError); !ok {
+		t.Fatalf("Expected SettingError, got %v", err)
+	}
+	if quota := plugins.StoreQuota("any"); quota != 2048 {
+		t.Fatalf("Expected store quota to stay 2048, got %d", quota)
+	}
+}
+
+func TestWatchConfigReloadsEdits(t *testing.T) {
+	plugins := newProfilesTestPlugins(t)
+
+	reloads := make(chan []string, 8)
+	stop, err := plugins.WatchConfig(50*time.Millisecond, func(changed []string) {
+		reloads <- changed
+	})
+	if err != nil {
+		t.Skipf("Watching isn't supported: %v", err)
+	}
+	defer stop()
+
+	if err := os.WriteFile(plugins.crksftConfig.FilePath(), []byte("version = 1\nstore-quota = 100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case changed := <-reloads:
+		if len(changed) != 1 || changed[0] != "store-quota" {
+			t.Fatalf("Expected store-quota to change, got %v", changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for config to be reloaded")
+	}
+	if quota := plugins.StoreQuota("any"); quota != 100 {
+		t.Fatalf("Expected store quota 100 after reload, got %d", quota)
+	}
+}
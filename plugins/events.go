@@ -0,0 +1,110 @@
+package plugins
+
+// EventType is the type of change that happened to a plugin.
+type EventType string
+
+const (
+	EventAdded    EventType = "added"
+	EventRemoved  EventType = "removed"
+	EventEnabled  EventType = "enabled"
+	EventDisabled EventType = "disabled"
+	EventRebuilt  EventType = "rebuilt"
+	// The plugin's settings were changed with SetSettings
+	EventSettingsChanged EventType = "settings-changed"
+)
+
+// Event is published whenever a plugin in the registry changes.
+type Event struct {
+	Type     EventType `json:"type"`
+	PluginId string    `json:"pluginId"`
+}
+
+// Number of events that can be buffered in a subscriber's channel. Events
+// published while it's full are queued until the subscriber catches up.
+const subscriberBufferSize = 64
+
+type subscriber struct {
+	ch   chan Event
+	done chan struct{}
+	// Events that didn't fit in ch, guarded by subscribersMu
+	overflow []Event
+	draining bool
+}
+
+// Subscribe returns a channel that receives an event for every change to the
+// registry, in order, and a function to unsubscribe. Events are never dropped,
+// subscribers that fall behind get them once they catch up.
+func (p *Plugins) Subscribe() (events <-chan Event, unsubscribe func()) {
+	s := &subscriber{
+		ch:   make(chan Event, subscriberBufferSize),
+		done: make(chan struct{}),
+	}
+
+	p.subscribersMu.Lock()
+	p.subscribers[s] = struct{}{}
+	p.subscribersMu.Unlock()
+
+	return s.ch, func() {
+		p.subscribersMu.Lock()
+		defer p.subscribersMu.Unlock()
+
+		if _, ok := p.subscribers[s]; ok {
+			delete(p.subscribers, s)
+			close(s.done)
+			// drain closes the channel once it stops sending to it
+			if !s.draining {
+				close(s.ch)
+			}
+		}
+	}
+}
+
+func (p *Plugins) publish(event Event) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+
+	for s := range p.subscribers {
+		// Once events are queued, new ones go after them to keep the order
+		if len(s.overflow) == 0 {
+			select {
+			case s.ch <- event:
+				continue
+			default:
+			}
+		}
+
+		s.overflow = append(s.overflow, event)
+		if !s.draining {
+			s.draining = true
+			go p.drain(s)
+		}
+	}
+}
+
+// drain sends a subscriber's queued events until the queue is empty or it
+// unsubscribes.
+func (p *Plugins) drain(s *subscriber) {
+	for {
+		p.subscribersMu.Lock()
+		if len(s.overflow) == 0 {
+			s.draining = false
+			p.subscribersMu.Unlock()
+			return
+		}
+		event := s.overflow[0]
+		p.subscribersMu.Unlock()
+
+		select {
+		case s.ch <- event:
+			p.subscribersMu.Lock()
+			s.overflow = s.overflow[1:]
+			p.subscribersMu.Unlock()
+		case <-s.done:
+			p.subscribersMu.Lock()
+			s.draining = false
+			close(s.ch)
+			p.subscribersMu.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/untar"
+)
+
+// Maximum amount of time to spend downloading a plugin archive
+const installDownloadTimeout = 5 * time.Minute
+
+// Install downloads the plugin archive at archiveUrl, verifies it against the
+// expected sha256 checksum, and installs the plugin it contains. The ID of the
+// installed plugin is returned.
+//
+// The archive must have a valid detached ed25519 signature from a trusted key.
+// If it doesn't, a SignatureError is returned unless allowUnsigned is true or
+// the user already allowed unsigned archives for the plugin. Allowing unsigned
+// archives is recorded in the plugin's Crankshaft config, along with the key
+// that signed the installed archive.
+//
+// The archive is downloaded into a staging directory and is only extracted
+// there once it's verified. The plugin is only moved into the plugins directory
+// once its config has been parsed and its script has been built. If any step
+// fails, the staging directory is removed and the previously installed version
+// of the plugin (if there is one) is left in place. Once the new version is
+// installed, the previous version is kept as a backup that can be restored
+// with Rollback.
+//
+// Plugins that don't support this platform are still installed, but they're
+// marked PluginStatusUnsupported and can't be enabled.
+func (p *Plugins) Install(archiveUrl, sha256sum, signature string, allowUnsigned bool) (string, error) {
+	return p.InstallFrom("", archiveUrl, sha256sum, signature, allowUnsigned)
+}
+
+// InstallFrom installs a plugin archive like Install, from the named
+// repository. The repository's trusted key is trusted to sign the archive, and
+// the repository is recorded in the plugin's Crankshaft config so its updates
+// only come from there.
+func (p *Plugins) InstallFrom(repository, archiveUrl, sha256sum, signature string, allowUnsigned bool) (string, error) {
+	if sha256sum == "" {
+		return "", errors.New("Expected sha256 checksum is required to install a plugin")
+	}
+
+	return p.install(repository, signature, allowUnsigned, func(archivePath string) error {
+		return downloadArchive(archiveUrl, archivePath, sha256sum)
+	})
+}
+
+// InstallFromFile installs the plugin archive at archivePath like Install, for
+// devices without network access. There's no checksum to verify the archive
+// against, but it still needs a trusted signature or allowUnsigned.
+func (p *Plugins) InstallFromFile(archivePath, signature string, allowUnsigned bool) (string, error) {
+	return p.install("", signature, allowUnsigned, func(stagedPath string) error {
+		return copyArchive(archivePath, stagedPath)
+	})
+}
+
+// install stages, verifies and installs a plugin archive. fetch writes the
+// archive to archivePath in the staging directory.
+func (p *Plugins) install(repository, signature string, allowUnsigned bool, fetch func(archivePath string) error) (string, error) {
+	p.installMu.Lock()
+	defer p.installMu.Unlock()
+
+	// The staging directory is created inside the plugins directory, so the
+	// final move is a rename on the same filesystem. It's prefixed with a dot so
+	// it's ignored if plugins are reloaded while we're installing.
+	stagingDir, err := os.MkdirTemp(p.pluginsDir, ".install-")
+	if err != nil {
+		return "", fmt.Errorf("Error creating staging directory: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Printf(`Error removing staging directory "%s": %v`, stagingDir, err)
+		}
+	}()
+
+	archivePath := path.Join(stagingDir, "archive.tar.gz")
+	if err := fetch(archivePath); err != nil {
+		return "", err
+	}
+
+	// The archive is only extracted once it's trusted
+	signedBy, reason, err := verifyArchiveSignature(archivePath, signature, p.trustedKeys(repository))
+	if err != nil {
+		return "", err
+	}
+	unsignedId := ""
+	if signedBy == "" {
+		unsignedId, err = archivePluginId(archivePath)
+		if err != nil {
+			return "", err
+		}
+		if !allowUnsigned {
+			allowed, reason := p.allowsUnsigned(unsignedId, signature, reason)
+			if !allowed {
+				return "", &SignatureError{PluginId: unsignedId, Reason: reason}
+			}
+		}
+		log.Printf("Installing unverified plugin \"%s\" because the user allowed it: %s\n", unsignedId, reason)
+	}
+
+	extractDir := path.Join(stagingDir, "extracted")
+	if err := os.Mkdir(extractDir, 0755); err != nil {
+		return "", err
+	}
+	if err := untar.Untar(archivePath, extractDir); err != nil {
+		return "", fmt.Errorf("Error extracting plugin archive: %v", err)
+	}
+
+	pluginId, pluginDir, err := findExtractedPlugin(extractDir)
+	if err != nil {
+		return "", err
+	}
+	if unsignedId != "" && pluginId != unsignedId {
+		return "", fmt.Errorf(`Plugin archive contained "%s", expected "%s"`, pluginId, unsignedId)
+	}
+
+	config, err := NewPluginConfig(pluginDir)
+	if err != nil {
+		return "", err
+	}
+
+	// The plugin is still installed, it's marked unsupported once it's loaded
+	if reason := config.unsupportedPlatformReason(runtime.GOOS); reason != "" {
+		log.Printf("Warning: plugin \"%s\" doesn't support this platform and won't be loaded: %s\n", pluginId, reason)
+	}
+
+	if _, _, _, err := buildPluginScript(pluginId, pluginDir, config); err != nil {
+		return "", err
+	}
+
+	log.Printf("Installing plugin \"%s\"...\n", pluginId)
+
+	rollback, err := p.movePluginIntoPlace(pluginId, pluginDir, stagingDir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.Reload(); err != nil {
+		log.Printf("Error reloading plugins after installing \"%s\", rolling back: %v\n", pluginId, err)
+		if rollbackErr := rollback(); rollbackErr != nil {
+			log.Printf("Error rolling back install of \"%s\": %v\n", pluginId, rollbackErr)
+		}
+		if reloadErr := p.Reload(); reloadErr != nil {
+			log.Println(reloadErr)
+		}
+		return "", fmt.Errorf(`Error loading installed plugin "%s": %v`, pluginId, err)
+	}
+
+	p.backupPrevious(pluginId, stagingDir)
+
+	if err := p.recordTrust(pluginId, repository, signedBy, allowUnsigned); err != nil {
+		log.Printf("Error recording signature of plugin \"%s\": %v\n", pluginId, err)
+	}
+
+	return pluginId, nil
+}
+
+// downloadArchive downloads the archive at archiveUrl to archivePath, and
+// returns an error if its sha256 checksum doesn't match the expected one.
+func downloadArchive(archiveUrl, archivePath, sha256sum string) error {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("Error creating plugin archive file: %v", err)
+	}
+	defer out.Close()
+
+	client := http.Client{Timeout: installDownloadTimeout}
+	res, err := client.Get(archiveUrl)
+	if err != nil {
+		return fmt.Errorf(`Error downloading plugin archive "%s": %v`, archiveUrl, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf(`Download of plugin archive "%s" returned status %d`, archiveUrl, res.StatusCode)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), res.Body); err != nil {
+		return fmt.Errorf(`Error downloading plugin archive "%s": %v`, archiveUrl, err)
+	}
+
+	actualSum := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actualSum, sha256sum) {
+		return fmt.Errorf(`Checksum mismatch for plugin archive "%s", expected %s, got %s`, archiveUrl, sha256sum, actualSum)
+	}
+
+	return nil
+}
+
+// copyArchive copies the local archive at archivePath to stagedPath.
+func copyArchive(archivePath, stagedPath string) error {
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf(`Error opening plugin archive "%s": %v`, archivePath, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(stagedPath)
+	if err != nil {
+		return fmt.Errorf("Error creating plugin archive file: %v", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf(`Error copying plugin archive "%s": %v`, archivePath, err)
+	}
+
+	return out.Close()
+}
+
+// archivePluginId returns the ID of the plugin in the archive, the name of its
+// single top-level directory, without extracting it.
+func archivePluginId(archivePath string) (string, error) {
+	names, err := untar.Names(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("Error reading plugin archive: %v", err)
+	}
+
+	pluginId := ""
+	for _, name := range names {
+		topLevel, _, _ := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+		if topLevel == "" || topLevel == "." || strings.HasPrefix(topLevel, ".") {
+			continue
+		}
+		if pluginId != "" && topLevel != pluginId {
+			return "", errors.New("Plugin archive must contain a single top-level plugin directory")
+		}
+		pluginId = topLevel
+	}
+
+	if pluginId == "" {
+		return "", errors.New("Plugin archive did not contain a plugin directory")
+	}
+
+	return pluginId, nil
+}
+
+// findExtractedPlugin finds the plugin directory in an extracted archive.
+// Plugin archives contain a single top-level directory named after the
+// plugin's ID.
+func findExtractedPlugin(extractDir string) (pluginId string, pluginDir string, err error) {
+	d, err := os.ReadDir(extractDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, entry := range d {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if !entry.IsDir() || pluginId != "" {
+			return "", "", errors.New("Plugin archive must contain a single top-level plugin directory")
+		}
+
+		pluginId = entry.Name()
+	}
+
+	if pluginId == "" {
+		return "", "", errors.New("Plugin archive did not contain a plugin directory")
+	}
+
+	return pluginId, path.Join(extractDir, pluginId), nil
+}
+
+// movePluginIntoPlace moves a staged plugin directory into the plugins
+// directory, replacing any existing version of the plugin. The existing
+// version is moved into the staging directory so it can be restored with the
+// returned rollback function.
+func (p *Plugins) movePluginIntoPlace(pluginId, stagedDir, stagingDir string) (rollback func() error, err error) {
+	destDir := path.Join(p.pluginsDir, pluginId)
+	previousDir := path.Join(stagingDir, "previous")
+
+	hadPrevious := false
+	if _, err := os.Lstat(destDir); err == nil {
+		if err := os.Rename(destDir, previousDir); err != nil {
+			return nil, fmt.Errorf(`Error moving previous version of plugin "%s": %v`, pluginId, err)
+		}
+		hadPrevious = true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	restorePrevious := func() error {
+		if !hadPrevious {
+			return nil
+		}
+		return os.Rename(previousDir, destDir)
+	}
+
+	if err := os.Rename(stagedDir, destDir); err != nil {
+		if restoreErr := restorePrevious(); restoreErr != nil {
+			log.Printf("Error restoring previous version of plugin \"%s\": %v\n", pluginId, restoreErr)
+		}
+		return nil, fmt.Errorf(`Error moving plugin "%s" into plugins directory: %v`, pluginId, err)
+	}
+
+	return func() error {
+		if err := os.RemoveAll(destDir); err != nil {
+			return err
+		}
+		return restorePrevious()
+	}, nil
+}
@@ -0,0 +1,193 @@
+package plugins
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+const testPluginToml = `
+name = "Test Plugin"
+version = "1.0.0"
+
+[entrypoints.desktop]
+library = true
+
+[entrypoints.deck]
+library = true
+`
+
+// makeTestArchive creates a gzipped tar archive containing the given files.
+func makeTestArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for name, contents := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func serveTestArchive(t *testing.T, archive []byte) (url string, sha256sum string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	t.Cleanup(server.Close)
+
+	sum := sha256.Sum256(archive)
+	return server.URL + "/test-plugin.tar.gz", hex.EncodeToString(sum[:])
+}
+
+func newTestPlugins(t *testing.T) *Plugins {
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, t.TempDir(), t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return plugins
+}
+
+func TestInstall(t *testing.T) {
+	plugins := newTestPlugins(t)
+
+	url, sum := serveTestArchive(t, makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	}))
+
+	id, err := plugins.Install(url, sum, "", true)
+	if err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+
+	if id != "test-plugin" {
+		t.Fatalf(`Install expected id "%v", got "%v"`, "test-plugin", id)
+	}
+
+	if _, ok := plugins.Get(id); !ok {
+		t.Fatalf("Installed plugin %v was not loaded", id)
+	}
+}
+
+func TestInstallFromFile(t *testing.T) {
+	plugins := newTestPlugins(t)
+
+	archivePath := path.Join(t.TempDir(), "test-plugin.tar.gz")
+	if err := os.WriteFile(archivePath, makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var signatureErr *SignatureError
+	if _, err := plugins.InstallFromFile(archivePath, "", false); !errors.As(err, &signatureErr) {
+		t.Fatalf("InstallFromFile expected SignatureError, got %v", err)
+	}
+
+	id, err := plugins.InstallFromFile(archivePath, "", true)
+	if err != nil {
+		t.Fatalf("InstallFromFile returned error: %v", err)
+	}
+	if _, ok := plugins.Get(id); !ok {
+		t.Fatalf("Installed plugin %v was not loaded", id)
+	}
+
+	// The archive is copied, so it's left where it was
+	if _, err := os.Stat(archivePath); err != nil {
+		t.Fatalf("Expected archive to be left in place: %v", err)
+	}
+}
+
+func TestInstallRollback(t *testing.T) {
+	plugins := newTestPlugins(t)
+
+	// Install a working version first
+	url, sum := serveTestArchive(t, makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	}))
+	if _, err := plugins.Install(url, sum, "", true); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+
+	tests := map[string]func() (string, string){
+		"checksum mismatch": func() (string, string) {
+			url, _ := serveTestArchive(t, makeTestArchive(t, map[string]string{
+				"test-plugin/plugin.toml":   testPluginToml,
+				"test-plugin/dist/index.js": "export const load = () => { broken };",
+			}))
+			return url, "0000"
+		},
+		"invalid config": func() (string, string) {
+			return serveTestArchive(t, makeTestArchive(t, map[string]string{
+				"test-plugin/plugin.toml":   `name = "Test Plugin"`,
+				"test-plugin/dist/index.js": "export const load = () => { broken };",
+			}))
+		},
+		"missing script": func() (string, string) {
+			return serveTestArchive(t, makeTestArchive(t, map[string]string{
+				"test-plugin/plugin.toml": testPluginToml,
+			}))
+		},
+	}
+
+	for name, getArchive := range tests {
+		t.Run(name, func(t *testing.T) {
+			url, sum := getArchive()
+			if _, err := plugins.Install(url, sum, "", true); err == nil {
+				t.Fatal("Install expected error, got nil")
+			}
+
+			data, err := os.ReadFile(path.Join(plugins.pluginsDir, "test-plugin", "dist", "index.js"))
+			if err != nil {
+				t.Fatalf("Error reading previously installed plugin: %v", err)
+			}
+			if string(data) != "export const load = () => {};" {
+				t.Fatalf("Previously installed plugin was modified, got script: %v", string(data))
+			}
+
+			entries, err := os.ReadDir(plugins.pluginsDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("Expected only the installed plugin in plugins directory, got %v entries", len(entries))
+			}
+		})
+	}
+}
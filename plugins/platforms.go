@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Names of the platforms plugins can declare support for, by GOOS
+var platformNames = map[string]string{
+	"linux":   "Linux",
+	"windows": "Windows",
+	"darwin":  "macOS",
+}
+
+// supportedPlatforms returns the GOOS values of the platforms the plugin
+// declares support for in store.platforms.
+func (c pluginConfig) supportedPlatforms() []string {
+	supported := []string{}
+	if c.Store.Platforms.Linux.Supported {
+		supported = append(supported, "linux")
+	}
+	if c.Store.Platforms.Windows.Supported {
+		supported = append(supported, "windows")
+	}
+	if c.Store.Platforms.Darwin.Supported {
+		supported = append(supported, "darwin")
+	}
+	return supported
+}
+
+// unsupportedPlatformReason returns why the plugin can't run on the platform,
+// or an empty string if it can. Plugins that don't declare any platforms,
+// like plugins that aren't published to the registry, are treated as
+// supporting every platform.
+func (c pluginConfig) unsupportedPlatformReason(goos string) string {
+	supported := c.supportedPlatforms()
+	if len(supported) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(supported))
+	for _, platform := range supported {
+		if platform == goos {
+			return ""
+		}
+		names = append(names, platformNames[platform])
+	}
+
+	name, ok := platformNames[goos]
+	if !ok {
+		name = goos
+	}
+	return fmt.Sprintf("Only supports %s, but this is %s", strings.Join(names, ", "), name)
+}
+
+// checkPlatforms sets the status of loaded plugins that don't support the
+// platform Crankshaft is running on to PluginStatusUnsupported, with the
+// reason as the error.
+func checkPlatforms(pluginMap PluginMap) {
+	for id, plugin := range pluginMap {
+		if plugin.Status != PluginStatusOk {
+			continue
+		}
+
+		if reason := plugin.Config.unsupportedPlatformReason(runtime.GOOS); reason != "" {
+			plugin.Status = PluginStatusUnsupported
+			plugin.Error = reason
+			pluginMap[id] = plugin
+		}
+	}
+}
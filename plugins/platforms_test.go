@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+func TestUnsupportedPlatformReason(t *testing.T) {
+	var c pluginConfig
+	if reason := c.unsupportedPlatformReason("linux"); reason != "" {
+		t.Fatalf("Expected plugins without platforms to support every platform, got %q", reason)
+	}
+
+	c.Store.Platforms.Linux.Supported = true
+	c.Store.Platforms.Windows.Supported = true
+	if reason := c.unsupportedPlatformReason("windows"); reason != "" {
+		t.Fatalf("Expected Windows to be supported, got %q", reason)
+	}
+	if reason := c.unsupportedPlatformReason("darwin"); reason != "Only supports Linux, Windows, but this is macOS" {
+		t.Fatalf("Expected macOS to be unsupported, got %q", reason)
+	}
+}
+
+func TestUnsupportedPluginsCantBeEnabled(t *testing.T) {
+	// Declare support for a platform other than the one the test runs on
+	otherPlatform := "windows"
+	if runtime.GOOS == "windows" {
+		otherPlatform = "linux"
+	}
+
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "other-platform", map[string]string{
+		"plugin.toml":   testPluginToml + fmt.Sprintf("\n[store.platforms.%s]\nsupported = true\n", otherPlatform),
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, pluginsDir, "every-platform", map[string]string{
+		"plugin.toml":   testPluginToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin, _ := plugins.Get("other-platform")
+	if plugin.Status != PluginStatusUnsupported || !strings.Contains(plugin.Error, "Only supports") {
+		t.Fatalf("Expected plugin to be unsupported, got %q: %s", plugin.Status, plugin.Error)
+	}
+	if err := plugins.SetEnabled("other-platform", true, true); err == nil {
+		t.Fatal("SetEnabled expected error for unsupported plugin, got nil")
+	}
+
+	if err := plugins.SetEnabled("every-platform", true, true); err != nil {
+		t.Fatalf("SetEnabled returned error: %v", err)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/semver"
 	"github.com/BurntSushi/toml"
 )
 
@@ -38,6 +42,27 @@ type store struct {
 	Platforms   platforms `json:"platforms"`
 }
 
+type buildConfig struct {
+	// Source entry point to bundle the plugin from, relative to the plugin
+	// directory. If it's empty, the prebuilt dist/index.js is used.
+	Entry string `json:"entry"`
+}
+
+type backendConfig struct {
+	// Executable to run as the plugin's backend. If it contains a path
+	// separator it's relative to the plugin directory, otherwise it's looked up
+	// in PATH.
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+type requirements struct {
+	// Semver constraint on the Crankshaft version, e.g. ">=0.2.0"
+	Crankshaft string `json:"crankshaft"`
+	// Semver constraints on other plugins by plugin ID
+	Plugins map[string]string `json:"plugins"`
+}
+
 type pluginConfig struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
@@ -47,13 +72,47 @@ type pluginConfig struct {
 	Author      authorInfo                `json:"author"`
 	Entrypoints map[cdp.UIMode]entrypoint `json:"entrypoints"`
 	Store       store                     `json:"store"`
+	Build       buildConfig               `json:"build"`
+	Requires    requirements              `json:"requires"`
+	// Optional native process that runs while the plugin is enabled
+	Backend backendConfig `json:"backend"`
+	// Settings the user can change, by key
+	Settings map[string]setting `json:"settings"`
+	// Permissions the plugin needs, the user has to approve these before the
+	// plugin can be enabled
+	Permissions auth.Scope `json:"permissions"`
+}
+
+// ConfigErrors lists every problem found when validating a plugin config.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
 }
 
 func NewPluginConfig(pluginDir string) (*pluginConfig, error) {
+	config, err := decodePluginConfig(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.validateConfig(); err != nil {
+		return nil, fmt.Errorf(`Error found in plugin config at "%s": %w`, path.Join(pluginDir, "plugin.toml"), err)
+	}
+
+	return config, nil
+}
+
+// decodePluginConfig reads the plugin's plugin.toml without validating it.
+func decodePluginConfig(pluginDir string) (*pluginConfig, error) {
 	configFilePath := path.Join(pluginDir, "plugin.toml")
 	data, err := os.ReadFile(configFilePath)
 	if err != nil {
-		return nil, fmt.Errorf(`Error opening plugin config at "%s": %v:`, configFilePath, err)
+		return nil, fmt.Errorf(`Error opening plugin config at "%s": %v`, configFilePath, err)
 	}
 
 	var config pluginConfig
@@ -61,21 +120,48 @@ func NewPluginConfig(pluginDir string) (*pluginConfig, error) {
 		return nil, fmt.Errorf(`Error decoding plugin config at "%s": %v`, configFilePath, err)
 	}
 
-	if err := config.validateConfig(); err != nil {
-		return nil, fmt.Errorf(`Error found in plugin configconfig at "%s": %v`, configFilePath, err)
-	}
+	// Backends run native code, so the user has to approve them like any other
+	// permission
+	config.Permissions.Backend = config.Backend.Command != ""
 
 	return &config, nil
 }
 
+// validateConfig returns ConfigErrors listing every problem with the config,
+// or nil if it's valid.
 func (p *pluginConfig) validateConfig() error {
+	var errs ConfigErrors
+
 	if _, contains := p.Entrypoints["desktop"]; !contains {
-		return errors.New("Config was missing entrypoints.desktop")
+		errs = append(errs, errors.New("Config was missing entrypoints.desktop"))
 	}
 
 	if _, contains := p.Entrypoints["deck"]; !contains {
-		return errors.New("Config was missing entrypoints.deck")
+		errs = append(errs, errors.New("Config was missing entrypoints.deck"))
+	}
+
+	if command := p.Backend.Command; filepath.IsAbs(command) || strings.HasPrefix(filepath.Clean(command), "..") {
+		errs = append(errs, errors.New("backend.command must be inside the plugin directory"))
 	}
 
+	if _, err := semver.ParseConstraint(p.Requires.Crankshaft); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid requires.crankshaft: %v", err))
+	}
+
+	for _, key := range sortedKeys(p.Settings) {
+		if err := p.Settings[key].validateSchema(); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid settings.%s: %v", key, err))
+		}
+	}
+
+	for _, pluginId := range sortedKeys(p.Requires.Plugins) {
+		if _, err := semver.ParseConstraint(p.Requires.Plugins[pluginId]); err != nil {
+			errs = append(errs, fmt.Errorf(`Invalid requires.plugins.%s: %v`, pluginId, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
@@ -8,33 +8,105 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/config"
+	datastore "git.sr.ht/~avery/crankshaft/store"
+)
+
+// PluginStatus indicates whether a plugin was loaded successfully.
+type PluginStatus string
+
+const (
+	PluginStatusOk      PluginStatus = "ok"
+	PluginStatusErrored PluginStatus = "errored"
+	// The plugin loaded, but it requires a Crankshaft version or other plugins
+	// that aren't available
+	PluginStatusUnmetRequirements PluginStatus = "unmet-requirements"
+	// The plugin loaded, but its store.platforms doesn't include the platform
+	// Crankshaft is running on
+	PluginStatusUnsupported PluginStatus = "unsupported"
 )
 
 type Plugin struct {
-	Id      string       `json:"id"`
-	Dir     string       `json:"dir"`
-	Config  pluginConfig `json:"config"`
-	Script  string       `json:"script"`
-	Enabled bool         `json:"enabled"`
+	Id     string       `json:"id"`
+	Dir    string       `json:"dir"`
+	Config pluginConfig `json:"config"`
+	Script string       `json:"script"`
+	// Enabled is true if the plugin is enabled in any UI mode
+	Enabled bool `json:"enabled"`
+	// EnabledModes is whether the plugin is enabled in each UI mode
+	EnabledModes map[cdp.UIMode]bool `json:"enabledModes"`
+	Status       PluginStatus        `json:"status"`
+	// PermissionsApproved is true if the user approved all of the permissions
+	// the plugin declares.
+	PermissionsApproved bool `json:"permissionsApproved"`
+	// Error is set to the reason the plugin couldn't be loaded when its status
+	// isn't PluginStatusOk.
+	Error string `json:"error,omitempty"`
+	// Repository is the name of the repository the plugin was installed from,
+	// if any.
+	Repository string `json:"repository,omitempty"`
+	// SourceMap is the source map for the script, it's served to devtools
+	// separately.
+	SourceMap string `json:"-"`
+	// SourceFiles are the files the script was bundled from, if it was built
+	// from source. They're watched for changes along with the plugin.
+	SourceFiles []string `json:"-"`
+	// Backups are the previous versions of the plugin that can be restored.
+	// They're only filled in by PluginsService.List.
+	Backups []PluginBackup `json:"backups,omitempty"`
 }
 
 type PluginMap = map[string]Plugin
 
+// Plugins is the registry of installed plugins. It's safe for concurrent use,
+// plugins should only be accessed through its methods.
 type Plugins struct {
-	PluginMap    PluginMap
+	mu        sync.RWMutex
+	pluginMap PluginMap
+	// Plugin IDs in the order they should be loaded
+	loadOrder    []string
 	pluginsDir   string
+	backupsDir   string
 	crksftConfig *config.CrksftConfig
+	// Where plugins' stored data is kept, so it can be deleted along with the
+	// plugin. This can be nil.
+	store *datastore.Store
+
+	// Installs move directories around in the plugins directory, so only one
+	// can run at a time
+	installMu sync.Mutex
+
+	subscribersMu sync.Mutex
+	subscribers   map[*subscriber]struct{}
 }
 
-func NewPlugins(crksftConfig *config.CrksftConfig, pluginsDir string) (*Plugins, error) {
+func NewPlugins(crksftConfig *config.CrksftConfig, pluginsDir, backupsDir string, store *datastore.Store) (*Plugins, error) {
 	plugins := Plugins{
-		PluginMap:    PluginMap{},
 		pluginsDir:   pluginsDir,
+		backupsDir:   backupsDir,
 		crksftConfig: crksftConfig,
+		store:        store,
+		subscribers:  make(map[*subscriber]struct{}),
+	}
+
+	pluginMap, err := scanPlugins(pluginsDir)
+	if err != nil {
+		return nil, err
 	}
 
+	plugins.replacePluginMap(pluginMap)
+
+	return &plugins, nil
+}
+
+// scanPlugins loads every plugin in the plugins directory.
+func scanPlugins(pluginsDir string) (PluginMap, error) {
+	pluginMap := PluginMap{}
+
 	d, err := os.ReadDir(pluginsDir)
 	if err != nil {
 		return nil, fmt.Errorf(`Error reading plugins directory "%s": %v`, pluginsDir, err)
@@ -43,7 +115,8 @@ func NewPlugins(crksftConfig *config.CrksftConfig, pluginsDir string) (*Plugins,
 	for _, entry := range d {
 		fi, err := entry.Info()
 		if err != nil {
-			return nil, err
+			log.Printf("Error reading plugin directory entry \"%s\": %v\n", entry.Name(), err)
+			continue
 		}
 
 		isSymLink := fi.Mode()&os.ModeSymlink == os.ModeSymlink
@@ -56,48 +129,140 @@ func NewPlugins(crksftConfig *config.CrksftConfig, pluginsDir string) (*Plugins,
 		pluginDir := path.Join(pluginsDir, pluginName)
 
 		if isSymLink {
-			pluginDir, err = filepath.EvalSymlinks(pluginDir)
+			resolvedDir, err := filepath.EvalSymlinks(pluginDir)
 			if err != nil {
-				return nil, err
+				log.Printf("Error resolving plugin symlink \"%s\": %v\n", pluginDir, err)
+				pluginMap[pluginName] = newErroredPlugin(pluginName, pluginDir, err)
+				continue
 			}
+			pluginDir = resolvedDir
 		}
 
-		config, err := NewPluginConfig(pluginDir)
-		if err != nil {
-			return nil, err
-		}
+		pluginMap[pluginName] = loadPlugin(pluginName, pluginDir)
+	}
 
-		log.Printf("Building plugin script \"%s\"...\n", pluginName)
+	return pluginMap, nil
+}
 
-		script, err := buildPluginScript(pluginName, pluginDir)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
+// loadPlugin reads the plugin's config and builds its script. If either of
+// those fail, an errored plugin is returned instead so that one broken plugin
+// doesn't stop the rest from loading.
+//
+// The returned plugin is always disabled, the caller is responsible for
+// setting its enabled state from the Crankshaft config.
+func loadPlugin(pluginId, pluginDir string) Plugin {
+	config, err := NewPluginConfig(pluginDir)
+	if err != nil {
+		log.Println(err)
+		return newErroredPlugin(pluginId, pluginDir, err)
+	}
 
-		enabled := false
-		if crksftPluginConfig, found := crksftConfig.Plugins[pluginName]; found {
-			enabled = crksftPluginConfig.Enabled
-		}
+	log.Printf("Building plugin script \"%s\"...\n", pluginId)
 
-		plugins.addPlugin(Plugin{
-			Id:      entry.Name(),
-			Dir:     pluginDir,
-			Script:  script,
-			Config:  *config,
-			Enabled: enabled,
-		})
+	script, sourceMap, sourceFiles, err := buildPluginScript(pluginId, pluginDir, config)
+	if err != nil {
+		log.Println(err)
+		plugin := newErroredPlugin(pluginId, pluginDir, err)
+		plugin.Config = *config
+		return plugin
 	}
 
-	return &plugins, nil
+	return Plugin{
+		Id:          pluginId,
+		Dir:         pluginDir,
+		Script:      script,
+		SourceMap:   sourceMap,
+		SourceFiles: sourceFiles,
+		Config:      *config,
+		Status:      PluginStatusOk,
+	}
 }
 
-func (p *Plugins) addPlugin(plugin Plugin) {
-	p.PluginMap[plugin.Id] = plugin
+// newErroredPlugin creates a plugin that failed to load. Errored plugins are
+// kept in the plugin map so the error can be shown to the user, but they're
+// never enabled or injected.
+func newErroredPlugin(pluginId, pluginDir string, err error) Plugin {
+	return Plugin{
+		Id:  pluginId,
+		Dir: pluginDir,
+		Config: pluginConfig{
+			// Fall back to the plugin ID so there's something to display
+			Name: pluginId,
+		},
+		Status: PluginStatusErrored,
+		Error:  err.Error(),
+	}
 }
 
-func (p *Plugins) RemovePlugin(pluginId string) error {
-	plugin, ok := p.PluginMap[pluginId]
+// applyConfig sets the plugin's enabled state in each UI mode, whether its
+// permissions are approved and the repository it was installed from, from the
+// Crankshaft config. Plugins are only enabled if they loaded successfully and
+// the user approved all of their permissions, so an update that asks for new
+// permissions disables the plugin until they're approved. They're also only
+// enabled in the UI modes where the plugins they require are enabled in
+// pluginMap, so the config must be applied to those first.
+// p.mu must be held by the caller.
+func (p *Plugins) applyConfig(plugin *Plugin, pluginMap PluginMap) {
+	crksftPluginConfig := p.crksftConfig.GetPlugin(plugin.Id)
+
+	plugin.PermissionsApproved = crksftPluginConfig.ApprovedPermissions.Covers(plugin.Config.Permissions)
+	plugin.Repository = crksftPluginConfig.Repository
+	plugin.Enabled = false
+	plugin.EnabledModes = make(map[cdp.UIMode]bool, len(uiModes))
+	for _, uiMode := range uiModes {
+		enabled := plugin.Status == PluginStatusOk &&
+			plugin.PermissionsApproved &&
+			crksftPluginConfig.IsEnabledIn(string(uiMode)) &&
+			len(disabledRequirements(*plugin, pluginMap, uiMode)) == 0
+		plugin.EnabledModes[uiMode] = enabled
+		plugin.Enabled = plugin.Enabled || enabled
+	}
+}
+
+// Get returns the plugin with the given ID.
+func (p *Plugins) Get(pluginId string) (Plugin, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	plugin, ok := p.pluginMap[pluginId]
+	return plugin, ok
+}
+
+// LoadOrder returns the IDs of all plugins, ordered so that every plugin comes
+// after the plugins it requires.
+func (p *Plugins) LoadOrder() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]string{}, p.loadOrder...)
+}
+
+// List returns a copy of all loaded plugins.
+func (p *Plugins) List() PluginMap {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pluginMap := make(PluginMap, len(p.pluginMap))
+	for id, plugin := range p.pluginMap {
+		pluginMap[id] = plugin
+	}
+	return pluginMap
+}
+
+// StoreQuota returns the size limit in bytes of the plugin's store namespace,
+// or of the shared namespace if pluginId is empty. Zero means there's no
+// limit.
+func (p *Plugins) StoreQuota(pluginId string) int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.crksftConfig.GetStoreQuota(pluginId)
+}
+
+// RemovePlugin deletes the plugin's directory. If purgeData is true, the data
+// the plugin saved in its store namespace is deleted too.
+func (p *Plugins) RemovePlugin(pluginId string, purgeData bool) error {
+	plugin, ok := p.Get(pluginId)
 	if !ok {
 		return errors.New("Plugin not found: " + pluginId)
 	}
@@ -106,45 +271,224 @@ func (p *Plugins) RemovePlugin(pluginId string) error {
 		return fmt.Errorf("Error deleting plugin directory for '%s': %v", pluginId, err)
 	}
 
+	if purgeData && p.store != nil {
+		if err := p.store.DeletePluginData(pluginId); err != nil {
+			return fmt.Errorf("Error deleting stored data for '%s': %v", pluginId, err)
+		}
+	}
+
 	return p.Reload()
 }
 
 func (p *Plugins) RebuildPlugin(pluginId string) error {
-	plugin, ok := p.PluginMap[pluginId]
+	plugin, ok := p.Get(pluginId)
 	if !ok {
 		return errors.New("Plugin not found: " + pluginId)
 	}
 
-	script, err := buildPluginScript(plugin.Id, plugin.Dir)
-	if err != nil {
-		return err
+	// Reload the plugin's config along with its script, so fixing either one
+	// brings an errored plugin back
+	rebuilt := loadPlugin(plugin.Id, plugin.Dir)
+
+	p.mu.Lock()
+	if _, ok := p.pluginMap[pluginId]; !ok {
+		// Plugin was removed while we were building it
+		p.mu.Unlock()
+		return errors.New("Plugin not found: " + pluginId)
+	}
+	pluginMap := make(PluginMap, len(p.pluginMap))
+	for id, plugin := range p.pluginMap {
+		pluginMap[id] = plugin
 	}
+	pluginMap[pluginId] = rebuilt
+	// The new version can change whether other plugins' requirements are met
+	events := p.replacePluginMap(pluginMap)
+	rebuilt = p.pluginMap[pluginId]
+	p.mu.Unlock()
 
-	plugin.Script = script
+	// Always publish an event for the rebuilt plugin, even if its script didn't
+	// change
+	rebuiltEvent := Event{Type: EventRebuilt, PluginId: pluginId}
+	p.publish(rebuiltEvent)
+	for _, event := range events {
+		if event != rebuiltEvent {
+			p.publish(event)
+		}
+	}
 
-	p.PluginMap[pluginId] = plugin
+	if rebuilt.Status != PluginStatusOk {
+		return errors.New(rebuilt.Error)
+	}
 
 	return nil
 }
 
-func (p *Plugins) SetEnabled(pluginId string, enabled bool) error {
-	plugin, ok := p.PluginMap[pluginId]
+// PermissionsRequiredError is returned when enabling a plugin that declares
+// permissions the user hasn't approved yet.
+type PermissionsRequiredError struct {
+	PluginId    string
+	Permissions auth.Scope
+}
+
+func (e *PermissionsRequiredError) Error() string {
+	return fmt.Sprintf(`Plugin "%s" requires permissions that haven't been approved`, e.PluginId)
+}
+
+// SetEnabled enables or disables the plugin in every UI mode. If the plugin
+// declares permissions that haven't been approved, enabling it fails with a
+// PermissionsRequiredError unless approvePermissions is true, in which case
+// the plugin's current permissions are recorded as approved.
+func (p *Plugins) SetEnabled(pluginId string, enabled bool, approvePermissions bool) error {
+	return p.SetEnabledIn(pluginId, "", enabled, approvePermissions)
+}
+
+// SetEnabledIn enables or disables the plugin in one UI mode, or in every UI
+// mode if uiMode is empty. It's otherwise the same as SetEnabled. If a profile
+// is active, the change is saved to it.
+func (p *Plugins) SetEnabledIn(pluginId string, uiMode cdp.UIMode, enabled bool, approvePermissions bool) error {
+	if uiMode != "" && !isUIMode(uiMode) {
+		return fmt.Errorf(`Unknown UI mode "%s"`, uiMode)
+	}
+
+	// The lock isn't held while the config is written, applying the written
+	// config below checks the plugin again
+	p.mu.RLock()
+
+	plugin, ok := p.pluginMap[pluginId]
 	if !ok {
+		p.mu.RUnlock()
 		return errors.New("Plugin not found: " + pluginId)
 	}
-	plugin.Enabled = enabled
-	p.PluginMap[pluginId] = plugin
+	if enabled && plugin.Status == PluginStatusErrored {
+		p.mu.RUnlock()
+		return fmt.Errorf(`Plugin "%s" failed to load and can't be enabled: %s`, pluginId, plugin.Error)
+	}
+	if enabled && plugin.Status == PluginStatusUnmetRequirements {
+		p.mu.RUnlock()
+		return fmt.Errorf(`Plugin "%s" can't be enabled because its requirements aren't met: %s`, pluginId, plugin.Error)
+	}
+	if enabled && plugin.Status == PluginStatusUnsupported {
+		p.mu.RUnlock()
+		return fmt.Errorf(`Plugin "%s" can't be enabled because it doesn't support this platform: %s`, pluginId, plugin.Error)
+	}
+	if enabled {
+		if reasons := disabledRequirementsIn(plugin, p.pluginMap, uiMode); len(reasons) > 0 {
+			p.mu.RUnlock()
+			return fmt.Errorf(`Plugin "%s" can't be enabled because %s`, pluginId, strings.Join(reasons, "; "))
+		}
+	}
+	if enabled && !plugin.PermissionsApproved && !approvePermissions {
+		p.mu.RUnlock()
+		return &PermissionsRequiredError{
+			PluginId:    pluginId,
+			Permissions: plugin.Config.Permissions,
+		}
+	}
+	p.mu.RUnlock()
 
-	p.crksftConfig.UpdatePlugin(pluginId, config.CrksftConfigPlugin{
-		Enabled: plugin.Enabled,
+	err := p.crksftConfig.Update(func(crksftConfig *config.CrksftConfig) {
+		crksftPluginConfig := crksftConfig.Plugins[pluginId]
+		if uiMode == "" {
+			crksftPluginConfig.Enabled = enabled
+			crksftPluginConfig.EnabledModes = nil
+		} else {
+			if crksftPluginConfig.EnabledModes == nil {
+				crksftPluginConfig.EnabledModes = make(map[string]bool)
+			}
+			crksftPluginConfig.EnabledModes[string(uiMode)] = enabled
+		}
+		if enabled && approvePermissions {
+			crksftPluginConfig.ApprovedPermissions = plugin.Config.Permissions
+		}
+		crksftConfig.Plugins[pluginId] = crksftPluginConfig
+
+		saveToActiveProfile(crksftConfig, pluginId)
 	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+
+	plugin, ok = p.pluginMap[pluginId]
+	if !ok {
+		p.mu.Unlock()
+		return errors.New("Plugin not found: " + pluginId)
+	}
+	p.applyConfig(&plugin, p.pluginMap)
+	p.pluginMap[pluginId] = plugin
+	// Plugins that require this one are only enabled while it is
+	events := p.reapplyConfig()
+
+	p.mu.Unlock()
+
+	if plugin.Enabled {
+		p.publish(Event{Type: EventEnabled, PluginId: pluginId})
+	} else {
+		p.publish(Event{Type: EventDisabled, PluginId: pluginId})
+	}
+	for _, event := range events {
+		p.publish(event)
+	}
 
 	return nil
 }
 
+// Reload rescans the plugins directory and replaces the loaded plugins,
+// publishing an event for each plugin that was added, removed or rebuilt.
 func (p *Plugins) Reload() error {
 	log.Println("Reloading plugins...")
-	newPlugins, err := NewPlugins(p.crksftConfig, p.pluginsDir)
-	*p = *newPlugins
-	return err
+
+	// Scan without holding the lock, building scripts can take a while
+	pluginMap, err := scanPlugins(p.pluginsDir)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	events := p.replacePluginMap(pluginMap)
+	p.mu.Unlock()
+
+	for _, event := range events {
+		p.publish(event)
+	}
+
+	return nil
+}
+
+// replacePluginMap swaps in a new set of plugins, after checking their
+// platforms, resolving their requirements and load order, and applying the
+// Crankshaft config to them. It returns an event for each plugin that was
+// added, removed or rebuilt, which the caller should publish after releasing
+// the lock.
+// p.mu must be held by the caller.
+func (p *Plugins) replacePluginMap(pluginMap PluginMap) []Event {
+	// Unsupported plugins can't be loaded, so they also don't meet other
+	// plugins' requirements
+	checkPlatforms(pluginMap)
+	loadOrder := resolveRequirements(pluginMap)
+
+	events := []Event{}
+	for _, id := range loadOrder {
+		plugin := pluginMap[id]
+		p.applyConfig(&plugin, pluginMap)
+		pluginMap[id] = plugin
+
+		prev, existed := p.pluginMap[id]
+		if !existed {
+			events = append(events, Event{Type: EventAdded, PluginId: id})
+		} else if prev.Script != plugin.Script || prev.Status != plugin.Status {
+			events = append(events, Event{Type: EventRebuilt, PluginId: id})
+		}
+	}
+	for id := range p.pluginMap {
+		if _, exists := pluginMap[id]; !exists {
+			events = append(events, Event{Type: EventRemoved, PluginId: id})
+		}
+	}
+
+	p.pluginMap = pluginMap
+	p.loadOrder = loadOrder
+
+	return events
 }
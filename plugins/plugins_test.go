@@ -0,0 +1,327 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+	datastore "git.sr.ht/~avery/crankshaft/store"
+)
+
+// writeTestPlugin writes the given files into a plugin directory.
+func writeTestPlugin(t *testing.T, pluginsDir, pluginId string, files map[string]string) {
+	for name, contents := range files {
+		filePath := filepath.Join(pluginsDir, pluginId, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewPluginsIsolatesErrors(t *testing.T) {
+	pluginsDir := t.TempDir()
+
+	writeTestPlugin(t, pluginsDir, "healthy", map[string]string{
+		"plugin.toml":   testPluginToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, pluginsDir, "bad-config", map[string]string{
+		"plugin.toml":   `name = "Bad Config"`,
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, pluginsDir, "missing-script", map[string]string{
+		"plugin.toml": testPluginToml,
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewPlugins returned error: %v", err)
+	}
+
+	expected := map[string]PluginStatus{
+		"healthy":        PluginStatusOk,
+		"bad-config":     PluginStatusErrored,
+		"missing-script": PluginStatusErrored,
+	}
+
+	for id, status := range expected {
+		plugin, ok := plugins.Get(id)
+		if !ok {
+			t.Fatalf("Plugin %v was not loaded", id)
+		}
+
+		if plugin.Status != status {
+			t.Fatalf(`Plugin %v status expected "%v", got "%v"`, id, status, plugin.Status)
+		}
+
+		if status == PluginStatusErrored && plugin.Error == "" {
+			t.Fatalf("Errored plugin %v has no error message", id)
+		}
+	}
+
+	if err := plugins.SetEnabled("bad-config", true, false); err == nil {
+		t.Fatal("SetEnabled expected error enabling errored plugin, got nil")
+	}
+}
+
+// TestPluginsConcurrentAccess exercises the registry from many goroutines at
+// once, it's mostly useful when run with -race.
+func TestPluginsConcurrentAccess(t *testing.T) {
+	pluginsDir := t.TempDir()
+	for _, id := range []string{"one", "two", "three"} {
+		writeTestPlugin(t, pluginsDir, id, map[string]string{
+			"plugin.toml":   testPluginToml,
+			"dist/index.js": "export const load = () => {};",
+		})
+	}
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := plugins.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range events {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(5)
+		go func() {
+			defer wg.Done()
+			plugins.SetEnabled("one", true, false)
+		}()
+		go func() {
+			defer wg.Done()
+			plugins.RebuildPlugin("two")
+		}()
+		go func() {
+			defer wg.Done()
+			if err := plugins.Reload(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range plugins.List() {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			plugins.Get("three")
+		}()
+	}
+	wg.Wait()
+
+	unsubscribe()
+	<-done
+
+	plugin, _ := plugins.Get("one")
+	if !plugin.Enabled {
+		t.Fatal("Expected plugin one to be enabled")
+	}
+}
+
+func TestPluginsEvents(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "existing", map[string]string{
+		"plugin.toml":   testPluginToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	expectEvent := func(expected Event) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event != expected {
+				t.Fatalf(`Expected event "%v", got "%v"`, expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf(`Timed out waiting for event "%v"`, expected)
+		}
+	}
+
+	plugins.SetEnabled("existing", true, false)
+	expectEvent(Event{Type: EventEnabled, PluginId: "existing"})
+
+	writeTestPlugin(t, pluginsDir, "new", map[string]string{
+		"plugin.toml":   testPluginToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+	plugins.Reload()
+	expectEvent(Event{Type: EventAdded, PluginId: "new"})
+
+	plugins.RemovePlugin("existing", false)
+	expectEvent(Event{Type: EventRemoved, PluginId: "existing"})
+}
+
+func TestPluginsEventsSlowSubscriber(t *testing.T) {
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, t.TempDir(), t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := plugins.Subscribe()
+
+	// Publish more events than fit in the subscriber's buffer before reading
+	count := subscriberBufferSize * 3
+	for i := 0; i < count; i++ {
+		plugins.publish(Event{Type: EventEnabled, PluginId: fmt.Sprint(i)})
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case event := <-events:
+			if event.PluginId != fmt.Sprint(i) {
+				t.Fatalf(`Expected event for "%d", got "%v"`, i, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf(`Timed out waiting for event %d`, i)
+		}
+	}
+
+	// Unsubscribing while events are queued closes the channel
+	for i := 0; i < count; i++ {
+		plugins.publish(Event{Type: EventEnabled, PluginId: fmt.Sprint(i)})
+	}
+	unsubscribe()
+	for range events {
+	}
+}
+
+func TestSetEnabledRequiresPermissionApproval(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "needs-exec", map[string]string{
+		"plugin.toml":   testPluginToml + "\n[permissions]\nexec = [\"git\"]\n",
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var permissionsErr *PermissionsRequiredError
+	if err := plugins.SetEnabled("needs-exec", true, false); !errors.As(err, &permissionsErr) {
+		t.Fatalf("SetEnabled expected PermissionsRequiredError, got %v", err)
+	}
+	if plugin, _ := plugins.Get("needs-exec"); plugin.Enabled {
+		t.Fatal("Plugin was enabled without approving permissions")
+	}
+
+	if err := plugins.SetEnabled("needs-exec", true, true); err != nil {
+		t.Fatalf("SetEnabled returned error: %v", err)
+	}
+	if plugin, _ := plugins.Get("needs-exec"); !plugin.Enabled || !plugin.PermissionsApproved {
+		t.Fatal("Expected plugin to be enabled with approved permissions")
+	}
+}
+
+func TestRemovePluginPurgesData(t *testing.T) {
+	plugins := newTestPlugins(t)
+	pluginStore, err := datastore.Open(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginStore.Close()
+	plugins.store = pluginStore
+
+	for _, pluginId := range []string{"kept", "purged"} {
+		writeTestPlugin(t, plugins.pluginsDir, pluginId, map[string]string{
+			"plugin.toml":   testPluginToml,
+			"dist/index.js": "export const load = () => {};",
+		})
+	}
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pluginId := range []string{"kept", "purged"} {
+		if err := plugins.store.Set(datastore.PluginNamespace(pluginId), "key", "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := plugins.RemovePlugin("kept", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := plugins.RemovePlugin("purged", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, _ := plugins.store.Get(datastore.PluginNamespace("kept"), "key"); !found {
+		t.Fatal("Expected data to be kept when removing without purging")
+	}
+	if _, found, _ := plugins.store.Get(datastore.PluginNamespace("purged"), "key"); found {
+		t.Fatal("Expected data to be deleted when purging")
+	}
+}
+
+func TestBackendRequiresPermissionApproval(t *testing.T) {
+	plugins := newTestPlugins(t)
+	writeTestPlugin(t, plugins.pluginsDir, "with-backend", map[string]string{
+		"plugin.toml":   testPluginToml + "\n[backend]\ncommand = \"bin/backend\"\n",
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, plugins.pluginsDir, "outside-backend", map[string]string{
+		"plugin.toml":   testPluginToml + "\n[backend]\ncommand = \"../other/backend\"\n",
+		"dist/index.js": "export const load = () => {};",
+	})
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	var permissionsErr *PermissionsRequiredError
+	if err := plugins.SetEnabled("with-backend", true, false); !errors.As(err, &permissionsErr) || !permissionsErr.Permissions.Backend {
+		t.Fatalf("Expected enabling plugin with a backend to require approval, got %v", err)
+	}
+
+	if plugin, _ := plugins.Get("outside-backend"); plugin.Status != PluginStatusErrored {
+		t.Fatal("Expected plugin with a backend outside its directory to fail to load")
+	}
+}
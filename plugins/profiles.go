@@ -0,0 +1,179 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// UI modes plugins can be enabled in
+var uiModes = []cdp.UIMode{cdp.UIModeDesktop, cdp.UIModeDeck}
+
+func isUIMode(uiMode cdp.UIMode) bool {
+	for _, mode := range uiModes {
+		if mode == uiMode {
+			return true
+		}
+	}
+	return false
+}
+
+// Profile is a named set of plugins to enable in each UI mode. Activating a
+// profile enables its plugins and disables every other plugin.
+type Profile struct {
+	Name string `json:"name"`
+	// IDs of the plugins the profile enables, by UI mode
+	Plugins map[cdp.UIMode][]string `json:"plugins"`
+}
+
+// Profiles returns the saved profiles sorted by name, and the name of the
+// active profile, which is empty if there isn't one.
+func (p *Plugins) Profiles() ([]Profile, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	crksftProfiles, activeProfile := p.crksftConfig.GetProfiles()
+	profiles := make([]Profile, 0, len(crksftProfiles))
+	for _, name := range sortedKeys(crksftProfiles) {
+		profile := Profile{
+			Name:    name,
+			Plugins: make(map[cdp.UIMode][]string, len(uiModes)),
+		}
+		for _, uiMode := range uiModes {
+			profile.Plugins[uiMode] = append([]string{}, crksftProfiles[name].Plugins[string(uiMode)]...)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, activeProfile
+}
+
+// SaveProfile saves the plugins that are currently enabled in each UI mode as
+// the profile, replacing it if it already exists, and makes it the active
+// profile.
+func (p *Plugins) SaveProfile(name string) error {
+	if name == "" {
+		return errors.New("Profile name can't be empty")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.crksftConfig.Update(func(crksftConfig *config.CrksftConfig) {
+		if crksftConfig.Profiles == nil {
+			crksftConfig.Profiles = make(map[string]config.CrksftConfigProfile)
+		}
+		crksftConfig.Profiles[name] = config.CrksftConfigProfile{}
+		crksftConfig.ActiveProfile = name
+		for pluginId := range crksftConfig.Plugins {
+			saveToActiveProfile(crksftConfig, pluginId)
+		}
+	})
+}
+
+// ActivateProfile enables the profile's plugins in each UI mode and disables
+// every other plugin. Plugins with permissions that haven't been approved stay
+// disabled until they're enabled again.
+func (p *Plugins) ActivateProfile(name string) error {
+	p.mu.Lock()
+
+	crksftProfiles, _ := p.crksftConfig.GetProfiles()
+	profile, ok := crksftProfiles[name]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf(`Profile "%s" not found`, name)
+	}
+
+	err := p.crksftConfig.Update(func(crksftConfig *config.CrksftConfig) {
+		for _, pluginIds := range profile.Plugins {
+			for _, pluginId := range pluginIds {
+				if _, ok := crksftConfig.Plugins[pluginId]; !ok {
+					crksftConfig.Plugins[pluginId] = config.CrksftConfigPlugin{}
+				}
+			}
+		}
+
+		for pluginId, crksftPluginConfig := range crksftConfig.Plugins {
+			crksftPluginConfig.Enabled = false
+			crksftPluginConfig.EnabledModes = make(map[string]bool, len(uiModes))
+			for _, uiMode := range uiModes {
+				crksftPluginConfig.EnabledModes[string(uiMode)] = contains(profile.Plugins[string(uiMode)], pluginId)
+			}
+			crksftConfig.Plugins[pluginId] = crksftPluginConfig
+		}
+
+		crksftConfig.ActiveProfile = name
+	})
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	events := p.reapplyConfig()
+
+	p.mu.Unlock()
+
+	for _, event := range events {
+		p.publish(event)
+	}
+
+	return nil
+}
+
+// DeleteProfile deletes the profile. Plugins are left enabled as they are.
+func (p *Plugins) DeleteProfile(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	crksftProfiles, _ := p.crksftConfig.GetProfiles()
+	if _, ok := crksftProfiles[name]; !ok {
+		return fmt.Errorf(`Profile "%s" not found`, name)
+	}
+
+	return p.crksftConfig.Update(func(crksftConfig *config.CrksftConfig) {
+		delete(crksftConfig.Profiles, name)
+		if crksftConfig.ActiveProfile == name {
+			crksftConfig.ActiveProfile = ""
+		}
+	})
+}
+
+// saveToActiveProfile updates the active profile, if there is one, with the
+// UI modes the plugin is enabled in.
+func saveToActiveProfile(crksftConfig *config.CrksftConfig, pluginId string) {
+	profile, ok := crksftConfig.Profiles[crksftConfig.ActiveProfile]
+	if !ok {
+		return
+	}
+
+	crksftPluginConfig := crksftConfig.Plugins[pluginId]
+	plugins := make(map[string][]string, len(uiModes))
+	for _, uiMode := range uiModes {
+		pluginIds := []string{}
+		for _, id := range profile.Plugins[string(uiMode)] {
+			if id != pluginId {
+				pluginIds = append(pluginIds, id)
+			}
+		}
+		if crksftPluginConfig.IsEnabledIn(string(uiMode)) {
+			pluginIds = append(pluginIds, pluginId)
+			sort.Strings(pluginIds)
+		}
+		plugins[string(uiMode)] = pluginIds
+	}
+	profile.Plugins = plugins
+
+	crksftConfig.Profiles[crksftConfig.ActiveProfile] = profile
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package plugins
+
+import (
+	"reflect"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+func newProfilesTestPlugins(t *testing.T, pluginIds ...string) *Plugins {
+	pluginsDir := t.TempDir()
+	for _, pluginId := range pluginIds {
+		writeTestPlugin(t, pluginsDir, pluginId, map[string]string{
+			"plugin.toml":   testPluginToml,
+			"dist/index.js": "export const load = () => {};",
+		})
+	}
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plugins
+}
+
+func TestSetEnabledInOneUIMode(t *testing.T) {
+	plugins := newProfilesTestPlugins(t, "deck-only")
+
+	if err := plugins.SetEnabledIn("deck-only", cdp.UIModeDeck, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin, _ := plugins.Get("deck-only")
+	if !plugin.Enabled || !plugin.EnabledModes[cdp.UIModeDeck] || plugin.EnabledModes[cdp.UIModeDesktop] {
+		t.Fatalf("Expected plugin to only be enabled in deck mode, got %v", plugin.EnabledModes)
+	}
+
+	if err := plugins.SetEnabledIn("deck-only", "tv", true, false); err == nil {
+		t.Fatal("Expected error enabling plugin in unknown UI mode")
+	}
+}
+
+func TestActivateProfile(t *testing.T) {
+	plugins := newProfilesTestPlugins(t, "one", "two", "three")
+
+	plugins.SetEnabled("one", true, false)
+	plugins.SetEnabledIn("two", cdp.UIModeDesktop, true, false)
+	if err := plugins.SaveProfile("minimal"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes while the profile is active are saved to it
+	plugins.SetEnabledIn("three", cdp.UIModeDeck, true, false)
+
+	profiles, active := plugins.Profiles()
+	expected := []Profile{{
+		Name: "minimal",
+		Plugins: map[cdp.UIMode][]string{
+			cdp.UIModeDesktop: {"one", "two"},
+			cdp.UIModeDeck:    {"one", "three"},
+		},
+	}}
+	if active != "minimal" || !reflect.DeepEqual(profiles, expected) {
+		t.Fatalf("Expected profiles %v with minimal active, got %v with %q active", expected, profiles, active)
+	}
+
+	if err := plugins.SaveProfile("none"); err != nil {
+		t.Fatal(err)
+	}
+	plugins.SetEnabled("one", false, false)
+	plugins.SetEnabled("two", false, false)
+	plugins.SetEnabled("three", false, false)
+
+	if err := plugins.ActivateProfile("minimal"); err != nil {
+		t.Fatal(err)
+	}
+	for pluginId, expected := range map[string]map[cdp.UIMode]bool{
+		"one":   {cdp.UIModeDesktop: true, cdp.UIModeDeck: true},
+		"two":   {cdp.UIModeDesktop: true, cdp.UIModeDeck: false},
+		"three": {cdp.UIModeDesktop: false, cdp.UIModeDeck: true},
+	} {
+		if plugin, _ := plugins.Get(pluginId); !reflect.DeepEqual(plugin.EnabledModes, expected) {
+			t.Fatalf("Expected %s to be enabled in %v, got %v", pluginId, expected, plugin.EnabledModes)
+		}
+	}
+
+	if err := plugins.ActivateProfile("none"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pluginId := range []string{"one", "two", "three"} {
+		if plugin, _ := plugins.Get(pluginId); plugin.Enabled {
+			t.Fatalf("Expected %s to be disabled by the profile", pluginId)
+		}
+	}
+
+	if err := plugins.ActivateProfile("missing"); err == nil {
+		t.Fatal("Expected error activating missing profile")
+	}
+}
@@ -0,0 +1,293 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/build"
+	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/semver"
+)
+
+// resolveRequirements checks every loaded plugin's requirements, setting the
+// status of plugins whose requirements aren't met to
+// PluginStatusUnmetRequirements with the reason as the error. It returns the
+// order plugins should be loaded in, where every plugin comes after the
+// plugins it requires. Plugins that can't be loaded are at the end.
+func resolveRequirements(pluginMap PluginMap) []string {
+	// Requirements are checked from scratch, so clear the results of the last
+	// check
+	for id, plugin := range pluginMap {
+		if plugin.Status == PluginStatusUnmetRequirements {
+			plugin.Status = PluginStatusOk
+			plugin.Error = ""
+			pluginMap[id] = plugin
+		}
+	}
+
+	// A plugin whose requirements aren't met can cause the plugins that
+	// require it to fail too, so keep checking until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for id, plugin := range pluginMap {
+			if plugin.Status != PluginStatusOk {
+				continue
+			}
+
+			if reasons := unmetRequirements(plugin, pluginMap); len(reasons) > 0 {
+				plugin.Status = PluginStatusUnmetRequirements
+				plugin.Error = strings.Join(reasons, "; ")
+				pluginMap[id] = plugin
+				changed = true
+			}
+		}
+	}
+
+	order := sortByRequirements(pluginMap)
+
+	loaded := make(map[string]bool, len(order))
+	for _, id := range order {
+		loaded[id] = true
+	}
+
+	// Anything left over is either part of a requirement cycle, or requires a
+	// plugin that is
+	leftOver := map[string]bool{}
+	for id, plugin := range pluginMap {
+		if plugin.Status == PluginStatusOk && !loaded[id] {
+			leftOver[id] = true
+		}
+	}
+	inCycle := map[string]bool{}
+	for _, cycle := range requirementCycles(pluginMap, leftOver) {
+		for _, id := range cycle {
+			inCycle[id] = true
+			plugin := pluginMap[id]
+			plugin.Status = PluginStatusUnmetRequirements
+			plugin.Error = fmt.Sprintf("Circular plugin requirements between %s", strings.Join(cycle, ", "))
+			pluginMap[id] = plugin
+		}
+	}
+	for id := range leftOver {
+		if inCycle[id] {
+			continue
+		}
+
+		plugin := pluginMap[id]
+		reasons := []string{}
+		for _, requiredId := range sortedKeys(plugin.Config.Requires.Plugins) {
+			if leftOver[requiredId] {
+				reasons = append(reasons, fmt.Sprintf(`Requires plugin "%s", which couldn't be loaded`, requiredId))
+			}
+		}
+		plugin.Status = PluginStatusUnmetRequirements
+		plugin.Error = strings.Join(reasons, "; ")
+		pluginMap[id] = plugin
+	}
+
+	notLoaded := []string{}
+	for id := range pluginMap {
+		if !loaded[id] {
+			notLoaded = append(notLoaded, id)
+		}
+	}
+	sort.Strings(notLoaded)
+
+	return append(order, notLoaded...)
+}
+
+// unmetRequirements returns the reasons the plugin's requirements aren't met.
+func unmetRequirements(plugin Plugin, pluginMap PluginMap) []string {
+	reasons := []string{}
+	requires := plugin.Config.Requires
+
+	// Constraints were validated when the config was loaded
+	if constraint, _ := semver.ParseConstraint(requires.Crankshaft); !constraint.Check(semver.MustParse(build.VERSION)) {
+		reasons = append(reasons, fmt.Sprintf("Requires Crankshaft %s, but this is version %s", constraint, build.VERSION))
+	}
+
+	for _, requiredId := range sortedKeys(requires.Plugins) {
+		constraint, _ := semver.ParseConstraint(requires.Plugins[requiredId])
+
+		required, ok := pluginMap[requiredId]
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf(`Requires plugin "%s", which isn't installed`, requiredId))
+			continue
+		}
+		if required.Status != PluginStatusOk {
+			reasons = append(reasons, fmt.Sprintf(`Requires plugin "%s", which couldn't be loaded`, requiredId))
+			continue
+		}
+
+		version, err := semver.Parse(required.Config.Version)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf(`Requires plugin "%s" %s, but its version "%s" isn't valid`, requiredId, constraint, required.Config.Version))
+			continue
+		}
+		if !constraint.Check(version) {
+			reasons = append(reasons, fmt.Sprintf(`Requires plugin "%s" %s, but version %s is installed`, requiredId, constraint, version))
+		}
+	}
+
+	return reasons
+}
+
+// sortByRequirements topologically sorts the plugins that loaded
+// successfully, so every plugin comes after the plugins it requires. Plugins
+// that are part of a requirement cycle are left out. Ties are broken by plugin
+// ID so the order is stable.
+func sortByRequirements(pluginMap PluginMap) []string {
+	// Number of unsorted plugins each plugin requires
+	remaining := map[string]int{}
+	// Plugins that require each plugin
+	dependents := map[string][]string{}
+
+	for id, plugin := range pluginMap {
+		if plugin.Status != PluginStatusOk {
+			continue
+		}
+		remaining[id] = len(plugin.Config.Requires.Plugins)
+		for requiredId := range plugin.Config.Requires.Plugins {
+			dependents[requiredId] = append(dependents[requiredId], id)
+		}
+	}
+
+	ready := []string{}
+	for id, count := range remaining {
+		if count == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	order := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+
+		for _, dependent := range dependents[id] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	return order
+}
+
+// requirementCycles returns the groups of plugins among ids that require each
+// other, each sorted by plugin ID. Plugins that only require a plugin in a
+// cycle aren't part of it.
+func requirementCycles(pluginMap PluginMap, ids map[string]bool) [][]string {
+	// Tarjan's strongly connected components algorithm, over the requirements
+	// between the given plugins
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	cycles := [][]string{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		index[id] = len(index)
+		lowLink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		for _, requiredId := range sortedKeys(pluginMap[id].Config.Requires.Plugins) {
+			if !ids[requiredId] {
+				continue
+			}
+			if _, visited := index[requiredId]; !visited {
+				visit(requiredId)
+				if lowLink[requiredId] < lowLink[id] {
+					lowLink[id] = lowLink[requiredId]
+				}
+			} else if onStack[requiredId] && index[requiredId] < lowLink[id] {
+				lowLink[id] = index[requiredId]
+			}
+		}
+
+		if lowLink[id] != index[id] {
+			return
+		}
+
+		component := []string{}
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			component = append(component, member)
+			if member == id {
+				break
+			}
+		}
+
+		// A single plugin is only a cycle if it requires itself
+		_, requiresItself := pluginMap[id].Config.Requires.Plugins[id]
+		if len(component) > 1 || requiresItself {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, id := range sortedKeys(ids) {
+		if _, visited := index[id]; !visited {
+			visit(id)
+		}
+	}
+
+	return cycles
+}
+
+// disabledRequirements returns the plugins the plugin requires that aren't
+// enabled in the UI mode.
+func disabledRequirements(plugin Plugin, pluginMap PluginMap, uiMode cdp.UIMode) []string {
+	disabled := []string{}
+	for _, requiredId := range sortedKeys(plugin.Config.Requires.Plugins) {
+		if !pluginMap[requiredId].EnabledModes[uiMode] {
+			disabled = append(disabled, requiredId)
+		}
+	}
+	return disabled
+}
+
+// disabledRequirementsIn returns the reasons the plugin can't be enabled in
+// the UI mode, or in every UI mode if uiMode is empty, because plugins it
+// requires aren't enabled there.
+func disabledRequirementsIn(plugin Plugin, pluginMap PluginMap, uiMode cdp.UIMode) []string {
+	modes := uiModes
+	if uiMode != "" {
+		modes = []cdp.UIMode{uiMode}
+	}
+
+	// UI modes each required plugin is disabled in
+	disabledModes := map[string][]string{}
+	for _, mode := range modes {
+		for _, requiredId := range disabledRequirements(plugin, pluginMap, mode) {
+			disabledModes[requiredId] = append(disabledModes[requiredId], string(mode))
+		}
+	}
+
+	reasons := []string{}
+	for _, requiredId := range sortedKeys(disabledModes) {
+		if len(disabledModes[requiredId]) == len(uiModes) {
+			reasons = append(reasons, fmt.Sprintf(`it requires plugin "%s", which isn't enabled`, requiredId))
+		} else {
+			reasons = append(reasons, fmt.Sprintf(`it requires plugin "%s", which isn't enabled in %s mode`, requiredId, strings.Join(disabledModes[requiredId], " or ")))
+		}
+	}
+	return reasons
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,212 @@
+package plugins
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// testPluginTomlRequiring returns a plugin.toml with the given version and
+// requirements section.
+func testPluginTomlRequiring(version, requires string) string {
+	return fmt.Sprintf(`
+name = "Test Plugin"
+version = "%s"
+
+[entrypoints.desktop]
+library = true
+
+[entrypoints.deck]
+library = true
+
+%s
+`, version, requires)
+}
+
+func TestResolveRequirements(t *testing.T) {
+	pluginsDir := t.TempDir()
+
+	testPlugins := map[string]string{
+		"base":          testPluginTomlRequiring("1.2.0", ""),
+		"ui":            testPluginTomlRequiring("1.0.0", "[requires.plugins]\nbase = \"^1.0.0\""),
+		"app":           testPluginTomlRequiring("1.0.0", "[requires.plugins]\nui = \">=1.0.0\"\nbase = \"^1.2\""),
+		"missing-dep":   testPluginTomlRequiring("1.0.0", "[requires.plugins]\nnot-installed = \"*\""),
+		"old-dep":       testPluginTomlRequiring("1.0.0", "[requires.plugins]\nbase = \"^2.0.0\""),
+		"needs-missing": testPluginTomlRequiring("1.0.0", "[requires.plugins]\nmissing-dep = \"*\""),
+		"future":        testPluginTomlRequiring("1.0.0", "[requires]\ncrankshaft = \">=99.0.0\""),
+		"current":       testPluginTomlRequiring("1.0.0", "[requires]\ncrankshaft = \">=0.0.1\""),
+		"cycle-a":       testPluginTomlRequiring("1.0.0", "[requires.plugins]\ncycle-b = \"*\""),
+		"cycle-b":       testPluginTomlRequiring("1.0.0", "[requires.plugins]\ncycle-a = \"*\""),
+		"needs-cycle":   testPluginTomlRequiring("1.0.0", "[requires.plugins]\ncycle-a = \"*\""),
+	}
+	for id, pluginToml := range testPlugins {
+		writeTestPlugin(t, pluginsDir, id, map[string]string{
+			"plugin.toml":   pluginToml,
+			"dist/index.js": "export const load = () => {};",
+		})
+	}
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedErrors := map[string]string{
+		"missing-dep":   `"not-installed", which isn't installed`,
+		"old-dep":       `"base" ^2.0.0, but version 1.2.0 is installed`,
+		"needs-missing": `"missing-dep", which couldn't be loaded`,
+		"future":        "Requires Crankshaft >=99.0.0",
+		"cycle-a":       "Circular plugin requirements between cycle-a, cycle-b",
+		"cycle-b":       "Circular plugin requirements between cycle-a, cycle-b",
+		"needs-cycle":   `Requires plugin "cycle-a", which couldn't be loaded`,
+	}
+	for id := range testPlugins {
+		plugin, _ := plugins.Get(id)
+		expectedError, unmet := expectedErrors[id]
+
+		if !unmet {
+			if plugin.Status != PluginStatusOk {
+				t.Fatalf("Expected %v to be ok, got %v: %v", id, plugin.Status, plugin.Error)
+			}
+			continue
+		}
+
+		if plugin.Status != PluginStatusUnmetRequirements {
+			t.Fatalf("Expected %v to have unmet requirements, got %v", id, plugin.Status)
+		}
+		if !strings.Contains(plugin.Error, expectedError) {
+			t.Fatalf(`Expected %v error to contain "%v", got "%v"`, id, expectedError, plugin.Error)
+		}
+		if err := plugins.SetEnabled(id, true, true); err == nil {
+			t.Fatalf("SetEnabled expected error enabling %v, got nil", id)
+		}
+	}
+
+	loadable := []string{}
+	for _, id := range plugins.LoadOrder() {
+		if plugin, _ := plugins.Get(id); plugin.Status == PluginStatusOk {
+			loadable = append(loadable, id)
+		}
+	}
+	expectedOrder := []string{"base", "current", "ui", "app"}
+	if !reflect.DeepEqual(loadable, expectedOrder) {
+		t.Fatalf(`Expected load order "%v", got "%v"`, expectedOrder, loadable)
+	}
+}
+
+func TestRebuildReresolvesRequirements(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "base", map[string]string{
+		"plugin.toml":   testPluginTomlRequiring("1.0.0", ""),
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, pluginsDir, "dependent", map[string]string{
+		"plugin.toml":   testPluginTomlRequiring("1.0.0", "[requires.plugins]\nbase = \">=2.0.0\""),
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plugin, _ := plugins.Get("dependent"); plugin.Status != PluginStatusUnmetRequirements {
+		t.Fatalf("Expected dependent to have unmet requirements, got %v", plugin.Status)
+	}
+
+	// Updating the required plugin should fix the dependent
+	writeTestPlugin(t, pluginsDir, "base", map[string]string{
+		"plugin.toml": testPluginTomlRequiring("2.0.0", ""),
+	})
+	if err := plugins.RebuildPlugin("base"); err != nil {
+		t.Fatal(err)
+	}
+
+	if plugin, _ := plugins.Get("dependent"); plugin.Status != PluginStatusOk {
+		t.Fatalf("Expected dependent to be ok after updating base, got %v: %v", plugin.Status, plugin.Error)
+	}
+}
+
+func TestRequiredPluginsMustBeEnabled(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "base", map[string]string{
+		"plugin.toml":   testPluginTomlRequiring("1.0.0", ""),
+		"dist/index.js": "export const load = () => {};",
+	})
+	writeTestPlugin(t, pluginsDir, "dependent", map[string]string{
+		"plugin.toml":   testPluginTomlRequiring("1.0.0", "[requires.plugins]\nbase = \"*\""),
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = plugins.SetEnabled("dependent", true, true)
+	if err == nil || !strings.Contains(err.Error(), `requires plugin "base", which isn't enabled`) {
+		t.Fatalf("Expected enabling dependent without base to fail, got %v", err)
+	}
+
+	if err := plugins.SetEnabled("base", true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := plugins.SetEnabled("dependent", true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	// Disabling base in one UI mode disables the dependent there too
+	if err := plugins.SetEnabledIn("base", cdp.UIModeDeck, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	dependent, _ := plugins.Get("dependent")
+	if dependent.EnabledModes[cdp.UIModeDeck] || !dependent.EnabledModes[cdp.UIModeDesktop] {
+		t.Fatalf("Expected dependent to only be enabled in desktop mode, got %v", dependent.EnabledModes)
+	}
+
+	// It's still enabled in desktop mode, so the change is published as
+	// enabled like any other change to its UI modes
+	published := []Event{}
+	for len(events) > 0 {
+		published = append(published, <-events)
+	}
+	if !reflect.DeepEqual(published[len(published)-1], Event{Type: EventEnabled, PluginId: "dependent"}) {
+		t.Fatalf("Expected an event for dependent, got %v", published)
+	}
+
+	err = plugins.SetEnabledIn("dependent", cdp.UIModeDeck, true, false)
+	if err == nil || !strings.Contains(err.Error(), `which isn't enabled in deck mode`) {
+		t.Fatalf("Expected enabling dependent in deck mode to fail, got %v", err)
+	}
+
+	// Enabling base again brings the dependent back
+	if err := plugins.SetEnabledIn("base", cdp.UIModeDeck, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if dependent, _ := plugins.Get("dependent"); !dependent.EnabledModes[cdp.UIModeDeck] {
+		t.Fatalf("Expected dependent to be enabled in deck mode again, got %v", dependent.EnabledModes)
+	}
+}
@@ -0,0 +1,87 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const scaffoldPluginToml = `name = "%s"
+version = "0.1.0"
+link = ""
+source = ""
+
+[author]
+name = ""
+link = ""
+
+[entrypoints.desktop]
+library = true
+
+[entrypoints.deck]
+library = true
+
+[store]
+description = ""
+
+[store.platforms.linux]
+supported = true
+
+[store.platforms.windows]
+supported = true
+
+[store.platforms.darwin]
+supported = false
+
+[build]
+entry = "src/index.ts"
+`
+
+const scaffoldPluginScript = `// Called when the plugin is loaded, smm is the Crankshaft API
+export const load = (smm: any) => {
+  smm.Toast.addToast('Hello from %s!');
+};
+
+// Called when the plugin is unloaded, this should undo anything load did
+export const unload = (smm: any) => {};
+`
+
+var tomlStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// CreatePlugin creates a new plugin named name in pluginDir, with a plugin.toml
+// that has entrypoints for both UI modes and a TypeScript entry point to build
+// the plugin from. pluginDir must not exist or be empty. The plugin's ID is
+// the name of its directory.
+func CreatePlugin(pluginDir, name string) error {
+	if name == "" {
+		return errors.New("Plugin name is required")
+	}
+
+	entries, err := os.ReadDir(pluginDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf(`Plugin directory "%s" isn't empty`, pluginDir)
+	}
+
+	files := map[string]string{
+		"plugin.toml":  fmt.Sprintf(scaffoldPluginToml, tomlStringEscaper.Replace(name)),
+		"src/index.ts": fmt.Sprintf(scaffoldPluginScript, strings.ReplaceAll(name, "'", `\'`)),
+	}
+
+	for file, contents := range files {
+		filePath := filepath.Join(pluginDir, file)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			return fmt.Errorf(`Error writing "%s": %v`, filePath, err)
+		}
+	}
+
+	return nil
+}
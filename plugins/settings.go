@@ -0,0 +1,208 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// SettingType is the type of value a plugin setting holds.
+type SettingType string
+
+const (
+	SettingBoolean SettingType = "boolean"
+	// Numbers can be limited to a range with min and max
+	SettingNumber SettingType = "number"
+	// Enums are strings limited to the setting's options
+	SettingEnum   SettingType = "enum"
+	SettingString SettingType = "string"
+)
+
+// setting is a setting declared in the settings section of plugin.toml.
+type setting struct {
+	Type        SettingType `json:"type"`
+	Label       string      `json:"label"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	Options     []string    `json:"options,omitempty"`
+}
+
+// SettingsError is returned when setting values that don't match the plugin's
+// settings schema.
+type SettingsError struct {
+	PluginId string
+	Key      string
+	Reason   string
+}
+
+func (e *SettingsError) Error() string {
+	return fmt.Sprintf(`Invalid value for setting "%s" of plugin "%s": %s`, e.Key, e.PluginId, e.Reason)
+}
+
+// validateSchema checks that the setting's type is known and its default is a
+// valid value.
+func (s setting) validateSchema() error {
+	switch s.Type {
+	case SettingBoolean, SettingNumber, SettingString:
+	case SettingEnum:
+		if len(s.Options) == 0 {
+			return errors.New("enum settings must have options")
+		}
+	default:
+		return fmt.Errorf(`unknown type "%s"`, s.Type)
+	}
+
+	if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+		return errors.New("min is greater than max")
+	}
+
+	if s.Default == nil {
+		return errors.New("missing default")
+	}
+	if _, err := s.normalize(s.Default); err != nil {
+		return fmt.Errorf("invalid default: %v", err)
+	}
+
+	return nil
+}
+
+// normalize checks that value is valid for the setting, and returns it as the
+// type used for the setting's values. Numbers are always float64, since they
+// can be decoded from TOML as integers.
+func (s setting) normalize(value interface{}) (interface{}, error) {
+	switch s.Type {
+	case SettingBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, errors.New("expected a boolean")
+
+	case SettingNumber:
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int64:
+			n = float64(v)
+		case int:
+			n = float64(v)
+		default:
+			return nil, errors.New("expected a number")
+		}
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, errors.New("expected a finite number")
+		}
+		if s.Min != nil && n < *s.Min {
+			return nil, fmt.Errorf("must be at least %v", *s.Min)
+		}
+		if s.Max != nil && n > *s.Max {
+			return nil, fmt.Errorf("must be at most %v", *s.Max)
+		}
+		return n, nil
+
+	case SettingEnum:
+		str, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a string")
+		}
+		for _, option := range s.Options {
+			if str == option {
+				return str, nil
+			}
+		}
+		return nil, fmt.Errorf(`"%s" isn't one of the options %v`, str, s.Options)
+
+	case SettingString:
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+		return nil, errors.New("expected a string")
+	}
+
+	return nil, fmt.Errorf(`unknown type "%s"`, s.Type)
+}
+
+// GetSettings returns the plugin's settings, using the default for any setting
+// that hasn't been set. Saved values that no longer match the schema, for
+// example after a plugin update changes it, are replaced with the default.
+func (p *Plugins) GetSettings(pluginId string) (map[string]interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	plugin, ok := p.pluginMap[pluginId]
+	if !ok {
+		return nil, errors.New("Plugin not found: " + pluginId)
+	}
+
+	return p.settingsWithDefaults(plugin), nil
+}
+
+// settingsWithDefaults returns the plugin's saved settings merged with the
+// defaults from its schema.
+// p.mu must be held by the caller.
+func (p *Plugins) settingsWithDefaults(plugin Plugin) map[string]interface{} {
+	saved := p.crksftConfig.GetPlugin(plugin.Id).Settings
+
+	settings := make(map[string]interface{}, len(plugin.Config.Settings))
+	for key, schema := range plugin.Config.Settings {
+		// Defaults were validated when the config was loaded
+		settings[key], _ = schema.normalize(schema.Default)
+
+		if value, ok := saved[key]; ok {
+			if normalized, err := schema.normalize(value); err == nil {
+				settings[key] = normalized
+			}
+		}
+	}
+
+	return settings
+}
+
+// SetSettings validates the given values against the plugin's settings schema
+// and saves them. Settings that aren't included keep their current values. If
+// any value is invalid, a SettingsError is returned and nothing is saved. The
+// plugin's updated settings are returned.
+func (p *Plugins) SetSettings(pluginId string, values map[string]interface{}) (map[string]interface{}, error) {
+	p.mu.Lock()
+
+	plugin, ok := p.pluginMap[pluginId]
+	if !ok {
+		p.mu.Unlock()
+		return nil, errors.New("Plugin not found: " + pluginId)
+	}
+
+	settings := p.settingsWithDefaults(plugin)
+	// Validate in a stable order so the same error is always reported first
+	for _, key := range sortedKeys(values) {
+		schema, ok := plugin.Config.Settings[key]
+		if !ok {
+			p.mu.Unlock()
+			return nil, &SettingsError{pluginId, key, "the plugin doesn't have this setting"}
+		}
+
+		normalized, err := schema.normalize(values[key])
+		if err != nil {
+			p.mu.Unlock()
+			return nil, &SettingsError{pluginId, key, err.Error()}
+		}
+		settings[key] = normalized
+	}
+
+	err := p.crksftConfig.UpdatePlugin(pluginId, func(crksftPluginConfig *config.CrksftConfigPlugin) {
+		crksftPluginConfig.Settings = settings
+	})
+
+	p.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.publish(Event{Type: EventSettingsChanged, PluginId: pluginId})
+
+	return settings, nil
+}
@@ -0,0 +1,163 @@
+package plugins
+
+import (
+	"errors"
+	"testing"
+)
+
+const testSettingsToml = testPluginToml + `
+[settings.enabled]
+type = "boolean"
+label = "Enabled"
+default = true
+
+[settings.volume]
+type = "number"
+label = "Volume"
+default = 50
+min = 0
+max = 100
+
+[settings.theme]
+type = "enum"
+label = "Theme"
+default = "dark"
+options = ["dark", "light"]
+
+[settings.greeting]
+type = "string"
+label = "Greeting"
+default = "hello"
+`
+
+func TestGetSettingsDefaults(t *testing.T) {
+	plugins := newTestPlugins(t)
+	writeTestPlugin(t, plugins.pluginsDir, "test-plugin", map[string]string{
+		"plugin.toml":   testSettingsToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := plugins.GetSettings("test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"enabled":  true,
+		"volume":   float64(50),
+		"theme":    "dark",
+		"greeting": "hello",
+	}
+	for key, value := range expected {
+		if settings[key] != value {
+			t.Fatalf(`Expected setting "%s" to be %v, got %v`, key, value, settings[key])
+		}
+	}
+}
+
+func TestSetSettings(t *testing.T) {
+	plugins := newTestPlugins(t)
+	writeTestPlugin(t, plugins.pluginsDir, "test-plugin", map[string]string{
+		"plugin.toml":   testSettingsToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	if _, err := plugins.SetSettings("test-plugin", map[string]interface{}{
+		"volume": float64(75),
+		"theme":  "light",
+	}); err != nil {
+		t.Fatalf("SetSettings returned error: %v", err)
+	}
+
+	event := <-events
+	if event.Type != EventSettingsChanged || event.PluginId != "test-plugin" {
+		t.Fatalf("Expected settings changed event, got %+v", event)
+	}
+
+	// Settings are persisted, and ones that weren't set keep their defaults
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := plugins.GetSettings("test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["volume"] != float64(75) || settings["theme"] != "light" || settings["enabled"] != true {
+		t.Fatalf("Unexpected settings after SetSettings: %v", settings)
+	}
+}
+
+func TestSetSettingsRejectsInvalid(t *testing.T) {
+	plugins := newTestPlugins(t)
+	writeTestPlugin(t, plugins.pluginsDir, "test-plugin", map[string]string{
+		"plugin.toml":   testSettingsToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+	if err := plugins.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]map[string]interface{}{
+		"unknown setting":   {"missing": true},
+		"wrong type":        {"enabled": "yes"},
+		"below min":         {"volume": float64(-1)},
+		"above max":         {"volume": float64(101)},
+		"not an option":     {"theme": "blue"},
+		"one invalid value": {"greeting": "hi", "volume": float64(200)},
+	}
+
+	for name, values := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := plugins.SetSettings("test-plugin", values)
+			var settingsErr *SettingsError
+			if !errors.As(err, &settingsErr) {
+				t.Fatalf("Expected SettingsError, got %v", err)
+			}
+
+			// Nothing is saved if any value is invalid
+			settings, err := plugins.GetSettings("test-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings["greeting"] != "hello" || settings["volume"] != float64(50) {
+				t.Fatalf("Expected settings to be unchanged, got %v", settings)
+			}
+		})
+	}
+}
+
+func TestInvalidSettingsSchema(t *testing.T) {
+	tests := map[string]string{
+		"unknown type":         "[settings.x]\ntype = \"color\"\nlabel = \"X\"\ndefault = \"red\"\n",
+		"missing default":      "[settings.x]\ntype = \"boolean\"\nlabel = \"X\"\n",
+		"invalid default":      "[settings.x]\ntype = \"number\"\nlabel = \"X\"\ndefault = 5\nmax = 1\n",
+		"enum without options": "[settings.x]\ntype = \"enum\"\nlabel = \"X\"\ndefault = \"a\"\n",
+	}
+
+	for name, settingsToml := range tests {
+		t.Run(name, func(t *testing.T) {
+			plugins := newTestPlugins(t)
+			writeTestPlugin(t, plugins.pluginsDir, "test-plugin", map[string]string{
+				"plugin.toml":   testPluginToml + "\n" + settingsToml,
+				"dist/index.js": "export const load = () => {};",
+			})
+			if err := plugins.Reload(); err != nil {
+				t.Fatal(err)
+			}
+
+			plugin, ok := plugins.Get("test-plugin")
+			if ok && plugin.Status != PluginStatusErrored {
+				t.Fatal("Expected plugin with invalid settings schema to fail to load")
+			}
+		})
+	}
+}
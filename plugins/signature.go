@@ -0,0 +1,127 @@
+package plugins
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+// Public keys of plugin publishers trusted by every Crankshaft install, as
+// base64 encoded ed25519 keys. Users can trust more keys with the
+// trusted-keys option in the Crankshaft config.
+//
+// The registry doesn't publish signatures yet, its key will be added here once
+// it does.
+var builtinTrustedKeys = []string{}
+
+// SignatureError is returned when installing a plugin archive that isn't
+// signed by a trusted key, and the user hasn't allowed unsigned archives for
+// the plugin.
+type SignatureError struct {
+	PluginId string
+	// Reason the signature couldn't be verified
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf(`Plugin "%s" couldn't be verified: %s`, e.PluginId, e.Reason)
+}
+
+// trustedKeys returns the built-in trusted keys along with the keys the user
+// added in the Crankshaft config. If the archive is installed from a
+// repository, the repository's trusted key is included too.
+func (p *Plugins) trustedKeys(repository string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	keys := append(append([]string{}, builtinTrustedKeys...), p.crksftConfig.GetTrustedKeys()...)
+	if repository == "" {
+		return keys
+	}
+	for _, repo := range p.crksftConfig.GetRepositories() {
+		if repo.Name == repository && repo.TrustedKey != "" {
+			keys = append(keys, repo.TrustedKey)
+		}
+	}
+	return keys
+}
+
+// allowsUnsigned returns if the user's recorded allowance covers installing an
+// archive of the plugin that couldn't be verified, and why not if it doesn't.
+// It only covers archives without a signature, as one that fails to verify may
+// have been tampered with, and it doesn't cover replacing a signed version of
+// the plugin with an unverified one.
+func (p *Plugins) allowsUnsigned(pluginId, signature, reason string) (bool, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	crksftPluginConfig := p.crksftConfig.GetPlugin(pluginId)
+	if crksftPluginConfig.SignedBy != "" {
+		return false, reason + ", and the installed version is signed"
+	}
+	return signature == "" && crksftPluginConfig.AllowUnsigned, reason
+}
+
+// recordTrust records the key that signed the installed plugin and the
+// repository it was installed from in its Crankshaft config, and whether the
+// user allowed unsigned archives. Installing a signed archive clears the
+// allowance, so it has to be given again to go back to an unsigned one.
+func (p *Plugins) recordTrust(pluginId, repository, signedBy string, allowUnsigned bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.crksftConfig.UpdatePlugin(pluginId, func(crksftPluginConfig *config.CrksftConfigPlugin) {
+		crksftPluginConfig.Repository = repository
+		crksftPluginConfig.SignedBy = signedBy
+		if signedBy != "" {
+			crksftPluginConfig.AllowUnsigned = false
+		} else if allowUnsigned {
+			crksftPluginConfig.AllowUnsigned = true
+		}
+	})
+
+	// The plugin was reloaded before it was recorded, so update its repository
+	// too
+	if plugin, ok := p.pluginMap[pluginId]; ok {
+		plugin.Repository = repository
+		p.pluginMap[pluginId] = plugin
+	}
+
+	return err
+}
+
+// verifyArchiveSignature checks the archive's detached base64 ed25519
+// signature against the trusted keys. It returns the key that signed the
+// archive, or the reason the archive couldn't be verified.
+func verifyArchiveSignature(archivePath, signature string, trustedKeys []string) (signedBy string, reason string, err error) {
+	if signature == "" {
+		return "", "the archive isn't signed", nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", "the archive's signature is malformed", nil
+	}
+
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading plugin archive to verify signature: %v", err)
+	}
+
+	for _, key := range trustedKeys {
+		publicKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			continue
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(publicKey), archive, sig) {
+			return key, "", nil
+		}
+	}
+
+	return "", "the archive isn't signed by a trusted key", nil
+}
@@ -0,0 +1,159 @@
+package plugins
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+func TestInstallVerifiesSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrustedKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	})
+	url, sum := serveTestArchive(t, archive)
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, archive))
+	untrustedSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(untrustedKey, archive))
+	trustedKey := base64.StdEncoding.EncodeToString(publicKey)
+
+	tests := map[string]struct {
+		signature     string
+		allowUnsigned bool
+		expectErr     bool
+	}{
+		"signed by trusted key":     {signature: signature},
+		"unsigned":                  {expectErr: true},
+		"signed by untrusted key":   {signature: untrustedSignature, expectErr: true},
+		"malformed signature":       {signature: "not a signature", expectErr: true},
+		"unsigned allowed by user":  {allowUnsigned: true},
+		"untrusted allowed by user": {signature: untrustedSignature, allowUnsigned: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			plugins := newTestPlugins(t)
+			plugins.crksftConfig.TrustedKeys = []string{trustedKey}
+
+			_, err := plugins.Install(url, sum, test.signature, test.allowUnsigned)
+
+			if test.expectErr {
+				var signatureErr *SignatureError
+				if !errors.As(err, &signatureErr) {
+					t.Fatalf("Install expected SignatureError, got %v", err)
+				}
+				if _, ok := plugins.Get("test-plugin"); ok {
+					t.Fatal("Plugin was installed without a valid signature")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Install returned error: %v", err)
+			}
+
+			crksftPluginConfig := plugins.crksftConfig.Plugins["test-plugin"]
+			if crksftPluginConfig.AllowUnsigned != test.allowUnsigned {
+				t.Fatalf("Expected allow-unsigned to be recorded as %v, got %v", test.allowUnsigned, crksftPluginConfig.AllowUnsigned)
+			}
+			if test.signature == signature && crksftPluginConfig.SignedBy != trustedKey {
+				t.Fatalf(`Expected signed-by to be recorded as "%v", got "%v"`, trustedKey, crksftPluginConfig.SignedBy)
+			}
+		})
+	}
+}
+
+func TestInstallRemembersAllowUnsigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrustedKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins := newTestPlugins(t)
+	plugins.crksftConfig.TrustedKeys = []string{base64.StdEncoding.EncodeToString(publicKey)}
+
+	archive := makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	})
+	url, sum := serveTestArchive(t, archive)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, archive))
+	untrustedSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(untrustedKey, archive))
+
+	if _, err := plugins.Install(url, sum, "", true); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+
+	// Updates don't need to be allowed again once the user allowed unsigned
+	// archives for the plugin
+	if _, err := plugins.Install(url, sum, "", false); err != nil {
+		t.Fatalf("Install of update returned error: %v", err)
+	}
+
+	// The allowance doesn't cover signatures that fail to verify
+	var signatureErr *SignatureError
+	if _, err := plugins.Install(url, sum, untrustedSignature, false); !errors.As(err, &signatureErr) {
+		t.Fatalf("Install with untrusted signature expected SignatureError, got %v", err)
+	}
+
+	// Once a signed version is installed, going back to an unsigned one has to
+	// be allowed again
+	if _, err := plugins.Install(url, sum, signature, false); err != nil {
+		t.Fatalf("Install of signed update returned error: %v", err)
+	}
+	if _, err := plugins.Install(url, sum, "", false); !errors.As(err, &signatureErr) {
+		t.Fatalf("Install of unsigned downgrade expected SignatureError, got %v", err)
+	}
+	if _, err := plugins.Install(url, sum, "", true); err != nil {
+		t.Fatalf("Install of allowed unsigned downgrade returned error: %v", err)
+	}
+}
+
+func TestInstallFromTrustsRepositoryKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := makeTestArchive(t, map[string]string{
+		"test-plugin/plugin.toml":   testPluginToml,
+		"test-plugin/dist/index.js": "export const load = () => {};",
+	})
+	url, sum := serveTestArchive(t, archive)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, archive))
+
+	plugins := newTestPlugins(t)
+	plugins.crksftConfig.Repositories = []config.CrksftConfigRepository{
+		{Name: "mirror", Url: "https://example.com/plugins.json", TrustedKey: base64.StdEncoding.EncodeToString(publicKey)},
+		{Name: "staging", Url: "https://staging.example.com/plugins.json"},
+	}
+
+	// The key is only trusted for archives from its repository
+	var signatureErr *SignatureError
+	if _, err := plugins.InstallFrom("staging", url, sum, signature, false); !errors.As(err, &signatureErr) {
+		t.Fatalf("InstallFrom expected SignatureError, got %v", err)
+	}
+
+	if _, err := plugins.InstallFrom("mirror", url, sum, signature, false); err != nil {
+		t.Fatalf("InstallFrom returned error: %v", err)
+	}
+	if plugin, _ := plugins.Get("test-plugin"); plugin.Repository != "mirror" {
+		t.Fatalf(`Expected plugin to be installed from "mirror", got "%v"`, plugin.Repository)
+	}
+}
@@ -0,0 +1,49 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"git.sr.ht/~avery/crankshaft/semver"
+)
+
+// ValidatePlugin checks the plugin in pluginDir the same way it's checked
+// when it's loaded, and returns every problem found instead of stopping at the
+// first one. It returns nil if the plugin is valid.
+//
+// The plugin also has to have a name and a semver version, since these are
+// needed to publish it to the registry.
+func ValidatePlugin(pluginDir string) []error {
+	absDir, err := filepath.Abs(pluginDir)
+	if err != nil {
+		return []error{err}
+	}
+	pluginId := filepath.Base(absDir)
+
+	config, err := decodePluginConfig(absDir)
+	if err != nil {
+		// Nothing else can be checked without the config
+		return []error{err}
+	}
+
+	var problems []error
+
+	var configErrs ConfigErrors
+	if err := config.validateConfig(); errors.As(err, &configErrs) {
+		problems = append(problems, configErrs...)
+	}
+
+	if config.Name == "" {
+		problems = append(problems, errors.New("Config was missing name"))
+	}
+	if _, err := semver.Parse(config.Version); err != nil {
+		problems = append(problems, fmt.Errorf("Invalid version: %v", err))
+	}
+
+	if _, _, _, err := buildPluginScript(pluginId, absDir, config); err != nil {
+		problems = append(problems, err)
+	}
+
+	return problems
+}
@@ -0,0 +1,63 @@
+package plugins
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreatePluginIsValid(t *testing.T) {
+	pluginDir := filepath.Join(t.TempDir(), "new-plugin")
+
+	if err := CreatePlugin(pluginDir, `My "New" Plugin`); err != nil {
+		t.Fatalf("CreatePlugin returned error: %v", err)
+	}
+
+	if problems := ValidatePlugin(pluginDir); len(problems) > 0 {
+		t.Fatalf("Expected scaffolded plugin to be valid, got: %v", problems)
+	}
+
+	config, err := NewPluginConfig(pluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != `My "New" Plugin` {
+		t.Fatalf("Expected scaffolded plugin name to be kept, got %q", config.Name)
+	}
+
+	if err := CreatePlugin(pluginDir, "Again"); err == nil {
+		t.Fatal("Expected CreatePlugin to refuse a directory that isn't empty")
+	}
+}
+
+func TestValidatePluginReportsEveryProblem(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "broken", map[string]string{
+		"plugin.toml": `
+name = "Broken"
+version = "not a version"
+
+[entrypoints.desktop]
+library = true
+
+[requires]
+crankshaft = ">= nope"
+
+[build]
+entry = "src/index.ts"
+`,
+		"src/index.ts": "export const load = () => {\n  const x = ;\n};\n",
+	})
+
+	problems := ValidatePlugin(filepath.Join(pluginsDir, "broken"))
+
+	expected := []string{"entrypoints.deck", "requires.crankshaft", "Invalid version", "src/index.ts:2:12"}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, problem := range problems {
+		if !strings.Contains(problem.Error(), expected[i]) {
+			t.Fatalf("Expected problem %d to mention %q, got: %v", i, expected[i], problem)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package plugins
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/watcher"
+)
+
+// Files that trigger a rebuild when they change, relative to the plugin
+// directory
+var watchedPluginFiles = []string{
+	"plugin.toml",
+	filepath.Join("dist", "index.js"),
+}
+
+// pluginWatcher rebuilds plugins when their files change.
+type pluginWatcher struct {
+	plugins   *Plugins
+	watcher   *watcher.Watcher
+	debouncer *watcher.Debouncer
+
+	mu sync.Mutex
+	// Plugin IDs by watched directory
+	dirs map[string]string
+}
+
+// Watch watches every plugin's directory and rebuilds a plugin whenever its
+// plugin.toml or dist/index.js changes, publishing an EventRebuilt event.
+// Plugins that are bundled from source are also rebuilt when any of the files
+// they were bundled from changes.
+// Bursts of changes within the debounce duration only trigger one rebuild.
+// Plugins that are added or removed later are watched or unwatched
+// automatically. Call the returned function to stop watching.
+func (p *Plugins) Watch(debounce time.Duration) (stop func(), err error) {
+	w, err := watcher.New()
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &pluginWatcher{
+		plugins:   p,
+		watcher:   w,
+		debouncer: watcher.NewDebouncer(debounce),
+		dirs:      make(map[string]string),
+	}
+
+	// Subscribe before watching the current plugins so none are missed
+	events, unsubscribe := p.Subscribe()
+
+	for _, plugin := range p.List() {
+		pw.add(plugin)
+	}
+
+	go pw.run(events)
+
+	return func() {
+		unsubscribe()
+		w.Close()
+		pw.debouncer.Stop()
+	}, nil
+}
+
+func (pw *pluginWatcher) run(events <-chan Event) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			switch event.Type {
+			case EventAdded, EventRebuilt:
+				// Rebuilt plugins are watched again in case their build
+				// entry point changed
+				if plugin, ok := pw.plugins.Get(event.PluginId); ok {
+					pw.add(plugin)
+				}
+			case EventRemoved:
+				pw.remove(event.PluginId)
+			}
+		case path, ok := <-pw.watcher.Events:
+			if !ok {
+				return
+			}
+			pw.handleChange(path)
+		}
+	}
+}
+
+// add watches the plugin directory, its dist directory and the directories of
+// the files it was bundled from.
+func (pw *pluginWatcher) add(plugin Plugin) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	dirs := []string{plugin.Dir, filepath.Join(plugin.Dir, "dist")}
+	for _, file := range plugin.SourceFiles {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+
+	for _, dir := range dirs {
+		if err := pw.watcher.Add(dir); err != nil {
+			// dist doesn't exist until the plugin is built, it's watched once
+			// it's created
+			if dir == plugin.Dir {
+				log.Printf("Error watching plugin \"%s\": %v\n", plugin.Id, err)
+			}
+			continue
+		}
+		pw.dirs[dir] = plugin.Id
+	}
+}
+
+func (pw *pluginWatcher) remove(pluginId string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	for dir, id := range pw.dirs {
+		if id == pluginId {
+			pw.watcher.Remove(dir)
+			delete(pw.dirs, dir)
+		}
+	}
+}
+
+func (pw *pluginWatcher) handleChange(path string) {
+	dir := filepath.Dir(path)
+
+	pw.mu.Lock()
+	pluginId, ok := pw.dirs[dir]
+	pw.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	plugin, ok := pw.plugins.Get(pluginId)
+	if !ok {
+		return
+	}
+
+	rel, err := filepath.Rel(plugin.Dir, path)
+	if err != nil {
+		return
+	}
+
+	if rel == "dist" {
+		// dist was created or replaced, watch it and rebuild in case it
+		// already contains the script
+		pw.add(plugin)
+		pw.scheduleRebuild(pluginId)
+		return
+	}
+
+	for _, file := range plugin.SourceFiles {
+		if path == file {
+			pw.scheduleRebuild(pluginId)
+			return
+		}
+	}
+
+	for _, file := range watchedPluginFiles {
+		if rel == file {
+			pw.scheduleRebuild(pluginId)
+			return
+		}
+	}
+}
+
+func (pw *pluginWatcher) scheduleRebuild(pluginId string) {
+	pw.debouncer.Call(pluginId, func() {
+		log.Printf("Plugin \"%s\" changed, rebuilding...\n", pluginId)
+		if err := pw.plugins.RebuildPlugin(pluginId); err != nil {
+			log.Printf("Error rebuilding plugin \"%s\": %v\n", pluginId, err)
+		}
+	})
+}
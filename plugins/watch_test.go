@@ -0,0 +1,115 @@
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+)
+
+func TestWatchRebuildsChangedPlugin(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "watched", map[string]string{
+		"plugin.toml":   testPluginToml,
+		"dist/index.js": "export const load = () => {};",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := plugins.Watch(50 * time.Millisecond)
+	if err != nil {
+		t.Skipf("Watching isn't supported: %v", err)
+	}
+	defer stop()
+
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	// Several writes in a row should only cause one rebuild
+	scriptPath := filepath.Join(pluginsDir, "watched", "dist", "index.js")
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(scriptPath, []byte("export const load = () => { console.log('changed'); };"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != EventRebuilt || event.PluginId != "watched" {
+			t.Fatalf("Expected rebuilt event for watched plugin, got %v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for plugin to be rebuilt")
+	}
+
+	select {
+	case event := <-events:
+		t.Fatalf("Expected writes to be debounced, got extra event %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	plugin, _ := plugins.Get("watched")
+	if plugin.Status != PluginStatusOk {
+		t.Fatalf("Expected rebuilt plugin to be ok, got %v: %v", plugin.Status, plugin.Error)
+	}
+}
+
+func TestWatchRebuildsOnNestedSourceChange(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writeTestPlugin(t, pluginsDir, "bundled", map[string]string{
+		"plugin.toml":         testPluginToml + "\n[build]\nentry = \"src/index.ts\"\n",
+		"src/lib/greeting.ts": "export const greeting = 'hello';",
+		"src/index.ts":        "import { greeting } from './lib/greeting';\nexport const load = () => greeting;",
+	})
+
+	crksftConfig, _, err := config.NewCrksftConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := NewPlugins(crksftConfig, pluginsDir, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := plugins.Watch(50 * time.Millisecond)
+	if err != nil {
+		t.Skipf("Watching isn't supported: %v", err)
+	}
+	defer stop()
+
+	events, unsubscribe := plugins.Subscribe()
+	defer unsubscribe()
+
+	// The changed file isn't next to the entry point, it's only found through
+	// the bundle's inputs
+	greetingPath := filepath.Join(pluginsDir, "bundled", "src", "lib", "greeting.ts")
+	if err := os.WriteFile(greetingPath, []byte("export const greeting = 'changed';"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != EventRebuilt || event.PluginId != "bundled" {
+			t.Fatalf("Expected rebuilt event for bundled plugin, got %v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for plugin to be rebuilt")
+	}
+
+	plugin, _ := plugins.Get("bundled")
+	if !strings.Contains(plugin.Script, "changed") {
+		t.Fatalf("Expected rebuilt script to contain the change, got:\n%s", plugin.Script)
+	}
+}
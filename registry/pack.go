@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/semver"
+)
+
+// PackResult is a plugin archive created by Pack.
+type PackResult struct {
+	// Path of the archive
+	ArchivePath string
+	// Registry index entry for the archive
+	Entry Plugin
+}
+
+// Pack validates the plugin in pluginDir and packs it into a .tar.gz archive
+// in outDir that can be installed and listed in the registry. The archive
+// URL in the returned registry entry is archiveBaseUrl followed by the archive
+// name, or just the archive name if archiveBaseUrl is empty.
+//
+// Archives are reproducible, packing the same files always gives the same
+// archive and checksum. Files are added in a fixed order with their
+// timestamps and owners cleared, and files and directories starting with a
+// dot, like .git, are left out.
+func Pack(pluginDir, outDir, archiveBaseUrl string) (*PackResult, error) {
+	absDir, err := filepath.Abs(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	pluginId := filepath.Base(absDir)
+
+	if problems := plugins.ValidatePlugin(absDir); len(problems) > 0 {
+		return nil, plugins.ConfigErrors(problems)
+	}
+
+	absOutDir, err := filepath.Abs(outDir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(absDir, absOutDir); err == nil && !strings.HasPrefix(rel, "..") {
+		// The archive would be packed into itself
+		return nil, errors.New("Output directory can't be inside the plugin directory")
+	}
+
+	config, err := plugins.NewPluginConfig(absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveName := fmt.Sprintf("%s-%s.tar.gz", pluginId, config.Version)
+	archivePath := filepath.Join(absOutDir, archiveName)
+
+	sum, err := writePluginArchive(absDir, pluginId, archivePath)
+	if err != nil {
+		os.Remove(archivePath)
+		return nil, err
+	}
+
+	archiveUrl := archiveName
+	if archiveBaseUrl != "" {
+		archiveUrl = strings.TrimSuffix(archiveBaseUrl, "/") + "/" + archiveName
+	}
+
+	entry := Plugin{
+		Id:      pluginId,
+		Name:    config.Name,
+		Version: config.Version,
+		Link:    config.Link,
+		Source:  config.Source,
+		Author: Author{
+			Name: config.Author.Name,
+			Link: config.Author.Link,
+		},
+		Store: Store{
+			Description: config.Store.Description,
+			Platforms: map[string]Platform{
+				"linux":   {config.Store.Platforms.Linux.Supported},
+				"windows": {config.Store.Platforms.Windows.Supported},
+				"darwin":  {config.Store.Platforms.Darwin.Supported},
+			},
+		},
+		Archive: archiveUrl,
+		Sha256:  sum,
+	}
+
+	if constraint, err := semver.ParseConstraint(config.Requires.Crankshaft); err == nil {
+		if min, ok := constraint.MinVersion(); ok {
+			entry.MinCrankshaftVersion = min.String()
+		}
+	}
+
+	return &PackResult{archivePath, entry}, nil
+}
+
+// writePluginArchive writes the plugin to a reproducible .tar.gz archive at
+// archivePath, with its files in a top-level directory named after the plugin
+// ID. The archive's sha256 checksum is returned.
+func writePluginArchive(pluginDir, pluginId, archivePath string) (string, error) {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("Error creating plugin archive: %v", err)
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	// The gzip header's timestamp and name are left empty
+	gzipWriter := gzip.NewWriter(io.MultiWriter(out, hash))
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	// WalkDir visits files in lexical order, so the archive's order is stable
+	err = filepath.WalkDir(pluginDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(pluginDir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel != "." && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    filepath.ToSlash(filepath.Join(pluginId, rel)),
+			ModTime: time.Unix(0, 0),
+		}
+
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 0755
+		case info.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Size = info.Size()
+			header.Mode = 0644
+			if info.Mode()&0111 != 0 {
+				header.Mode = 0755
+			}
+		default:
+			return fmt.Errorf(`Can't pack "%s", only regular files and directories can be packed`, rel)
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			file, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			if _, err := io.Copy(tarWriter, file); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error packing plugin: %v", err)
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
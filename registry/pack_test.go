@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/untar"
+)
+
+const testPackPluginToml = `
+name = "Packed Plugin"
+version = "1.2.0"
+
+[entrypoints.desktop]
+library = true
+
+[entrypoints.deck]
+library = true
+
+[store.platforms.linux]
+supported = true
+
+[requires]
+crankshaft = ">=0.3.0"
+`
+
+func writeTestPackPlugin(t *testing.T, pluginDir string) {
+	files := map[string]string{
+		"plugin.toml":   testPackPluginToml,
+		"dist/index.js": "export const load = () => {};",
+		".git/HEAD":     "ref: refs/heads/main",
+	}
+	for name, contents := range files {
+		filePath := filepath.Join(pluginDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	pluginDir := filepath.Join(t.TempDir(), "packed")
+	writeTestPackPlugin(t, pluginDir)
+
+	res, err := Pack(pluginDir, t.TempDir(), "https://example.com/archives/")
+	if err != nil {
+		t.Fatalf("Pack returned error: %v", err)
+	}
+
+	entry := res.Entry
+	if entry.Id != "packed" || entry.Version != "1.2.0" || entry.Name != "Packed Plugin" {
+		t.Fatalf("Unexpected registry entry: %+v", entry)
+	}
+	if entry.Archive != "https://example.com/archives/packed-1.2.0.tar.gz" {
+		t.Fatalf("Unexpected archive URL %q", entry.Archive)
+	}
+	if entry.MinCrankshaftVersion != "0.3.0" {
+		t.Fatalf("Expected minimum Crankshaft version from requirements, got %q", entry.MinCrankshaftVersion)
+	}
+	if !entry.Store.Platforms["linux"].Supported || entry.Store.Platforms["windows"].Supported {
+		t.Fatalf("Unexpected platforms: %+v", entry.Store.Platforms)
+	}
+
+	// The archive has the layout Install expects, without dotfiles
+	extractDir := t.TempDir()
+	if err := untar.Untar(res.ArchivePath, extractDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(extractDir, "packed", "dist", "index.js")); err != nil {
+		t.Fatalf("Expected plugin files in archive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(extractDir, "packed", ".git")); err == nil {
+		t.Fatal("Expected dotfiles to be left out of archive")
+	}
+}
+
+func TestPackIsReproducible(t *testing.T) {
+	pluginDir := filepath.Join(t.TempDir(), "packed")
+	writeTestPackPlugin(t, pluginDir)
+
+	first, err := Pack(pluginDir, t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing timestamps doesn't change the archive
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(pluginDir, "plugin.toml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Pack(pluginDir, t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Entry.Sha256 != second.Entry.Sha256 {
+		t.Fatalf("Expected packing twice to give the same checksum, got %s and %s", first.Entry.Sha256, second.Entry.Sha256)
+	}
+}
+
+func TestPackRejectsInvalidPlugin(t *testing.T) {
+	pluginDir := filepath.Join(t.TempDir(), "invalid")
+	if err := os.MkdirAll(pluginDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pluginDir, "plugin.toml"), []byte(`name = "Invalid"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Pack(pluginDir, t.TempDir(), ""); err == nil {
+		t.Fatal("Expected Pack to reject an invalid plugin")
+	}
+}
@@ -0,0 +1,128 @@
+// Package registry implements fetching the plugin registry indexes of the
+// configured repositories and checking installed plugins for updates.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Maximum amount of time to spend fetching the registry index
+const fetchTimeout = 30 * time.Second
+
+type Author struct {
+	Name string `json:"name"`
+	Link string `json:"link,omitempty"`
+}
+
+type Platform struct {
+	Supported bool `json:"supported"`
+}
+
+type Store struct {
+	Description string `json:"description,omitempty"`
+	// Supported platforms by GOOS
+	Platforms map[string]Platform `json:"platforms"`
+}
+
+// Plugin is a plugin listed in the registry index.
+type Plugin struct {
+	Id                   string `json:"id"`
+	Name                 string `json:"name"`
+	Version              string `json:"version"`
+	Link                 string `json:"link"`
+	Source               string `json:"source"`
+	MinCrankshaftVersion string `json:"minCrankshaftVersion,omitempty"`
+	Author               Author `json:"author"`
+	Store                Store  `json:"store"`
+	Archive              string `json:"archive"`
+	Sha256               string `json:"sha256"`
+	// Base64 encoded ed25519 signature of the archive, if it's signed
+	Signature string `json:"signature,omitempty"`
+	// Name of the repository the plugin is listed in, it's filled in when
+	// repositories' indexes are merged
+	Repository string `json:"repository,omitempty"`
+}
+
+// Index is the registry's list of plugins by plugin ID.
+type Index = map[string]Plugin
+
+// Client fetches the registry index. The last response is cached, and later
+// fetches send its ETag and Last-Modified headers so the index is only
+// downloaded again when it changes. It's safe for concurrent use.
+type Client struct {
+	url        string
+	httpClient http.Client
+
+	mu           sync.Mutex
+	index        Index
+	etag         string
+	lastModified string
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		url:        url,
+		httpClient: http.Client{Timeout: fetchTimeout},
+	}
+}
+
+// Url returns the URL of the registry index.
+func (c *Client) Url() string {
+	return c.url
+}
+
+// Fetch returns the registry index, using the cached index if it hasn't
+// changed since the last fetch.
+func (c *Client) Fetch() (Index, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`Error creating request for registry "%s": %v`, c.url, err)
+	}
+	if c.index != nil {
+		if c.etag != "" {
+			req.Header.Set("If-None-Match", c.etag)
+		}
+		if c.lastModified != "" {
+			req.Header.Set("If-Modified-Since", c.lastModified)
+		}
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf(`Error fetching registry "%s": %v`, c.url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && c.index != nil {
+		return c.index, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`Fetching registry "%s" returned status %d`, c.url, res.StatusCode)
+	}
+
+	var index Index
+	if err := json.NewDecoder(res.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf(`Error decoding registry "%s": %v`, c.url, err)
+	}
+
+	// The index is keyed by ID, fill in any entries that leave it out
+	for id, plugin := range index {
+		if plugin.Id == "" {
+			plugin.Id = id
+			index[id] = plugin
+		}
+	}
+
+	c.index = index
+	c.etag = res.Header.Get("ETag")
+	c.lastModified = res.Header.Get("Last-Modified")
+
+	return index, nil
+}
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testIndex = `{
+  "test-plugin": {
+    "id": "test-plugin",
+    "name": "Test Plugin",
+    "version": "1.1.0",
+    "archive": "https://example.com/test-plugin.tar.gz",
+    "sha256": "abc",
+    "store": {"platforms": {"linux": {"supported": true}}}
+  }
+}`
+
+func TestFetchUsesETag(t *testing.T) {
+	requests := 0
+	notModified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	for i := 0; i < 3; i++ {
+		index, err := client.Fetch()
+		if err != nil {
+			t.Fatalf("Fetch returned error: %v", err)
+		}
+		if index["test-plugin"].Version != "1.1.0" {
+			t.Fatalf(`Expected test-plugin version "1.1.0", got "%v"`, index["test-plugin"].Version)
+		}
+	}
+
+	if requests != 3 || notModified != 2 {
+		t.Fatalf("Expected 3 requests with 2 not modified, got %v requests with %v not modified", requests, notModified)
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL).Fetch(); err == nil {
+		t.Fatal("Fetch expected error, got nil")
+	}
+}
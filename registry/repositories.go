@@ -0,0 +1,246 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+// Repository is a plugin registry configured in the Crankshaft config, along
+// with the result of the last fetch of its index.
+type Repository struct {
+	Name     string `json:"name"`
+	Url      string `json:"url"`
+	Priority int    `json:"priority"`
+	// Trusted is true if the repository has a key that's trusted to sign
+	// archives installed from it
+	Trusted bool `json:"trusted"`
+	// LastFetched is when the index was last fetched successfully, zero if it
+	// hasn't been yet
+	LastFetched time.Time `json:"lastFetched"`
+	// Error is why the last fetch failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+type repository struct {
+	Repository
+	client *Client
+	index  Index
+}
+
+// Repositories fetches the indexes of every configured repository and merges
+// them into one. When more than one repository lists a plugin:
+//
+//   - An installed plugin only comes from the repository it was installed
+//     from, so another repository can't replace it with its own version. It's
+//     left out if that repository doesn't list it anymore.
+//   - Otherwise it comes from the repository with the highest priority, and
+//     repositories with the same priority are used in the order they're
+//     configured.
+//
+// Installed plugins from a repository that's no longer configured are treated
+// like plugins that weren't installed from a repository. The last index
+// fetched from each repository is cached. It's safe for concurrent use.
+type Repositories struct {
+	plugins *plugins.Plugins
+
+	mu sync.RWMutex
+	// Repositories in priority order
+	repos []*repository
+}
+
+// NewRepositories creates the repositories from the Crankshaft config. Every
+// repository needs a unique name and an index URL.
+func NewRepositories(repoConfigs []config.CrksftConfigRepository, plugins *plugins.Plugins) (*Repositories, error) {
+	repos, err := newRepos(repoConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repositories{plugins: plugins, repos: repos}, nil
+}
+
+// Reconfigure replaces the repositories with the ones in repoConfigs, when the
+// Crankshaft config changes. Repositories that keep their name and URL keep
+// the index fetched last. If repoConfigs is invalid, the repositories are left
+// as they are.
+func (r *Repositories) Reconfigure(repoConfigs []config.CrksftConfigRepository) error {
+	repos, err := newRepos(repoConfigs)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, repo := range repos {
+		for _, current := range r.repos {
+			if current.Name == repo.Name && current.Url == repo.Url {
+				repo.index = current.index
+				repo.LastFetched = current.LastFetched
+				repo.Error = current.Error
+			}
+		}
+	}
+	r.repos = repos
+
+	return nil
+}
+
+// newRepos validates the repository configs and returns the repositories in
+// priority order.
+func newRepos(repoConfigs []config.CrksftConfigRepository) ([]*repository, error) {
+	repos := make([]*repository, 0, len(repoConfigs))
+	names := make(map[string]bool, len(repoConfigs))
+	for _, repoConfig := range repoConfigs {
+		if repoConfig.Name == "" {
+			return nil, fmt.Errorf(`Repository "%s" needs a name`, repoConfig.Url)
+		}
+		if repoConfig.Url == "" {
+			return nil, fmt.Errorf(`Repository "%s" needs a URL`, repoConfig.Name)
+		}
+		if names[repoConfig.Name] {
+			return nil, fmt.Errorf(`More than one repository is named "%s"`, repoConfig.Name)
+		}
+		names[repoConfig.Name] = true
+
+		repos = append(repos, &repository{
+			Repository: Repository{
+				Name:     repoConfig.Name,
+				Url:      repoConfig.Url,
+				Priority: repoConfig.Priority,
+				Trusted:  repoConfig.TrustedKey != "",
+			},
+			client: NewClient(repoConfig.Url),
+		})
+	}
+
+	// Stable, so repositories with the same priority keep their config order
+	sort.SliceStable(repos, func(i, j int) bool {
+		return repos[i].Priority > repos[j].Priority
+	})
+
+	return repos, nil
+}
+
+// List returns the repositories in priority order.
+func (r *Repositories) List() []Repository {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Repository, 0, len(r.repos))
+	for _, repo := range r.repos {
+		list = append(list, repo.Repository)
+	}
+	return list
+}
+
+// Fetch fetches every repository's index and returns the merged index.
+// Repositories that can't be fetched keep the index from their last
+// successful fetch, and the error is recorded in their status. An error is
+// only returned if none of the repositories could be fetched.
+func (r *Repositories) Fetch() (Index, error) {
+	r.mu.RLock()
+	repos := r.repos
+	r.mu.RUnlock()
+
+	indexes := make([]Index, len(repos))
+	errs := make([]error, len(repos))
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo *repository) {
+			defer wg.Done()
+			indexes[i], errs[i] = repo.client.Fetch()
+		}(i, repo)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	fetched := 0
+	errMessages := []string{}
+	for i, repo := range repos {
+		if errs[i] != nil {
+			log.Println(errs[i])
+			repo.Error = errs[i].Error()
+			errMessages = append(errMessages, errs[i].Error())
+			continue
+		}
+		repo.index = indexes[i]
+		repo.LastFetched = time.Now()
+		repo.Error = ""
+		fetched++
+	}
+	r.mu.Unlock()
+
+	if len(repos) == 0 {
+		return nil, errors.New("No plugin repositories are configured")
+	}
+	if fetched == 0 {
+		return nil, fmt.Errorf("Error fetching plugin repositories: %s", strings.Join(errMessages, "; "))
+	}
+
+	index, _ := r.Index()
+	return index, nil
+}
+
+// Index returns the merged index of the indexes fetched last, and when the
+// oldest of them was fetched. The time is zero if a repository hasn't been
+// fetched yet.
+func (r *Repositories) Index() (Index, time.Time) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var oldest time.Time
+	indexes := make([]repoIndex, 0, len(r.repos))
+	for i, repo := range r.repos {
+		if i == 0 || repo.LastFetched.Before(oldest) {
+			oldest = repo.LastFetched
+		}
+		indexes = append(indexes, repoIndex{repo.Name, repo.index})
+	}
+
+	return mergeIndexes(indexes, r.plugins.List()), oldest
+}
+
+// repoIndex is a repository's index, for merging.
+type repoIndex struct {
+	name  string
+	index Index
+}
+
+// mergeIndexes merges the repositories' indexes, which are in priority order,
+// following the rules described on Repositories. Each plugin in the merged
+// index has its repository set.
+func mergeIndexes(indexes []repoIndex, installed plugins.PluginMap) Index {
+	configured := make(map[string]bool, len(indexes))
+	for _, repo := range indexes {
+		configured[repo.name] = true
+	}
+
+	merged := Index{}
+	for _, repo := range indexes {
+		for id, plugin := range repo.index {
+			if _, ok := merged[id]; ok {
+				continue
+			}
+
+			// Installed plugins are pinned to the repository they came from
+			if pinned := installed[id].Repository; configured[pinned] && pinned != repo.name {
+				continue
+			}
+
+			plugin.Repository = repo.name
+			merged[id] = plugin
+		}
+	}
+
+	return merged
+}
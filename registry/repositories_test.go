@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/config"
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+func TestNewRepositoriesPriorityOrder(t *testing.T) {
+	repos, err := NewRepositories([]config.CrksftConfigRepository{
+		{Name: "public", Url: "https://example.com/plugins.json"},
+		{Name: "mirror", Url: "https://mirror.example.com/plugins.json", Priority: 10},
+		{Name: "staging", Url: "https://staging.example.com/plugins.json"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, repo := range repos.List() {
+		names = append(names, repo.Name)
+	}
+	if len(names) != 3 || names[0] != "mirror" || names[1] != "public" || names[2] != "staging" {
+		t.Fatalf("Expected repositories in priority then config order, got %v", names)
+	}
+}
+
+func TestRepositoriesReconfigure(t *testing.T) {
+	repos, err := NewRepositories([]config.CrksftConfigRepository{
+		{Name: "public", Url: "https://example.com/plugins.json"},
+		{Name: "mirror", Url: "https://mirror.example.com/plugins.json"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Now()
+	for _, repo := range repos.repos {
+		repo.LastFetched = fetched
+	}
+
+	err = repos.Reconfigure([]config.CrksftConfigRepository{
+		{Name: "public", Url: "https://example.com/plugins.json"},
+		{Name: "mirror", Url: "https://new-mirror.example.com/plugins.json", Priority: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the repository that kept its URL keeps its last fetch
+	list := repos.List()
+	if len(list) != 2 || list[0].Name != "mirror" || list[1].Name != "public" {
+		t.Fatalf("Expected the reconfigured repositories in priority order, got %v", list)
+	}
+	if !list[0].LastFetched.IsZero() || !list[1].LastFetched.Equal(fetched) {
+		t.Fatalf("Expected only public to keep its last fetch, got %v", list)
+	}
+
+	// Invalid configs leave the repositories as they are
+	if err := repos.Reconfigure([]config.CrksftConfigRepository{{Name: "public"}}); err == nil {
+		t.Fatal("Reconfigure expected error, got nil")
+	}
+	if len(repos.List()) != 2 {
+		t.Fatalf("Expected repositories to be kept after an invalid config, got %v", repos.List())
+	}
+}
+
+func TestNewRepositoriesRejectsInvalidConfig(t *testing.T) {
+	tests := map[string][]config.CrksftConfigRepository{
+		"missing name": {{Url: "https://example.com/plugins.json"}},
+		"missing URL":  {{Name: "public"}},
+		"duplicate name": {
+			{Name: "public", Url: "https://example.com/plugins.json"},
+			{Name: "public", Url: "https://mirror.example.com/plugins.json"},
+		},
+	}
+
+	for name, repoConfigs := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewRepositories(repoConfigs, nil); err == nil {
+				t.Fatal("NewRepositories expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestMergeIndexes(t *testing.T) {
+	indexes := []repoIndex{
+		{"mirror", Index{
+			"shared":      {Id: "shared", Version: "2.0.0"},
+			"pinned":      {Id: "pinned", Version: "2.0.0"},
+			"mirror-only": {Id: "mirror-only", Version: "1.0.0"},
+		}},
+		{"public", Index{
+			"shared":  {Id: "shared", Version: "1.0.0"},
+			"pinned":  {Id: "pinned", Version: "1.0.0"},
+			"removed": {Id: "removed", Version: "1.0.0"},
+			"orphan":  {Id: "orphan", Version: "1.0.0"},
+		}},
+	}
+
+	installed := plugins.PluginMap{
+		"pinned": {Id: "pinned", Repository: "public"},
+		// The repository the plugin was installed from doesn't list it anymore
+		"mirror-only": {Id: "mirror-only", Repository: "public"},
+		// The repository the plugin was installed from isn't configured anymore
+		"orphan": {Id: "orphan", Repository: "old"},
+	}
+
+	merged := mergeIndexes(indexes, installed)
+
+	expected := map[string]string{
+		"shared":  "mirror",
+		"pinned":  "public",
+		"removed": "public",
+		"orphan":  "public",
+	}
+	if len(merged) != len(expected) {
+		t.Fatalf("Expected %d plugins, got %v", len(expected), merged)
+	}
+	for id, repository := range expected {
+		if merged[id].Repository != repository {
+			t.Fatalf(`Expected %s to come from "%s", got "%s"`, id, repository, merged[id].Repository)
+		}
+	}
+	if merged["pinned"].Version != "1.0.0" {
+		t.Fatalf(`Expected pinned plugin version "1.0.0", got "%s"`, merged["pinned"].Version)
+	}
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/build"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/semver"
+)
+
+// Update is a newer version of an installed plugin that's available in the
+// registry.
+type Update struct {
+	PluginId         string `json:"pluginId"`
+	InstalledVersion string `json:"installedVersion"`
+	Version          string `json:"version"`
+	// Repository the update comes from
+	Repository string `json:"repository"`
+	Archive    string `json:"archive"`
+	Sha256     string `json:"sha256"`
+	Signature  string `json:"signature,omitempty"`
+	// MinCrankshaftVersion is the Crankshaft version the update requires, if
+	// any. Compatible is false if this version of Crankshaft is older.
+	MinCrankshaftVersion string `json:"minCrankshaftVersion,omitempty"`
+	Compatible           bool   `json:"compatible"`
+}
+
+// UpdateChecker periodically checks the repositories for updates to the
+// installed plugins. It's safe for concurrent use.
+type UpdateChecker struct {
+	repositories *Repositories
+	plugins      *plugins.Plugins
+	interval     time.Duration
+	// Called with the updates that weren't available in the previous check
+	onNewUpdates func(updates []Update)
+
+	mu          sync.RWMutex
+	index       Index
+	lastChecked time.Time
+	// Updates found by previous checks, so onNewUpdates is only called once
+	// per update
+	seen map[Update]bool
+}
+
+// NewUpdateChecker creates an update checker. onNewUpdates is called whenever
+// a check finds updates that weren't found by the previous check.
+func NewUpdateChecker(repositories *Repositories, plugins *plugins.Plugins, interval time.Duration, onNewUpdates func(updates []Update)) *UpdateChecker {
+	return &UpdateChecker{
+		repositories: repositories,
+		plugins:      plugins,
+		interval:     interval,
+		onNewUpdates: onNewUpdates,
+		seen:         make(map[Update]bool),
+	}
+}
+
+// Run checks for updates immediately and then every interval, it never
+// returns. It returns immediately if the interval isn't positive, which
+// disables background checks.
+func (c *UpdateChecker) Run() {
+	if c.interval <= 0 {
+		log.Println("Background plugin update checks are disabled")
+		return
+	}
+
+	for {
+		if _, err := c.Check(); err != nil {
+			log.Printf("Error checking for plugin updates: %v\n", err)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// Check fetches the repositories and returns the available updates.
+func (c *UpdateChecker) Check() ([]Update, error) {
+	index, err := c.repositories.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	updates := findUpdates(index, c.plugins.List())
+
+	c.mu.Lock()
+	c.index = index
+	c.lastChecked = time.Now()
+	newUpdates := []Update{}
+	for _, update := range updates {
+		if !c.seen[update] {
+			c.seen[update] = true
+			newUpdates = append(newUpdates, update)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(newUpdates) > 0 {
+		log.Printf("Found %d new plugin updates\n", len(newUpdates))
+		if c.onNewUpdates != nil {
+			c.onNewUpdates(newUpdates)
+		}
+	}
+
+	return updates, nil
+}
+
+// Updates returns the updates available in the registry index fetched by the
+// last check, and when it ran. The installed versions are compared again, so
+// plugins updated since the last check aren't included. The time is zero if
+// no check has finished yet.
+func (c *UpdateChecker) Updates() ([]Update, time.Time) {
+	c.mu.RLock()
+	index, lastChecked := c.index, c.lastChecked
+	c.mu.RUnlock()
+
+	if index == nil {
+		return []Update{}, lastChecked
+	}
+
+	return findUpdates(index, c.plugins.List()), lastChecked
+}
+
+// findUpdates compares the installed plugins' versions with the registry,
+// returning an update for every plugin with a newer version that supports
+// this platform.
+func findUpdates(index Index, installed plugins.PluginMap) []Update {
+	crksftVersion := semver.MustParse(build.VERSION)
+
+	updates := []Update{}
+	for id, plugin := range installed {
+		available, ok := index[id]
+		if !ok || !available.Store.Platforms[runtime.GOOS].Supported {
+			continue
+		}
+
+		installedVersion, err := semver.Parse(plugin.Config.Version)
+		if err != nil {
+			log.Printf("Skipping update check for plugin \"%s\": %v\n", id, err)
+			continue
+		}
+		availableVersion, err := semver.Parse(available.Version)
+		if err != nil {
+			log.Printf("Skipping update check for plugin \"%s\", registry has %v\n", id, err)
+			continue
+		}
+		if availableVersion.Compare(installedVersion) <= 0 {
+			continue
+		}
+
+		compatible := true
+		if available.MinCrankshaftVersion != "" {
+			minVersion, err := semver.Parse(available.MinCrankshaftVersion)
+			compatible = err == nil && crksftVersion.Compare(minVersion) >= 0
+		}
+
+		updates = append(updates, Update{
+			PluginId:             id,
+			InstalledVersion:     plugin.Config.Version,
+			Version:              available.Version,
+			Repository:           available.Repository,
+			Archive:              available.Archive,
+			Sha256:               available.Sha256,
+			Signature:            available.Signature,
+			MinCrankshaftVersion: available.MinCrankshaftVersion,
+			Compatible:           compatible,
+		})
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].PluginId < updates[j].PluginId
+	})
+
+	return updates
+}
@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"runtime"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+func TestFindUpdates(t *testing.T) {
+	supported := Store{Platforms: map[string]Platform{runtime.GOOS: {Supported: true}}}
+
+	index := Index{
+		"outdated":      {Id: "outdated", Version: "1.10.0", Store: supported},
+		"current":       {Id: "current", Version: "1.0.0", Store: supported},
+		"unsupported":   {Id: "unsupported", Version: "2.0.0"},
+		"needs-newer":   {Id: "needs-newer", Version: "2.0.0", MinCrankshaftVersion: "99.0.0", Store: supported},
+		"not-installed": {Id: "not-installed", Version: "1.0.0", Store: supported},
+	}
+
+	installed := plugins.PluginMap{}
+	for id, version := range map[string]string{
+		"outdated":    "1.9.0",
+		"current":     "1.0.0",
+		"unsupported": "1.0.0",
+		"needs-newer": "1.0.0",
+		"local-only":  "1.0.0",
+	} {
+		plugin := plugins.Plugin{Id: id}
+		plugin.Config.Version = version
+		installed[id] = plugin
+	}
+
+	updates := findUpdates(index, installed)
+
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %v", updates)
+	}
+
+	if updates[0].PluginId != "needs-newer" || updates[0].Compatible {
+		t.Fatalf("Expected incompatible update for needs-newer, got %v", updates[0])
+	}
+	if updates[1].PluginId != "outdated" || updates[1].Version != "1.10.0" || !updates[1].Compatible {
+		t.Fatalf("Expected compatible update to 1.10.0 for outdated, got %v", updates[1])
+	}
+}
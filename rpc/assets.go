@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+// Types for assets plugins commonly ship that Go doesn't know about on every
+// platform
+var pluginAssetTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".mp3":   "audio/mpeg",
+	".ogg":   "audio/ogg",
+	".wav":   "audio/wav",
+	".webm":  "video/webm",
+	".mp4":   "video/mp4",
+}
+
+// handlePluginAssets serves files from the assets directory of each plugin at
+// /plugins/<id>/assets/<path>.
+func handlePluginAssets(p *plugins.Plugins) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The path is /plugins/<id>/assets/<path>
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/plugins/"), "/", 3)
+		if len(parts) != 3 || parts[1] != "assets" {
+			http.NotFound(w, r)
+			return
+		}
+		pluginId, assetPath := parts[0], parts[2]
+
+		filePath, err := p.AssetPath(pluginId, assetPath)
+		if errors.Is(err, plugins.ErrAssetNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error serving asset \"%s\" of plugin \"%s\": %v\n", assetPath, pluginId, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		contentType, ok := pluginAssetTypes[strings.ToLower(filepath.Ext(filePath))]
+		if !ok {
+			contentType = mime.TypeByExtension(filepath.Ext(filePath))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Assets change when plugins are updated, so they're revalidated every
+		// time they're used. ServeContent responds with 304 Not Modified if they
+		// haven't changed.
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+		http.ServeContent(w, r, filePath, info.ModTime(), file)
+	})
+}
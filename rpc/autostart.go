@@ -3,6 +3,7 @@ package rpc
 import (
 	"net/http"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/autostart"
 )
 
@@ -41,6 +42,10 @@ type InstallServiceArgs struct{}
 type InstallServiceReply struct{}
 
 func (service *AutostartService) InstallService(r *http.Request, req *InstallServiceArgs, res *InstallServiceReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	return autostart.InstallService(service.dataDir)
 }
 
@@ -49,5 +54,9 @@ type DisableServiceArgs struct{}
 type DisableServiceReply struct{}
 
 func (service *AutostartService) DisableService(r *http.Request, req *DisableServiceArgs, res *DisableServiceReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	return autostart.DisableService()
 }
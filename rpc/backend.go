@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/backend"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/safemode"
+)
+
+type BackendService struct {
+	backends *backend.Supervisor
+}
+
+func NewBackendService(backends *backend.Supervisor) *BackendService {
+	return &BackendService{backends}
+}
+
+type CallArgs struct {
+	Id     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type CallReply struct {
+	Result json.RawMessage `json:"result"`
+}
+
+func (service *BackendService) Call(r *http.Request, req *CallArgs, res *CallReply) error {
+	if err := auth.RequireCoreOrPlugin(r, req.Id); err != nil {
+		return err
+	}
+
+	result, err := service.backends.Call(req.Id, req.Method, req.Params)
+	if err != nil {
+		return err
+	}
+
+	res.Result = result
+
+	return nil
+}
+
+// superviseBackends runs the backends of enabled plugins, starting them when
+// plugins are enabled, restarting them when plugins are added or rebuilt, and
+// stopping them when they're disabled or removed. Backends aren't started in
+// safe mode.
+func superviseBackends(p *plugins.Plugins, backends *backend.Supervisor, safeMode *safemode.Tracker) {
+	events, _ := p.Subscribe()
+
+	for _, plugin := range p.List() {
+		updateBackend(plugin, backends, safeMode, false)
+	}
+
+	for event := range events {
+		switch event.Type {
+		case plugins.EventRemoved:
+			backends.Stop(event.PluginId)
+		case plugins.EventSettingsChanged:
+			// Settings are read by the backend, it doesn't need restarting
+		default:
+			plugin, ok := p.Get(event.PluginId)
+			if !ok {
+				backends.Stop(event.PluginId)
+				continue
+			}
+			// Only a new build changes what the backend runs, enabling the
+			// plugin in another UI mode leaves it running
+			restart := event.Type == plugins.EventAdded || event.Type == plugins.EventRebuilt
+			updateBackend(plugin, backends, safeMode, restart)
+		}
+	}
+}
+
+// updateBackend starts the plugin's backend if it should be running and isn't,
+// or restarts it if restart is set, and stops it otherwise.
+func updateBackend(plugin plugins.Plugin, backends *backend.Supervisor, safeMode *safemode.Tracker, restart bool) {
+	command := plugin.Config.Backend.Command
+	if command == "" || !plugin.Enabled || plugin.Status != plugins.PluginStatusOk || safeMode.Active() {
+		backends.Stop(plugin.Id)
+		return
+	}
+
+	if !restart && backends.IsRunning(plugin.Id) {
+		return
+	}
+
+	log.Printf("Starting backend of plugin \"%s\"\n", plugin.Id)
+	if err := backends.Start(plugin.Id, backend.Backend{
+		Dir:     plugin.Dir,
+		Command: command,
+		Args:    plugin.Config.Backend.Args,
+	}); err != nil {
+		log.Printf("Error starting backend of plugin \"%s\": %v\n", plugin.Id, err)
+	}
+}
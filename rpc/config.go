@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/config"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/registry"
+	"git.sr.ht/~avery/crankshaft/ws"
+)
+
+// How long to wait after config.toml stops changing before reloading it
+const configReloadDebounce = 300 * time.Millisecond
+
+type ConfigService struct {
+	plugins *plugins.Plugins
+	hub     *ws.Hub
+}
+
+func NewConfigService(plugins *plugins.Plugins, hub *ws.Hub) *ConfigService {
+	return &ConfigService{plugins, hub}
+}
+
+type GetConfigArgs struct{}
+
+type GetConfigReply struct {
+	Settings []config.Setting `json:"settings"`
+}
+
+// Get returns Crankshaft's settings with their current values.
+func (service *ConfigService) Get(r *http.Request, req *GetConfigArgs, res *GetConfigReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	res.Settings = service.plugins.CrankshaftSettings()
+
+	return nil
+}
+
+type SetConfigArgs struct {
+	// Settings to change by key, settings that aren't included keep their
+	// values
+	Settings map[string]interface{} `json:"settings"`
+}
+
+type SetConfigReply struct {
+	Settings []config.Setting `json:"settings"`
+}
+
+// Set changes some of Crankshaft's settings. Nothing is changed if any value
+// is invalid.
+func (service *ConfigService) Set(r *http.Request, req *SetConfigArgs, res *SetConfigReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	changed, err := service.plugins.SetCrankshaftSettings(req.Settings)
+	if err != nil {
+		return err
+	}
+
+	res.Settings = broadcastConfigChanged(service.hub, service.plugins, changed)
+
+	return nil
+}
+
+type configChanged struct {
+	// Keys of the settings that changed
+	Changed  []string         `json:"changed"`
+	Settings []config.Setting `json:"settings"`
+}
+
+// broadcastConfigChanged lets the injected scripts know which settings
+// changed along with the new settings, and returns the new settings. Nothing
+// is broadcast if no settings changed.
+func broadcastConfigChanged(hub *ws.Hub, p *plugins.Plugins, changed []string) []config.Setting {
+	settings := p.CrankshaftSettings()
+	if len(changed) > 0 {
+		broadcastIPC(hub, "csConfigChanged", configChanged{changed, settings})
+	}
+	return settings
+}
+
+// watchConfig applies edits made to config.toml outside of Crankshaft.
+// Changes to which plugins are enabled are published as plugin events, and the
+// repositories are rebuilt from the edited config.
+func watchConfig(crksftConfig *config.CrksftConfig, p *plugins.Plugins, repositories *registry.Repositories, hub *ws.Hub) {
+	_, err := p.WatchConfig(configReloadDebounce, func(changed []string) {
+		if len(changed) > 0 {
+			log.Printf("Crankshaft config changed: %v\n", changed)
+		}
+		if err := repositories.Reconfigure(crksftConfig.GetRepositories()); err != nil {
+			log.Printf("Invalid repositories in Crankshaft config, keeping the current repositories: %v\n", err)
+		}
+		broadcastConfigChanged(hub, p, changed)
+	})
+	if err != nil {
+		log.Printf("Error watching Crankshaft config, edits will apply after restarting: %v\n", err)
+	}
+}
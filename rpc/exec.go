@@ -9,6 +9,7 @@ import (
 	"strings"
 	"syscall"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/executil"
 )
 
@@ -19,6 +20,8 @@ type CmdInfo struct {
 	cmd         *exec.Cmd
 	stdoutBytes bytes.Buffer
 	stderrBytes bytes.Buffer
+	// ID of the plugin that started the command, empty for core scripts
+	pluginId string
 }
 
 type ExecService struct {
@@ -43,6 +46,10 @@ type RunReply struct {
 }
 
 func (service *ExecService) Run(r *http.Request, req *RunArgs, res *RunReply) error {
+	if err := auth.CheckExec(r, req.Command); err != nil {
+		return err
+	}
+
 	cmd := executil.Command(req.Command, req.Args...)
 
 	var stdoutBytes, stderrBytes bytes.Buffer
@@ -74,7 +81,14 @@ type StartReply struct {
 }
 
 func (service *ExecService) Start(r *http.Request, req *StartArgs, res *StartReply) error {
+	if err := auth.CheckExec(r, req.Command); err != nil {
+		return err
+	}
+
 	var cmdInfo CmdInfo
+	if identity, ok := auth.IdentityFromRequest(r); ok {
+		cmdInfo.pluginId = identity.PluginId
+	}
 
 	cmdInfo.cmd = executil.Command(req.Command, req.Args...)
 	cmdInfo.cmd.Stdout = &cmdInfo.stdoutBytes
@@ -110,6 +124,11 @@ func (service *ExecService) Stop(r *http.Request, req *StopArgs, res *StopReply)
 		return fmt.Errorf(`Process with PID "%d" not found`, req.Pid)
 	}
 
+	// Plugins can only stop processes they started
+	if identity, ok := auth.IdentityFromRequest(r); !ok || !(identity.IsCore() || identity.PluginId == cmdInfo.pluginId) {
+		return fmt.Errorf(`Process with PID "%d" not found`, req.Pid)
+	}
+
 	process := cmdInfo.cmd.Process
 
 	// Stop the process
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/pathutil"
 	"git.sr.ht/~avery/crankshaft/untar"
 )
@@ -32,6 +33,9 @@ type ListDirReply struct {
 
 func (service *FSService) ListDir(r *http.Request, req *ListDirArgs, res *ListDirReply) error {
 	path := pathutil.SubstituteHomeAndXdg(req.Path)
+	if err := auth.CheckPath(r, path); err != nil {
+		return err
+	}
 
 	c, err := os.ReadDir(path)
 	if err != nil {
@@ -59,6 +63,9 @@ type MakeDirReply struct{}
 
 func (service *FSService) MkDir(r *http.Request, req *MakeDirArgs, res *MakeDirReply) error {
 	path := pathutil.SubstituteHomeAndXdg(req.Path)
+	if err := auth.CheckPath(r, path); err != nil {
+		return err
+	}
 	if req.Parents {
 		// TODO: allow specifying mode
 		return os.MkdirAll(path, 0755)
@@ -76,6 +83,9 @@ type ReadFileReply struct {
 
 func (service *FSService) ReadFile(r *http.Request, req *ReadFileArgs, res *ReadFileReply) error {
 	path := pathutil.SubstituteHomeAndXdg(req.Path)
+	if err := auth.CheckPath(r, path); err != nil {
+		return err
+	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -97,6 +107,9 @@ type RemoveFileReply struct {
 
 func (service *FSService) RemoveFile(r *http.Request, req *RemoveFileArgs, res *RemoveFileReply) error {
 	path := pathutil.SubstituteHomeAndXdg(req.Path)
+	if err := auth.CheckPath(r, path); err != nil {
+		return err
+	}
 
 	err := os.Remove(path)
 	if err != nil {
@@ -117,6 +130,12 @@ type UntarReply struct{}
 func (service *FSService) Untar(r *http.Request, req *UntarArgs, res *UntarReply) error {
 	tarPath := pathutil.SubstituteHomeAndXdg(req.TarPath)
 	destPath := pathutil.SubstituteHomeAndXdg(req.DestPath)
+	if err := auth.CheckPath(r, tarPath); err != nil {
+		return err
+	}
+	if err := auth.CheckPath(r, destPath); err != nil {
+		return err
+	}
 
 	err := untar.Untar(tarPath, destPath)
 	if err != nil {
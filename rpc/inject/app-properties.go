@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"text/template"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/build"
 	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/tags"
@@ -20,12 +21,17 @@ type InjectAppPropertiesArgs struct {
 
 type InjectAppPropertiesReply struct{}
 
-var appPropertiesScriptTemplate = template.Must(template.New("app-properties").Parse(`
-	window.appPropertiesApp = JSON.parse(` + "`{{ .App }}`" + `);
-	{{ .Script }}
-`))
+// The app is set on the first line, the source map for the script is offset by
+// one line to match, see coreSourceMapOffset
+var appPropertiesScriptTemplate = template.Must(template.New("app-properties").Parse(
+	"window.appPropertiesApp = JSON.parse(`{{ .App }}`);\n{{ .Script }}",
+))
 
 func (service *InjectService) InjectAppProperties(r *http.Request, req *InjectAppPropertiesArgs, res *InjectAppPropertiesReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	log.Println("Injecting app properties scripts...")
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
@@ -47,7 +53,7 @@ func (service *InjectService) InjectAppProperties(r *http.Request, req *InjectAp
 		}
 	}
 
-	if err = steamClient.RunScriptInAppProperties(sharedScript, req.Title); err != nil {
+	if err = steamClient.RunScriptInAppProperties(service.sharedEvalScript(), req.Title); err != nil {
 		log.Println(err)
 		return fmt.Errorf("Error injecting shared script: %v", err)
 	}
@@ -68,6 +74,7 @@ func (service *InjectService) InjectAppProperties(r *http.Request, req *InjectAp
 		appPropertiesEvalScript, err = build.BuildEvalScript(
 			service.serverPort,
 			steamClient.UiMode,
+			"app-properties",
 			appPropertiesScript,
 			service.steamPath,
 			service.authToken,
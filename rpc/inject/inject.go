@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/build"
 	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/safemode"
 	"git.sr.ht/~avery/crankshaft/tags"
 )
 
@@ -19,10 +22,26 @@ type InjectService struct {
 	steamPath  string
 	authToken  string
 	pluginsDir string
+	tokens     *auth.Tokens
+	safeMode   *safemode.Tracker
+
+	injectedMu sync.Mutex
+	// Targets each plugin is injected into, by plugin ID
+	injected map[string]map[injectedTarget]bool
 }
 
-func NewInjectService(debugPort, serverPort string, plugins *plugins.Plugins, steamPath string, authToken string, pluginsDir string) *InjectService {
-	return &InjectService{debugPort, serverPort, plugins, steamPath, authToken, pluginsDir}
+func NewInjectService(debugPort, serverPort string, plugins *plugins.Plugins, steamPath string, tokens *auth.Tokens, pluginsDir string, safeMode *safemode.Tracker) *InjectService {
+	return &InjectService{
+		debugPort:  debugPort,
+		serverPort: serverPort,
+		plugins:    plugins,
+		steamPath:  steamPath,
+		authToken:  tokens.CoreToken(),
+		pluginsDir: pluginsDir,
+		tokens:     tokens,
+		safeMode:   safeMode,
+		injected:   make(map[string]map[injectedTarget]bool),
+	}
 }
 
 type InjectArgs struct{}
@@ -30,6 +49,10 @@ type InjectArgs struct{}
 type InjectReply struct{}
 
 func (service *InjectService) InjectLibrary(r *http.Request, req *InjectArgs, res *InjectReply) error {
+	if err := auth.RequireCoreOrInjector(r); err != nil {
+		return err
+	}
+
 	log.Println("Injecting library scripts...")
 
 	err := cdp.WaitForLibraryEl(service.debugPort)
@@ -57,7 +80,7 @@ func (service *InjectService) InjectLibrary(r *http.Request, req *InjectArgs, re
 		}
 	}
 
-	err = steamClient.RunScriptInLibrary(sharedScript)
+	err = steamClient.RunScriptInLibrary(service.sharedEvalScript())
 	if err != nil {
 		log.Println(err)
 		return fmt.Errorf("Error injecting shared script: %v", err)
@@ -79,6 +102,7 @@ func (service *InjectService) InjectLibrary(r *http.Request, req *InjectArgs, re
 		libraryEvalScript, err = build.BuildEvalScript(
 			service.serverPort,
 			steamClient.UiMode,
+			"library",
 			libraryScript,
 			service.steamPath,
 			service.authToken,
@@ -99,6 +123,10 @@ func (service *InjectService) InjectLibrary(r *http.Request, req *InjectArgs, re
 }
 
 func (service *InjectService) InjectKeyboard(r *http.Request, req *InjectArgs, res *InjectReply) error {
+	if err := auth.RequireCoreOrInjector(r); err != nil {
+		return err
+	}
+
 	log.Println("Injecting keyboard scripts...")
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
@@ -121,7 +149,7 @@ func (service *InjectService) InjectKeyboard(r *http.Request, req *InjectArgs, r
 		}
 	}
 
-	err = steamClient.RunScriptInKeyboard(sharedScript)
+	err = steamClient.RunScriptInKeyboard(service.sharedEvalScript())
 	if err != nil {
 		log.Println(err)
 		return fmt.Errorf("Error injecting shared script: %v", err)
@@ -142,6 +170,7 @@ func (service *InjectService) InjectKeyboard(r *http.Request, req *InjectArgs, r
 		keyboardEvalScript, err = build.BuildEvalScript(
 			service.serverPort,
 			steamClient.UiMode,
+			"keyboard",
 			keyboardScript,
 			service.steamPath,
 			service.authToken,
@@ -162,6 +191,10 @@ func (service *InjectService) InjectKeyboard(r *http.Request, req *InjectArgs, r
 }
 
 func (service *InjectService) InjectMenu(r *http.Request, req *InjectArgs, res *InjectReply) error {
+	if err := auth.RequireCoreOrInjector(r); err != nil {
+		return err
+	}
+
 	log.Println("Injecting menu scripts...")
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
@@ -185,7 +218,7 @@ func (service *InjectService) InjectMenu(r *http.Request, req *InjectArgs, res *
 		}
 	}
 
-	err = steamClient.RunScriptInMenu(sharedScript)
+	err = steamClient.RunScriptInMenu(service.sharedEvalScript())
 	if err != nil {
 		log.Println(err)
 		return fmt.Errorf("Error injecting shared script: %v", err)
@@ -207,6 +240,7 @@ func (service *InjectService) InjectMenu(r *http.Request, req *InjectArgs, res *
 		menuEvalScript, err = build.BuildEvalScript(
 			service.serverPort,
 			steamClient.UiMode,
+			"menu",
 			menuScript,
 			service.steamPath,
 			service.authToken,
@@ -227,6 +261,10 @@ func (service *InjectService) InjectMenu(r *http.Request, req *InjectArgs, res *
 }
 
 func (service *InjectService) InjectQuickAccess(r *http.Request, req *InjectArgs, res *InjectReply) error {
+	if err := auth.RequireCoreOrInjector(r); err != nil {
+		return err
+	}
+
 	log.Println("Injecting quick access scripts...")
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
@@ -250,7 +288,7 @@ func (service *InjectService) InjectQuickAccess(r *http.Request, req *InjectArgs
 		}
 	}
 
-	err = steamClient.RunScriptInQuickAccess(sharedScript)
+	err = steamClient.RunScriptInQuickAccess(service.sharedEvalScript())
 	if err != nil {
 		log.Println(err)
 		return fmt.Errorf("Error injecting shared script: %v", err)
@@ -272,6 +310,7 @@ func (service *InjectService) InjectQuickAccess(r *http.Request, req *InjectArgs
 		quickAccessEvalScript, err = build.BuildEvalScript(
 			service.serverPort,
 			steamClient.UiMode,
+			"quick-access",
 			quickAccessScript,
 			service.steamPath,
 			service.authToken,
@@ -290,3 +329,9 @@ func (service *InjectService) InjectQuickAccess(r *http.Request, req *InjectArgs
 
 	return nil
 }
+
+// sharedEvalScript returns the shared script with the comments that link it to
+// its source map.
+func (service *InjectService) sharedEvalScript() string {
+	return sharedScript + build.CoreSourceComments(service.serverPort, "shared")
+}
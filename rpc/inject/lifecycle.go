@@ -0,0 +1,173 @@
+package inject
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/cdp"
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+// injectedTarget is a target a plugin was injected into. App properties
+// windows are told apart by their title, it's empty for other targets.
+type injectedTarget struct {
+	target cdp.SteamTarget
+	title  string
+}
+
+func newInjectedTarget(target cdp.SteamTarget, title string) injectedTarget {
+	if target != cdp.AppPropertiesTarget {
+		title = ""
+	}
+	return injectedTarget{target, title}
+}
+
+// unloadPluginScript calls the plugin's unload hook, then removes the plugin
+// so it can be injected again.
+func unloadPluginScript(pluginId string) string {
+	return fmt.Sprintf(
+		"(async () => { await window.smm?.unloadPlugin(%q); if (window.smmPlugins) delete window.smmPlugins[%q]; })()",
+		pluginId,
+		pluginId,
+	)
+}
+
+func runScriptInTarget(steamClient *cdp.SteamClient, target injectedTarget, script string) error {
+	switch target.target {
+	case cdp.LibraryTarget:
+		return steamClient.RunScriptInLibrary(script)
+	case cdp.KeyboardTarget:
+		return steamClient.RunScriptInKeyboard(script)
+	case cdp.MenuTarget:
+		return steamClient.RunScriptInMenu(script)
+	case cdp.QuickAccessTarget:
+		return steamClient.RunScriptInQuickAccess(script)
+	case cdp.AppPropertiesTarget:
+		return steamClient.RunScriptInAppProperties(script, target.title)
+	}
+	return fmt.Errorf(`Unknown target "%s"`, target.target)
+}
+
+func (service *InjectService) isInjected(pluginId string, target injectedTarget) bool {
+	service.injectedMu.Lock()
+	defer service.injectedMu.Unlock()
+
+	return service.injected[pluginId][target]
+}
+
+func (service *InjectService) setInjected(pluginId string, target injectedTarget, injected bool) {
+	service.injectedMu.Lock()
+	defer service.injectedMu.Unlock()
+
+	if !injected {
+		delete(service.injected[pluginId], target)
+		return
+	}
+
+	if service.injected[pluginId] == nil {
+		service.injected[pluginId] = make(map[injectedTarget]bool)
+	}
+	service.injected[pluginId][target] = true
+}
+
+// injectedTargets returns the targets the plugin is injected into.
+func (service *InjectService) injectedTargets(pluginId string) []injectedTarget {
+	service.injectedMu.Lock()
+	defer service.injectedMu.Unlock()
+
+	targets := make([]injectedTarget, 0, len(service.injected[pluginId]))
+	for target := range service.injected[pluginId] {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// forgetTarget records that no plugins are injected into the target, after
+// it's been reloaded.
+func (service *InjectService) forgetTarget(target injectedTarget) {
+	service.injectedMu.Lock()
+	defer service.injectedMu.Unlock()
+
+	for _, targets := range service.injected {
+		delete(targets, target)
+	}
+}
+
+func (service *InjectService) unloadPluginFrom(steamClient *cdp.SteamClient, pluginId string, target injectedTarget) error {
+	log.Printf("Unloading plugin \"%s\" from %s\n", pluginId, target.target)
+
+	// The plugin is forgotten even if unloading fails, the target has most
+	// likely been closed
+	service.setInjected(pluginId, target, false)
+
+	if err := runScriptInTarget(steamClient, target, unloadPluginScript(pluginId)); err != nil {
+		return fmt.Errorf(`Error unloading plugin "%s" from %s: %v`, pluginId, target.target, err)
+	}
+
+	return nil
+}
+
+// unloadPlugin unloads the plugin from every target it's injected into.
+func (service *InjectService) unloadPlugin(steamClient *cdp.SteamClient, pluginId string) {
+	for _, target := range service.injectedTargets(pluginId) {
+		if err := service.unloadPluginFrom(steamClient, pluginId, target); err != nil {
+			// Keep going so the plugin is unloaded from every other target
+			log.Println(err)
+		}
+	}
+}
+
+type UnloadPluginArgs struct {
+	PluginId string `json:"pluginId"`
+}
+
+type UnloadPluginReply struct{}
+
+// UnloadPlugin calls the plugin's unload hook in every target it was injected
+// into, and removes it so it can be injected again.
+func (service *InjectService) UnloadPlugin(r *http.Request, req *UnloadPluginArgs, res *UnloadPluginReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	steamClient, err := cdp.NewSteamClient(service.debugPort)
+	if err != nil {
+		return err
+	}
+	defer steamClient.Cancel()
+
+	service.unloadPlugin(steamClient, req.PluginId)
+
+	return nil
+}
+
+// UnloadDisabledPlugins unloads plugins as soon as they're disabled in the
+// current UI mode or removed. It runs until the plugin registry stops
+// publishing events.
+func (service *InjectService) UnloadDisabledPlugins() {
+	events, _ := service.plugins.Subscribe()
+	for event := range events {
+		if event.Type != plugins.EventEnabled && event.Type != plugins.EventDisabled && event.Type != plugins.EventRemoved {
+			continue
+		}
+		if len(service.injectedTargets(event.PluginId)) == 0 {
+			continue
+		}
+
+		steamClient, err := cdp.NewSteamClient(service.debugPort)
+		if err != nil {
+			log.Printf("Error unloading plugin \"%s\": %v\n", event.PluginId, err)
+			continue
+		}
+
+		// The plugin can still be enabled in the other UI mode, it's unloaded if
+		// it's disabled in the current one
+		if plugin, ok := service.plugins.Get(event.PluginId); !ok || !plugin.EnabledModes[steamClient.UiMode] {
+			service.unloadPlugin(steamClient, event.PluginId)
+		}
+
+		steamClient.Cancel()
+	}
+}
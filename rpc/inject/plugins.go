@@ -5,6 +5,8 @@ import (
 	"log"
 	"net/http"
 
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/build"
 	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/plugins"
 )
@@ -47,6 +49,10 @@ type InjectPluginsArgs struct {
 type InjectPluginsReply struct{}
 
 func (service *InjectService) InjectPlugins(r *http.Request, req *InjectPluginsArgs, res *InjectPluginsReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	log.Printf("Injecting plugins into %s...\n", req.Entrypoint)
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
@@ -56,14 +62,40 @@ func (service *InjectService) InjectPlugins(r *http.Request, req *InjectPluginsA
 	}
 	defer steamClient.Cancel()
 
-	for _, plugin := range service.plugins.PluginMap {
-		if !plugin.Enabled {
+	// The target was just loaded, so none of the plugins injected into it
+	// before are still there
+	service.forgetTarget(newInjectedTarget(req.Entrypoint.target(), req.Title))
+
+	// Inject plugins in load order, so plugins are loaded after the plugins
+	// they require. Only plugins enabled in the current UI mode are injected,
+	// and none are in safe mode. Plugins aren't enabled in a UI mode unless the
+	// plugins they require are too.
+	pluginMap := service.plugins.List()
+	loadOrder := service.plugins.LoadOrder()
+	if service.safeMode.Active() {
+		log.Println("In safe mode, not injecting plugins")
+		loadOrder = nil
+	}
+	for _, pluginId := range loadOrder {
+		plugin, ok := pluginMap[pluginId]
+		if !ok || !plugin.EnabledModes[steamClient.UiMode] || plugin.Status != plugins.PluginStatusOk {
 			continue
 		}
 
-		if err := injectPlugin(steamClient, plugin, req.Entrypoint.target(), req.Title); err != nil {
+		script := service.pluginScript(plugin)
+
+		// If injecting the plugin hangs or crashes Steam, this is the last
+		// plugin safe mode reports
+		if err := service.safeMode.Injecting(pluginId); err != nil {
+			log.Println(err)
+		}
+
+		if err := service.injectPlugin(steamClient, plugin, script, req.Entrypoint.target(), req.Title); err != nil {
+			// Keep going so one broken plugin doesn't stop the rest from loading
 			log.Println(err)
-			return err
+			if err := service.safeMode.PluginFailed(pluginId, err); err != nil {
+				log.Println(err)
+			}
 		}
 	}
 
@@ -93,10 +125,20 @@ type InjectPluginArgs struct {
 type InjectPluginReply struct{}
 
 func (service *InjectService) InjectPlugin(r *http.Request, req *InjectPluginArgs, res *InjectPluginReply) error {
-	plugin, ok := service.plugins.PluginMap[req.PluginId]
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	plugin, ok := service.plugins.Get(req.PluginId)
 	if !ok {
 		return fmt.Errorf("Plugin %s not found", req.PluginId)
 	}
+	if plugin.Status != plugins.PluginStatusOk {
+		return fmt.Errorf("Plugin %s can't be loaded: %s", req.PluginId, plugin.Error)
+	}
+	if service.safeMode.Active() {
+		return fmt.Errorf("Plugin %s can't be loaded in safe mode", req.PluginId)
+	}
 
 	steamClient, err := cdp.NewSteamClient(service.debugPort)
 	if err != nil {
@@ -104,50 +146,151 @@ func (service *InjectService) InjectPlugin(r *http.Request, req *InjectPluginArg
 	}
 	defer steamClient.Cancel()
 
-	if err := injectPlugin(steamClient, plugin, req.Entrypoint.target(), req.Title); err != nil {
+	script := service.pluginScript(plugin)
+
+	if err := service.injectPlugin(steamClient, plugin, script, req.Entrypoint.target(), req.Title); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func injectPlugin(steamClient *cdp.SteamClient, plugin plugins.Plugin, entrypoint cdp.SteamTarget, title string) error {
+// pluginScript returns the plugin's script with comments pointing to its
+// source map.
+func (service *InjectService) pluginScript(plugin plugins.Plugin) string {
+	return plugin.Script + build.PluginSourceComments(service.serverPort, plugin.Id)
+}
+
+type PluginAuthTokenArgs struct {
+	PluginId string `json:"pluginId"`
+}
+
+type PluginAuthTokenReply struct {
+	Token string `json:"token"`
+}
+
+// PluginAuthToken returns the plugin's scoped auth token, which only grants
+// the permissions declared in the plugin's config. The core script passes it
+// to the plugin when loading it, so the token isn't visible to other plugins.
+func (service *InjectService) PluginAuthToken(r *http.Request, req *PluginAuthTokenArgs, res *PluginAuthTokenReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	plugin, ok := service.plugins.Get(req.PluginId)
+	if !ok {
+		return fmt.Errorf("Plugin %s not found", req.PluginId)
+	}
+	if plugin.Status != plugins.PluginStatusOk || !plugin.Enabled {
+		return fmt.Errorf("Plugin %s isn't enabled", req.PluginId)
+	}
+
+	token, err := service.tokens.IssuePluginToken(plugin.Id, plugin.Config.Permissions)
+	if err != nil {
+		return fmt.Errorf(`Error issuing auth token for plugin "%s": %v`, plugin.Id, err)
+	}
+	res.Token = token
+
+	return nil
+}
+
+// injectPlugin injects the plugin's script into the target if the plugin
+// declares it as an entrypoint. If the plugin is already injected into the
+// target, the old version is unloaded first so it doesn't keep running
+// alongside the new one.
+func (service *InjectService) injectPlugin(steamClient *cdp.SteamClient, plugin plugins.Plugin, script string, entrypoint cdp.SteamTarget, title string) error {
 	pluginEntrypoints := plugin.Config.Entrypoints[steamClient.UiMode]
 
-	if entrypoint == cdp.LibraryTarget && pluginEntrypoints.Library {
-		log.Println("Injecting", plugin.Id, "into library")
-		if err := steamClient.RunScriptInLibrary(plugin.Script); err != nil {
-			return fmt.Errorf(`Error injecting plugin "%s" into library: %v`, plugin.Config.Name, err)
-		}
+	var declared bool
+	var targetName string
+	switch entrypoint {
+	case cdp.LibraryTarget:
+		declared, targetName = pluginEntrypoints.Library, "library"
+	case cdp.KeyboardTarget:
+		declared, targetName = pluginEntrypoints.Keyboard, "keyboard"
+	case cdp.MenuTarget:
+		declared, targetName = pluginEntrypoints.Menu, "menu"
+	case cdp.QuickAccessTarget:
+		declared, targetName = pluginEntrypoints.QuickAccess, "quick access"
+	case cdp.AppPropertiesTarget:
+		declared, targetName = pluginEntrypoints.AppProperties, "app properties"
+	}
+	if !declared {
+		return nil
 	}
 
-	if entrypoint == cdp.KeyboardTarget && pluginEntrypoints.Keyboard {
-		log.Println("Injecting", plugin.Id, "into keyboard")
-		if err := steamClient.RunScriptInKeyboard(plugin.Script); err != nil {
-			return fmt.Errorf(`Error injecting plugin "%s" into keyboard: %v`, plugin.Config.Name, err)
+	target := newInjectedTarget(entrypoint, title)
+	if service.isInjected(plugin.Id, target) {
+		if err := service.unloadPluginFrom(steamClient, plugin.Id, target); err != nil {
+			log.Println(err)
 		}
 	}
 
-	if entrypoint == cdp.MenuTarget && pluginEntrypoints.Menu {
-		log.Println("Injecting", plugin.Id, "into menu")
-		if err := steamClient.RunScriptInMenu(plugin.Script); err != nil {
-			return fmt.Errorf(`Error injecting plugin "%s" into menu: %v`, plugin.Config.Name, err)
-		}
+	log.Println("Injecting", plugin.Id, "into", targetName)
+	if err := runScriptInTarget(steamClient, target, script); err != nil {
+		return fmt.Errorf(`Error injecting plugin "%s" into %s: %v`, plugin.Config.Name, targetName, err)
 	}
+	service.setInjected(plugin.Id, target, true)
 
-	if entrypoint == cdp.QuickAccessTarget && pluginEntrypoints.QuickAccess {
-		log.Println("Injecting", plugin.Id, "into quick access")
-		if err := steamClient.RunScriptInQuickAccess(plugin.Script); err != nil {
-			log.Println(err)
-			return fmt.Errorf(`Error injecting plugin "%s" into quick access: %v`, plugin.Config.Name, err)
+	return nil
+}
+
+// Targets a plugin can be reinjected into. App properties windows are left
+// out, they're only injected into when they're opened.
+var reinjectTargets = []cdp.SteamTarget{
+	cdp.LibraryTarget,
+	cdp.KeyboardTarget,
+	cdp.MenuTarget,
+	cdp.QuickAccessTarget,
+}
+
+// ReinjectRebuiltPlugins reinjects plugins into every target they declare
+// whenever they're rebuilt, and reloads them if they're enabled. It runs until
+// the plugin registry stops publishing events.
+func (service *InjectService) ReinjectRebuiltPlugins() {
+	events, _ := service.plugins.Subscribe()
+	for event := range events {
+		if event.Type != plugins.EventRebuilt {
+			continue
 		}
+
+		if err := service.reinjectPlugin(event.PluginId); err != nil {
+			log.Printf("Error reinjecting plugin \"%s\": %v\n", event.PluginId, err)
+		}
+	}
+}
+
+func (service *InjectService) reinjectPlugin(pluginId string) error {
+	plugin, ok := service.plugins.Get(pluginId)
+	if !ok || plugin.Status != plugins.PluginStatusOk || !plugin.Enabled || service.safeMode.Active() {
+		return nil
 	}
 
-	if entrypoint == cdp.AppPropertiesTarget && pluginEntrypoints.AppProperties {
-		log.Println("Injecting", plugin.Id, "into app properties")
-		if err := steamClient.RunScriptInAppProperties(plugin.Script, title); err != nil {
+	log.Printf("Reinjecting plugin \"%s\"...\n", pluginId)
+
+	steamClient, err := cdp.NewSteamClient(service.debugPort)
+	if err != nil {
+		return err
+	}
+	defer steamClient.Cancel()
+
+	if !plugin.EnabledModes[steamClient.UiMode] {
+		return nil
+	}
+
+	script := service.pluginScript(plugin)
+
+	// injectPlugin unloads the old version before replacing it, then the new
+	// one is loaded. The wrapper starts on the same line as the script so its
+	// source map still lines up.
+	script = fmt.Sprintf("(async () => { %s\nawait window.smm?.loadPlugin(%q);\n})()", script, pluginId)
+
+	// injectPlugin skips targets the plugin doesn't declare
+	for _, target := range reinjectTargets {
+		if err := service.injectPlugin(steamClient, plugin, script, target, ""); err != nil {
+			// Keep going so one missing target doesn't stop the others from
+			// being updated
 			log.Println(err)
-			return fmt.Errorf(`Error injecting plugin "%s" into app properties: %v`, plugin.Config.Name, err)
 		}
 	}
 
@@ -2,6 +2,11 @@
 
 package inject
 
+import (
+	"os"
+	"path"
+)
+
 // In dev mode, these scripts will be bundled at run time, so we just declare
 // them as empty strings for now.
 
@@ -11,3 +16,8 @@ var keyboardScript string
 var menuScript string
 var quickAccessScript string
 var appPropertiesScript string
+
+// readCoreSourceMap reads a core script's source map from the bundle output.
+func readCoreSourceMap(name string) ([]byte, error) {
+	return os.ReadFile(path.Join(".build", name+".js.map"))
+}
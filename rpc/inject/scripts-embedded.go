@@ -3,7 +3,7 @@
 package inject
 
 import (
-	_ "embed"
+	"embed"
 )
 
 // When in release mode, these scripts should be built and placed in a scripts
@@ -26,3 +26,12 @@ var quickAccessScript string
 
 //go:embed scripts/app-properties.js
 var appPropertiesScript string
+
+//go:embed scripts/*.js.map
+var sourceMaps embed.FS
+
+// readCoreSourceMap reads a core script's source map from the embedded
+// scripts.
+func readCoreSourceMap(name string) ([]byte, error) {
+	return sourceMaps.ReadFile("scripts/" + name + ".js.map")
+}
@@ -0,0 +1,104 @@
+package inject
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"git.sr.ht/~avery/crankshaft/build"
+	"git.sr.ht/~avery/crankshaft/plugins"
+)
+
+// Core scripts that have source maps, by name
+var coreScriptNames = map[string]bool{
+	"shared":         true,
+	"library":        true,
+	"keyboard":       true,
+	"menu":           true,
+	"quick-access":   true,
+	"app-properties": true,
+}
+
+// coreSourceMapOffset returns the number of lines added before the core script
+// when it's injected.
+func coreSourceMapOffset(name string) int {
+	switch name {
+	case "shared":
+		// The shared script is injected as it is
+		return 0
+	case "app-properties":
+		// The app properties script is wrapped in another template that sets the
+		// app first
+		return build.EvalScriptHeaderLines + 1
+	}
+	return build.EvalScriptHeaderLines
+}
+
+// HandleSourceMaps serves the source maps for the injected scripts at
+// /sourcemaps/core/<name>.js.map and /sourcemaps/plugins/<id>.js.map. The maps
+// are offset to match the scripts as they're injected.
+func HandleSourceMaps(p *plugins.Plugins) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		kind, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/sourcemaps/"), "/")
+		name := strings.TrimSuffix(file, ".js.map")
+		if !ok || !strings.HasSuffix(file, ".js.map") || name == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var sourceMap []byte
+		var lines int
+		switch kind {
+		case "core":
+			if !coreScriptNames[name] {
+				http.NotFound(w, r)
+				return
+			}
+
+			var err error
+			sourceMap, err = readCoreSourceMap(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				log.Printf("Error reading source map for \"%s\": %v\n", name, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			lines = coreSourceMapOffset(name)
+		case "plugins":
+			plugin, ok := p.Get(name)
+			if !ok || plugin.SourceMap == "" {
+				http.NotFound(w, r)
+				return
+			}
+			// Plugin scripts are injected as they are
+			sourceMap = []byte(plugin.SourceMap)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		sourceMap, err := build.OffsetSourceMap(sourceMap, lines)
+		if err != nil {
+			log.Printf("Error offsetting source map for \"%s\": %v\n", name, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(sourceMap)
+	})
+}
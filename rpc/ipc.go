@@ -1,8 +1,11 @@
 package rpc
 
 import (
+	"encoding/json"
+	"log"
 	"net/http"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/ws"
 )
 
@@ -21,6 +24,30 @@ type SendArgs struct {
 type SendReply struct{}
 
 func (service *IPCService) Send(r *http.Request, req *SendArgs, res *SendReply) error {
+	// The core scripts use IPC to manage plugins in other targets
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	service.wsHub.Broadcast <- []byte(req.Message)
 	return nil
 }
+
+// ipcMessage is the format of messages sent to the injected scripts over the
+// websocket hub.
+type ipcMessage struct {
+	Name string      `json:"name"`
+	Data interface{} `json:"data"`
+}
+
+// broadcastIPC sends a message to every injected script listening for the
+// given IPC name.
+func broadcastIPC(hub *ws.Hub, name string, data interface{}) {
+	message, err := json.Marshal(ipcMessage{Name: name, Data: data})
+	if err != nil {
+		log.Printf("Error encoding IPC message %s: %v\n", name, err)
+		return
+	}
+
+	hub.Broadcast <- message
+}
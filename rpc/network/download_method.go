@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"git.sr.ht/~avery/crankshaft/auth"
 	"git.sr.ht/~avery/crankshaft/pathutil"
 )
 
@@ -32,6 +33,12 @@ type DownloadReply struct {
 
 func (service *NetworkService) Download(r *http.Request, req *DownloadArgs, res *DownloadReply) error {
 	path := pathutil.SubstituteHomeAndXdg(req.Path)
+	if err := auth.CheckUrl(r, req.Url); err != nil {
+		return err
+	}
+	if err := auth.CheckPath(r, path); err != nil {
+		return err
+	}
 
 	out, err := os.Create(path)
 	log.Println("Created file", path)
@@ -41,7 +48,7 @@ func (service *NetworkService) Download(r *http.Request, req *DownloadArgs, res
 	}
 	defer out.Close()
 
-	getRes, err := http.Get(req.Url)
+	getRes, err := scopedClient(r).Get(req.Url)
 	log.Println("got file", req.Url)
 	if err != nil {
 		log.Println("Error getting download", req.Url)
@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+
+	"git.sr.ht/~avery/crankshaft/auth"
 )
 
 type GetArgs struct {
@@ -16,7 +18,11 @@ type GetReply struct {
 }
 
 func (service *NetworkService) Get(r *http.Request, req *GetArgs, res *GetReply) error {
-	getRes, err := http.Get(req.Url)
+	if err := auth.CheckUrl(r, req.Url); err != nil {
+		return err
+	}
+
+	getRes, err := scopedClient(r).Get(req.Url)
 	if err != nil {
 		log.Println("Error fetching", req.Url)
 		return err
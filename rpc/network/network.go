@@ -1,5 +1,12 @@
 package network
 
+import (
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+)
+
 type NetworkService struct {
 	DownloadProgress
 }
@@ -7,3 +14,19 @@ type NetworkService struct {
 func NewNetworkService() *NetworkService {
 	return &NetworkService{DownloadProgress: make(DownloadProgress)}
 }
+
+// maxRedirects is the same limit http.Client uses by default.
+const maxRedirects = 10
+
+// scopedClient returns a client for requests made on behalf of r, which
+// checks every redirect against r's network scope.
+func scopedClient(r *http.Request) *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("Stopped after 10 redirects")
+			}
+			return auth.CheckUrl(r, req.URL.String())
+		},
+	}
+}
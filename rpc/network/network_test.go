@@ -0,0 +1,43 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+)
+
+func TestScopedClientChecksRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	targetUrl, err := url.Parse(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both servers are on 127.0.0.1, so the redirect goes to localhost to
+	// reach a different host
+	redirect := httptest.NewServer(http.RedirectHandler("http://localhost:"+targetUrl.Port(), http.StatusFound))
+	defer redirect.Close()
+
+	identity := auth.Identity{PluginId: "example", Scope: auth.Scope{Network: []string{"127.0.0.1"}}}
+	r := httptest.NewRequest(http.MethodPost, "/rpc", nil).WithContext(auth.WithIdentity(context.Background(), identity))
+
+	_, err = scopedClient(r).Get(redirect.URL)
+	var permissionErr *auth.PermissionError
+	if !errors.As(err, &permissionErr) {
+		t.Fatalf("Expected a permission error for the redirect, got %v", err)
+	}
+
+	identity.Scope.Network = append(identity.Scope.Network, "localhost")
+	r = r.WithContext(auth.WithIdentity(context.Background(), identity))
+	res, err := scopedClient(r).Get(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
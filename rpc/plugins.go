@@ -1,17 +1,24 @@
 package rpc
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/cdp"
 	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/registry"
 )
 
 type PluginsService struct {
-	plugins *plugins.Plugins
+	plugins       *plugins.Plugins
+	updateChecker *registry.UpdateChecker
 }
 
-func NewPluginsService(plugins *plugins.Plugins) *PluginsService {
-	return &PluginsService{plugins}
+func NewPluginsService(plugins *plugins.Plugins, updateChecker *registry.UpdateChecker) *PluginsService {
+	return &PluginsService{plugins, updateChecker}
 }
 
 type ListArgs struct{}
@@ -22,16 +29,18 @@ type ListReply struct {
 
 func (service *PluginsService) List(r *http.Request, req *ListArgs, res *ListReply) error {
 	res.Plugins = make(map[string]plugins.Plugin)
-	for id, plugin := range service.plugins.PluginMap {
+	for id, plugin := range service.plugins.List() {
+		backups, err := service.plugins.Backups(id)
+		if err != nil {
+			log.Println(err)
+		}
+
 		// We don't include the script here. It's large and not necessary since
 		// we're just getting info about the plugin, not loading it, so we don't
 		// need to send it over.
-		res.Plugins[id] = plugins.Plugin{
-			Id:      plugin.Id,
-			Dir:     plugin.Dir,
-			Config:  plugin.Config,
-			Enabled: plugin.Enabled,
-		}
+		plugin.Script = ""
+		plugin.Backups = backups
+		res.Plugins[id] = plugin
 	}
 	return nil
 }
@@ -43,6 +52,10 @@ type RebuildArgs struct {
 type RebuildReply struct{}
 
 func (service *PluginsService) Rebuild(r *http.Request, req *RebuildArgs, res *RebuildReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	return service.plugins.RebuildPlugin(req.Id)
 }
 
@@ -50,27 +63,280 @@ type ReloadArgs struct{}
 type ReloadReply struct{}
 
 func (service *PluginsService) Reload(r *http.Request, req *ReloadArgs, res *ReloadReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
 	return service.plugins.Reload()
 }
 
 type SetEnabledArgs struct {
 	Id      string `json:"id"`
 	Enabled bool   `json:"enabled"`
+	// UI mode to enable or disable the plugin in, every UI mode if it's empty
+	UIMode cdp.UIMode `json:"uiMode"`
+	// Set once the user has approved the permissions the plugin requested
+	ApprovePermissions bool `json:"approvePermissions"`
 }
 
-type SetEnabledReply struct{}
+type SetEnabledReply struct {
+	// PermissionsRequired is true if the plugin wasn't enabled because the user
+	// has to approve Permissions first
+	PermissionsRequired bool       `json:"permissionsRequired"`
+	Permissions         auth.Scope `json:"permissions"`
+}
 
 func (service *PluginsService) SetEnabled(r *http.Request, req *SetEnabledArgs, res *SetEnabledReply) error {
-	err := service.plugins.SetEnabled(req.Id, req.Enabled)
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	err := service.plugins.SetEnabledIn(req.Id, req.UIMode, req.Enabled, req.ApprovePermissions)
+
+	var permissionsErr *plugins.PermissionsRequiredError
+	if errors.As(err, &permissionsErr) {
+		res.PermissionsRequired = true
+		res.Permissions = permissionsErr.Permissions
+		return nil
+	}
+
 	return err
 }
 
+type ListProfilesArgs struct{}
+
+type ListProfilesReply struct {
+	Profiles []plugins.Profile `json:"profiles"`
+	// Name of the active profile, empty if there isn't one
+	Active string `json:"active"`
+}
+
+func (service *PluginsService) ListProfiles(r *http.Request, req *ListProfilesArgs, res *ListProfilesReply) error {
+	res.Profiles, res.Active = service.plugins.Profiles()
+	return nil
+}
+
+type ProfileArgs struct {
+	Name string `json:"name"`
+}
+
+type ProfileReply struct{}
+
+// SaveProfile saves the plugins enabled in each UI mode as the named profile.
+func (service *PluginsService) SaveProfile(r *http.Request, req *ProfileArgs, res *ProfileReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.plugins.SaveProfile(req.Name)
+}
+
+func (service *PluginsService) ActivateProfile(r *http.Request, req *ProfileArgs, res *ProfileReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.plugins.ActivateProfile(req.Name)
+}
+
+func (service *PluginsService) DeleteProfile(r *http.Request, req *ProfileArgs, res *ProfileReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.plugins.DeleteProfile(req.Name)
+}
+
 type RemoveArgs struct {
 	Id string `json:"id"`
+	// Also delete the data the plugin saved with StoreService
+	PurgeData bool `json:"purgeData"`
 }
 
 type RemoveReply struct{}
 
 func (service *PluginsService) Remove(r *http.Request, req *RemoveArgs, res *RemoveReply) error {
-	return service.plugins.RemovePlugin(req.Id)
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.plugins.RemovePlugin(req.Id, req.PurgeData)
+}
+
+type InstallArgs struct {
+	Url    string `json:"url"`
+	Sha256 string `json:"sha256"`
+	// Base64 encoded ed25519 signature of the archive
+	Signature string `json:"signature"`
+	// Set once the user has agreed to install an archive that isn't signed by a
+	// trusted key
+	AllowUnsigned bool `json:"allowUnsigned"`
+	// Name of the repository the archive is listed in, empty if it isn't from a
+	// repository
+	Repository string `json:"repository"`
+}
+
+type InstallReply struct {
+	Id string `json:"id"`
+	// SignatureRequired is true if the plugin wasn't installed because its
+	// archive isn't signed by a trusted key, and the user has to allow it
+	// first. SignatureError is the reason the signature couldn't be verified.
+	SignatureRequired bool   `json:"signatureRequired"`
+	SignatureError    string `json:"signatureError,omitempty"`
+	// Warning is set if the plugin was installed but can't be enabled, because
+	// it doesn't support this platform
+	Warning string `json:"warning,omitempty"`
+}
+
+func (service *PluginsService) Install(r *http.Request, req *InstallArgs, res *InstallReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	id, err := service.plugins.InstallFrom(req.Repository, req.Url, req.Sha256, req.Signature, req.AllowUnsigned)
+	return service.setInstallReply(res, id, err)
+}
+
+type InstallFromFileArgs struct {
+	// Path of the plugin archive on this device
+	Path string `json:"path"`
+	// Base64 encoded ed25519 signature of the archive
+	Signature string `json:"signature"`
+	// Set once the user has agreed to install an archive that isn't signed by a
+	// trusted key
+	AllowUnsigned bool `json:"allowUnsigned"`
+}
+
+// InstallFromFile installs a plugin archive that's already on this device, for
+// devices without network access.
+func (service *PluginsService) InstallFromFile(r *http.Request, req *InstallFromFileArgs, res *InstallReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	id, err := service.plugins.InstallFromFile(req.Path, req.Signature, req.AllowUnsigned)
+	return service.setInstallReply(res, id, err)
+}
+
+// setInstallReply fills in the reply to an install. An archive that isn't
+// signed by a trusted key isn't an error, the reply asks the user to allow it.
+func (service *PluginsService) setInstallReply(res *InstallReply, id string, err error) error {
+	var signatureErr *plugins.SignatureError
+	if errors.As(err, &signatureErr) {
+		res.Id = signatureErr.PluginId
+		res.SignatureRequired = true
+		res.SignatureError = signatureErr.Reason
+		return nil
+	}
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	res.Id = id
+	if plugin, ok := service.plugins.Get(id); ok && plugin.Status == plugins.PluginStatusUnsupported {
+		res.Warning = plugin.Error
+	}
+
+	return nil
+}
+
+type RollbackArgs struct {
+	Id      string `json:"id"`
+	Version string `json:"version"`
+}
+
+type RollbackReply struct{}
+
+func (service *PluginsService) Rollback(r *http.Request, req *RollbackArgs, res *RollbackReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	if err := service.plugins.Rollback(req.Id, req.Version); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+type CheckUpdatesArgs struct {
+	// Fetch the registry now instead of returning the result of the last
+	// background check
+	Refresh bool `json:"refresh"`
+}
+
+type CheckUpdatesReply struct {
+	Updates     []registry.Update `json:"updates"`
+	LastChecked time.Time         `json:"lastChecked"`
+}
+
+func (service *PluginsService) CheckUpdates(r *http.Request, req *CheckUpdatesArgs, res *CheckUpdatesReply) error {
+	updates, lastChecked := service.updateChecker.Updates()
+
+	// Check now if asked to, or if the background check hasn't finished yet
+	if req.Refresh || lastChecked.IsZero() {
+		var err error
+		updates, err = service.updateChecker.Check()
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		_, lastChecked = service.updateChecker.Updates()
+	}
+
+	res.Updates = updates
+	res.LastChecked = lastChecked
+
+	return nil
+}
+
+type GetSettingsArgs struct {
+	Id string `json:"id"`
+}
+
+type GetSettingsReply struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+func (service *PluginsService) GetSettings(r *http.Request, req *GetSettingsArgs, res *GetSettingsReply) error {
+	if err := auth.RequireCoreOrPlugin(r, req.Id); err != nil {
+		return err
+	}
+
+	settings, err := service.plugins.GetSettings(req.Id)
+	if err != nil {
+		return err
+	}
+
+	res.Settings = settings
+
+	return nil
+}
+
+type SetSettingsArgs struct {
+	Id string `json:"id"`
+	// Settings to change, settings that aren't included keep their values
+	Settings map[string]interface{} `json:"settings"`
+}
+
+type SetSettingsReply struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+func (service *PluginsService) SetSettings(r *http.Request, req *SetSettingsArgs, res *SetSettingsReply) error {
+	if err := auth.RequireCoreOrPlugin(r, req.Id); err != nil {
+		return err
+	}
+
+	settings, err := service.plugins.SetSettings(req.Id, req.Settings)
+	if err != nil {
+		return err
+	}
+
+	res.Settings = settings
+
+	return nil
 }
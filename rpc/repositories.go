@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~avery/crankshaft/registry"
+)
+
+type RepositoryService struct {
+	repositories *registry.Repositories
+}
+
+func NewRepositoryService(repositories *registry.Repositories) *RepositoryService {
+	return &RepositoryService{repositories}
+}
+
+type ListRepositoriesArgs struct{}
+
+type ListRepositoriesReply struct {
+	// Repositories in priority order
+	Repositories []registry.Repository `json:"repositories"`
+}
+
+func (service *RepositoryService) List(r *http.Request, req *ListRepositoriesArgs, res *ListRepositoriesReply) error {
+	res.Repositories = service.repositories.List()
+
+	return nil
+}
+
+type ListRepositoryPluginsArgs struct {
+	// Fetch the repositories now instead of returning the indexes fetched last
+	Refresh bool `json:"refresh"`
+}
+
+type ListRepositoryPluginsReply struct {
+	Plugins registry.Index `json:"plugins"`
+	// When the oldest of the repositories' indexes was fetched
+	LastFetched time.Time `json:"lastFetched"`
+}
+
+// Plugins returns the plugins listed in every repository, merged into one
+// index. Each plugin has the repository it comes from set.
+func (service *RepositoryService) Plugins(r *http.Request, req *ListRepositoryPluginsArgs, res *ListRepositoryPluginsReply) error {
+	index, lastFetched := service.repositories.Index()
+
+	// Fetch now if asked to, or if a repository hasn't been fetched yet
+	if req.Refresh || lastFetched.IsZero() {
+		var err error
+		index, err = service.repositories.Fetch()
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		_, lastFetched = service.repositories.Index()
+	}
+
+	res.Plugins = index
+	res.LastFetched = lastFetched
+
+	return nil
+}
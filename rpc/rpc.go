@@ -5,9 +5,14 @@ import (
 	"net/http"
 
 	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/backend"
+	"git.sr.ht/~avery/crankshaft/config"
 	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/registry"
 	"git.sr.ht/~avery/crankshaft/rpc/inject"
 	"git.sr.ht/~avery/crankshaft/rpc/network"
+	"git.sr.ht/~avery/crankshaft/safemode"
+	"git.sr.ht/~avery/crankshaft/store"
 	"git.sr.ht/~avery/crankshaft/ws"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/rpc/v2"
@@ -15,35 +20,114 @@ import (
 )
 
 // StartRpcServer starts the HTTP server that serves the RPC plugin API.
-func StartRpcServer(debugPort, serverPort, steamPath, dataDir, pluginsDir, authToken string, plugins *plugins.Plugins) {
+func StartRpcServer(debugPort, serverPort, steamPath, dataDir, pluginsDir, authToken, injectorToken string, crksftConfig *config.CrksftConfig, plugins *plugins.Plugins, repositories *registry.Repositories, pluginStore *store.Store, backends *backend.Supervisor, safeMode *safemode.Tracker, watchPlugins bool) {
 	hub := ws.NewHub()
 	go hub.Run()
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(hub, w, r)
 	})
 
-	rpcServer := handleRpc(debugPort, serverPort, plugins, hub, steamPath, dataDir, pluginsDir, authToken)
+	go forwardPluginEvents(plugins, hub)
+	watchConfig(crksftConfig, plugins, repositories, hub)
 
-	http.Handle("/rpc", auth.RequireAuth(authToken, handlers.CORS(
+	tokens := auth.NewTokens(authToken, injectorToken)
+	go revokePluginTokens(plugins, tokens)
+	go superviseBackends(plugins, backends, safeMode)
+
+	updateChecker := newUpdateChecker(crksftConfig, repositories, plugins, hub)
+	go updateChecker.Run()
+
+	rpcServer := handleRpc(debugPort, serverPort, plugins, repositories, pluginStore, backends, safeMode, updateChecker, hub, steamPath, dataDir, pluginsDir, tokens, watchPlugins)
+
+	http.Handle("/rpc", auth.RequireAuth(tokens, handlers.CORS(
 		handlers.AllowedHeaders([]string{"Content-Type", "X-Cs-Auth"}),
 		handlers.AllowedMethods([]string{"POST"}),
 		handlers.AllowedOrigins([]string{"https://steamloopback.host"}),
 	)(rpcServer)))
 
+	http.Handle("/plugins/", handlers.CORS(
+		handlers.AllowedMethods([]string{"GET", "HEAD"}),
+		handlers.AllowedOrigins([]string{"https://steamloopback.host"}),
+	)(handlePluginAssets(plugins)))
+
+	http.Handle("/sourcemaps/", handlers.CORS(
+		handlers.AllowedMethods([]string{"GET", "HEAD"}),
+		handlers.AllowedOrigins([]string{"https://steamloopback.host"}),
+	)(inject.HandleSourceMaps(plugins)))
+
 	log.Println("Listening on :" + serverPort)
 	log.Fatal(http.ListenAndServe(":"+serverPort, nil))
 }
 
-func handleRpc(debugPort, serverPort string, plugins *plugins.Plugins, hub *ws.Hub, steamPath, dataDir, pluginsDir, authToken string) *rpc.Server {
+func handleRpc(debugPort, serverPort string, plugins *plugins.Plugins, repositories *registry.Repositories, pluginStore *store.Store, backends *backend.Supervisor, safeMode *safemode.Tracker, updateChecker *registry.UpdateChecker, hub *ws.Hub, steamPath, dataDir, pluginsDir string, tokens *auth.Tokens, watchPlugins bool) *rpc.Server {
 	server := rpc.NewServer()
 	server.RegisterCodec(rpcJson.NewCodec(), "application/json")
 	server.RegisterService(network.NewNetworkService(), "NetworkService")
 	server.RegisterService(NewFSService(pluginsDir), "FSService")
-	server.RegisterService(inject.NewInjectService(debugPort, serverPort, plugins, steamPath, authToken, pluginsDir), "InjectService")
-	server.RegisterService(NewPluginsService(plugins), "PluginsService")
+	injectService := inject.NewInjectService(debugPort, serverPort, plugins, steamPath, tokens, pluginsDir, safeMode)
+	go injectService.UnloadDisabledPlugins()
+	if watchPlugins {
+		go injectService.ReinjectRebuiltPlugins()
+	}
+	server.RegisterService(injectService, "InjectService")
+	server.RegisterService(NewPluginsService(plugins, updateChecker), "PluginsService")
+	server.RegisterService(NewRepositoryService(repositories), "RepositoryService")
 	server.RegisterService(NewIPCService(hub), "IPCService")
 	server.RegisterService(NewAutostartService(dataDir), "AutostartService")
 	server.RegisterService(NewExecService(), "ExecService")
-	server.RegisterService(NewStoreService(dataDir), "StoreService")
+	server.RegisterService(NewStoreService(pluginStore, plugins), "StoreService")
+	server.RegisterService(NewBackendService(backends), "BackendService")
+	server.RegisterService(NewSafeModeService(safeMode, plugins, backends), "SafeModeService")
+	server.RegisterService(NewConfigService(plugins, hub), "ConfigService")
 	return server
 }
+
+// newUpdateChecker creates an update checker for the repositories, which
+// broadcasts new updates to the injected scripts.
+func newUpdateChecker(crksftConfig *config.CrksftConfig, repositories *registry.Repositories, plugins *plugins.Plugins, hub *ws.Hub) *registry.UpdateChecker {
+	interval, err := crksftConfig.GetUpdateCheckInterval()
+	if err != nil {
+		log.Println(err)
+		interval = 0
+	}
+
+	return registry.NewUpdateChecker(repositories, plugins, interval, func(updates []registry.Update) {
+		broadcastIPC(hub, "csPluginUpdatesAvailable", updates)
+	})
+}
+
+type pluginSettingsChanged struct {
+	PluginId string                 `json:"pluginId"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// forwardPluginEvents broadcasts plugin registry changes to the injected
+// scripts. Settings changes are broadcast separately with the new settings, so
+// plugins can listen for changes to their own settings.
+func forwardPluginEvents(p *plugins.Plugins, hub *ws.Hub) {
+	events, _ := p.Subscribe()
+	for event := range events {
+		if event.Type == plugins.EventSettingsChanged {
+			settings, err := p.GetSettings(event.PluginId)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			broadcastIPC(hub, "csPluginSettingsChanged", pluginSettingsChanged{event.PluginId, settings})
+			continue
+		}
+		broadcastIPC(hub, "csPluginsChanged", event)
+	}
+}
+
+// revokePluginTokens revokes a plugin's scoped auth token when it's disabled
+// or removed, a new token is issued the next time it's injected.
+func revokePluginTokens(p *plugins.Plugins, tokens *auth.Tokens) {
+	events, _ := p.Subscribe()
+	for event := range events {
+		switch event.Type {
+		case plugins.EventDisabled, plugins.EventRemoved:
+			tokens.RevokePluginToken(event.PluginId)
+		}
+	}
+}
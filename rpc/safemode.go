@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"errors"
+	"net/http"
+
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/backend"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/safemode"
+)
+
+type SafeModeService struct {
+	safeMode *safemode.Tracker
+	plugins  *plugins.Plugins
+	backends *backend.Supervisor
+}
+
+func NewSafeModeService(safeMode *safemode.Tracker, plugins *plugins.Plugins, backends *backend.Supervisor) *SafeModeService {
+	return &SafeModeService{safeMode, plugins, backends}
+}
+
+type SafeModeStatusArgs struct{}
+
+type SafeModeStatusReply struct {
+	Status safemode.Status `json:"status"`
+}
+
+func (service *SafeModeService) Status(r *http.Request, req *SafeModeStatusArgs, res *SafeModeStatusReply) error {
+	res.Status = service.safeMode.Status()
+	return nil
+}
+
+type ReportPluginErrorArgs struct {
+	PluginId string `json:"pluginId"`
+	Error    string `json:"error"`
+}
+
+type ReportPluginErrorReply struct{}
+
+// ReportPluginError records an error thrown while loading a plugin in the
+// injected scripts.
+func (service *SafeModeService) ReportPluginError(r *http.Request, req *ReportPluginErrorArgs, res *ReportPluginErrorReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.safeMode.PluginFailed(req.PluginId, errors.New(req.Error))
+}
+
+type PluginsLoadedArgs struct{}
+
+type PluginsLoadedReply struct{}
+
+// PluginsLoaded records that the library finished loading plugins, so Steam
+// started successfully.
+func (service *SafeModeService) PluginsLoaded(r *http.Request, req *PluginsLoadedArgs, res *PluginsLoadedReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	return service.safeMode.PluginsLoaded()
+}
+
+type ExitSafeModeArgs struct{}
+
+type ExitSafeModeReply struct{}
+
+// Exit turns off safe mode and starts the backends of enabled plugins. The
+// injected scripts are responsible for loading plugins again.
+func (service *SafeModeService) Exit(r *http.Request, req *ExitSafeModeArgs, res *ExitSafeModeReply) error {
+	if err := auth.RequireCore(r); err != nil {
+		return err
+	}
+
+	if err := service.safeMode.Exit(); err != nil {
+		return err
+	}
+
+	for _, plugin := range service.plugins.List() {
+		updateBackend(plugin, service.backends, service.safeMode, false)
+	}
+
+	return nil
+}
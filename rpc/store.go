@@ -1,30 +1,75 @@
 package rpc
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"path"
+	"strings"
 
-	"github.com/boltdb/bolt"
+	"git.sr.ht/~avery/crankshaft/auth"
+	"git.sr.ht/~avery/crankshaft/plugins"
+	"git.sr.ht/~avery/crankshaft/store"
 )
 
+// StoreService lets plugins persist data. Each plugin has its own namespace,
+// and plugins with the shared store permission can also use a namespace
+// shared between plugins.
 type StoreService struct {
-	db *bolt.DB
+	store   *store.Store
+	plugins *plugins.Plugins
 }
 
-func NewStoreService(dataDir string) *StoreService {
-	dbPath := path.Join(dataDir, "store.db")
-	db, err := bolt.Open(dbPath, 0600, nil)
-	if err != nil {
-		// TODO: this error could maybe be handled better
-		log.Fatalf(`StoreService database not found at "%s"`, dbPath)
+func NewStoreService(store *store.Store, plugins *plugins.Plugins) *StoreService {
+	return &StoreService{store, plugins}
+}
+
+// Store buckets of Crankshaft's internal plugins start with this prefix. They
+// run with the core token, and it's the only store they can use.
+const internalBucketPrefix = "_cs-"
+
+// storeNamespace returns the namespace a request should use. Plugins always use
+// the namespace of the plugin their auth token was issued to. The core
+// Crankshaft scripts can only use the namespaces of internal plugins, so they
+// can't be used to access an installed plugin's data.
+func storeNamespace(r *http.Request, bucket string, shared bool) (store.Namespace, error) {
+	if shared {
+		if err := auth.CheckSharedStore(r); err != nil {
+			return store.Namespace{}, err
+		}
+		return store.SharedNamespace, nil
 	}
 
-	return &StoreService{db}
+	identity, ok := auth.IdentityFromRequest(r)
+	if !ok {
+		return store.Namespace{}, errors.New("Request is missing an auth identity")
+	}
+
+	if identity.IsCore() {
+		if !strings.HasPrefix(bucket, internalBucketPrefix) {
+			return store.Namespace{}, fmt.Errorf(`Core scripts can only use the store of internal plugins, not "%s"`, bucket)
+		}
+		return store.PluginNamespace(bucket), nil
+	}
+
+	if identity.PluginId == "" {
+		return store.Namespace{}, errors.New("Injector isn't allowed to access the store")
+	}
+	if strings.HasPrefix(identity.PluginId, internalBucketPrefix) {
+		return store.Namespace{}, fmt.Errorf(`Plugin "%s" can't use the store because its ID is reserved for internal plugins`, identity.PluginId)
+	}
+	if bucket != "" && bucket != identity.PluginId {
+		return store.Namespace{}, fmt.Errorf(`Plugin "%s" isn't allowed to access the store of plugin "%s"`, identity.PluginId, bucket)
+	}
+	return store.PluginNamespace(identity.PluginId), nil
 }
 
 type GetArgs struct {
+	// Plugin ID of the namespace to use. Plugins always use their own namespace
+	// and can leave this empty, the core scripts can only use internal plugins'
+	// namespaces.
 	Bucket string `json:"bucket"`
+	// Use the namespace shared between plugins instead of Bucket
+	Shared bool   `json:"shared"`
 	Key    string `json:"key"`
 }
 
@@ -34,28 +79,18 @@ type GetReply struct {
 }
 
 func (service *StoreService) Get(r *http.Request, req *GetArgs, res *GetReply) error {
-	return service.db.View(func(tx *bolt.Tx) error {
-		res.Found = false
-		res.Value = ""
-
-		b := tx.Bucket([]byte(req.Bucket))
-		if b == nil {
-			return nil
-		}
-
-		val := b.Get([]byte(req.Key))
-		if val == nil {
-			return nil
-		}
+	ns, err := storeNamespace(r, req.Bucket, req.Shared)
+	if err != nil {
+		return err
+	}
 
-		res.Found = true
-		res.Value = string(val)
-		return nil
-	})
+	res.Value, res.Found, err = service.store.Get(ns, req.Key)
+	return err
 }
 
 type SetArgs struct {
 	Bucket string `json:"bucket"`
+	Shared bool   `json:"shared"`
 	Key    string `json:"key"`
 	Value  string `json:"value"`
 }
@@ -63,12 +98,50 @@ type SetArgs struct {
 type SetReply struct{}
 
 func (service *StoreService) Set(r *http.Request, req *SetArgs, res *SetReply) error {
-	return service.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(req.Bucket))
-		if err != nil {
-			return err
-		}
+	ns, err := storeNamespace(r, req.Bucket, req.Shared)
+	if err != nil {
+		return err
+	}
+
+	return service.store.Set(ns, req.Key, req.Value, service.plugins.StoreQuota(ns.PluginId))
+}
+
+type DeleteArgs struct {
+	Bucket string `json:"bucket"`
+	Shared bool   `json:"shared"`
+	Key    string `json:"key"`
+}
+
+type DeleteReply struct{}
+
+func (service *StoreService) Delete(r *http.Request, req *DeleteArgs, res *DeleteReply) error {
+	ns, err := storeNamespace(r, req.Bucket, req.Shared)
+	if err != nil {
+		return err
+	}
+
+	return service.store.Delete(ns, req.Key)
+}
+
+type UsageArgs struct {
+	Bucket string `json:"bucket"`
+	Shared bool   `json:"shared"`
+}
+
+type UsageReply struct {
+	// Bytes stored in the namespace
+	Size int64 `json:"size"`
+	// Size limit of the namespace in bytes, 0 if there's no limit
+	Quota int64 `json:"quota"`
+}
+
+func (service *StoreService) Usage(r *http.Request, req *UsageArgs, res *UsageReply) error {
+	ns, err := storeNamespace(r, req.Bucket, req.Shared)
+	if err != nil {
+		return err
+	}
 
-		return b.Put([]byte(req.Key), []byte(req.Value))
-	})
+	res.Size, err = service.store.Size(ns)
+	res.Quota = service.plugins.StoreQuota(ns.PluginId)
+	return err
 }
@@ -0,0 +1,199 @@
+// Package safemode tracks failed startups and plugin injection errors, so
+// Crankshaft can start without injecting plugins after a plugin keeps
+// breaking Steam.
+package safemode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+)
+
+// Number of consecutive failed startups or plugin errors after which
+// Crankshaft starts in safe mode
+const FailureThreshold = 3
+
+// Status is the safe mode state. Everything but Forced is persisted.
+type Status struct {
+	// Active is true if plugins aren't being injected
+	Active bool `json:"active"`
+	// Forced is true if safe mode was turned on with the -safe-mode flag, it
+	// isn't persisted
+	Forced bool `json:"forced"`
+	// Consecutive startups where injecting plugins never finished
+	FailedStartups int `json:"failedStartups"`
+	// Consecutive errors injecting or loading plugins
+	PluginErrors int `json:"pluginErrors"`
+	// Plugin that was injected last, which is likely to be the one that
+	// caused the failures
+	LastPlugin string `json:"lastPlugin,omitempty"`
+	// Last error injecting or loading a plugin
+	LastError string `json:"lastError,omitempty"`
+	// Injecting is set to true while plugins are being injected, if it's still
+	// set when Steam starts again the startup failed
+	Injecting bool `json:"injecting"`
+}
+
+// Tracker keeps the safe mode state in a file, so failures are counted across
+// restarts. It's safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	filePath string
+	status   Status
+	forced   bool
+	// Whether an error happened since Steam last started
+	errored bool
+}
+
+// Open loads the safe mode state from the file, counting the last startup as
+// failed if it never finished injecting plugins. If force is true, safe mode
+// is turned on for this run until it's exited.
+func Open(filePath string, force bool) (*Tracker, error) {
+	t := &Tracker{filePath: filePath, forced: force}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(`Error reading safe mode state at "%s": %v`, filePath, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.status); err != nil {
+			// A broken state file shouldn't stop Crankshaft from starting
+			log.Printf("Error decoding safe mode state at \"%s\", resetting it: %v\n", filePath, err)
+			t.status = Status{}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.steamStarted(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Active reports whether Crankshaft is in safe mode, so plugins shouldn't be
+// injected.
+func (t *Tracker) Active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status.Active || t.forced
+}
+
+// Status returns the current safe mode state.
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.Active = status.Active || t.forced
+	status.Forced = t.forced
+	return status
+}
+
+// SteamStarted counts the last startup as failed if it never finished
+// injecting plugins. It should be called every time Steam starts.
+func (t *Tracker) SteamStarted() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.steamStarted()
+}
+
+func (t *Tracker) steamStarted() error {
+	t.errored = false
+	if t.status.Injecting {
+		t.status.Injecting = false
+		t.status.FailedStartups++
+		log.Printf("Injecting plugins didn't finish last time Steam started, %d failed startups\n", t.status.FailedStartups)
+	}
+	t.checkThreshold()
+
+	return t.write()
+}
+
+// Injecting records that the plugin is about to be injected.
+func (t *Tracker) Injecting(pluginId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Injecting = true
+	t.status.LastPlugin = pluginId
+
+	return t.write()
+}
+
+// PluginFailed records an error injecting or loading the plugin.
+func (t *Tracker) PluginFailed(pluginId string, pluginErr error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.errored = true
+	t.status.PluginErrors++
+	t.status.LastPlugin = pluginId
+	t.status.LastError = pluginErr.Error()
+	t.checkThreshold()
+
+	return t.write()
+}
+
+// PluginsLoaded records that plugins finished loading, so the startup
+// succeeded. The plugin error count is only reset if no plugins failed since
+// Steam started.
+func (t *Tracker) PluginsLoaded() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Injecting = false
+	t.status.FailedStartups = 0
+	if !t.errored {
+		t.status.PluginErrors = 0
+		t.status.LastError = ""
+	}
+
+	return t.write()
+}
+
+// Exit turns off safe mode and resets the failure counts.
+func (t *Tracker) Exit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status = Status{LastPlugin: t.status.LastPlugin}
+	t.forced = false
+	t.errored = false
+
+	return t.write()
+}
+
+// checkThreshold turns on safe mode once there have been too many failures.
+// t.mu must be held by the caller.
+func (t *Tracker) checkThreshold() {
+	if t.status.Active {
+		return
+	}
+
+	if t.status.FailedStartups >= FailureThreshold || t.status.PluginErrors >= FailureThreshold {
+		log.Printf("Too many failures, starting safe mode. Last plugin injected was \"%s\"\n", t.status.LastPlugin)
+		t.status.Active = true
+	}
+}
+
+// t.mu must be held by the caller.
+func (t *Tracker) write() error {
+	data, err := json.Marshal(t.status)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(t.filePath, data, 0644); err != nil {
+		return fmt.Errorf(`Error writing safe mode state at "%s": %v`, t.filePath, err)
+	}
+
+	return nil
+}
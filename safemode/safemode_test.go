@@ -0,0 +1,89 @@
+package safemode
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailedStartupsActivateSafeMode(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "safe-mode.json")
+
+	// Each run starts injecting a plugin and never finishes
+	for i := 0; i < FailureThreshold; i++ {
+		tracker, err := Open(filePath, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracker.Active() {
+			t.Fatalf("Expected safe mode to be off after %d failed startups", i)
+		}
+		if err := tracker.Injecting("hangs-steam"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker, err := Open(filePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := tracker.Status()
+	if !status.Active || status.FailedStartups != FailureThreshold || status.LastPlugin != "hangs-steam" {
+		t.Fatalf("Expected safe mode to blame hangs-steam, got %+v", status)
+	}
+
+	// Safe mode stays on after a successful startup until it's exited
+	tracker.PluginsLoaded()
+	if tracker, _ = Open(filePath, false); !tracker.Active() {
+		t.Fatal("Expected safe mode to stay on until it's exited")
+	}
+
+	if err := tracker.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	if tracker, _ = Open(filePath, false); tracker.Active() {
+		t.Fatal("Expected safe mode to be off after exiting it")
+	}
+}
+
+func TestPluginErrorsActivateSafeMode(t *testing.T) {
+	tracker, err := Open(filepath.Join(t.TempDir(), "safe-mode.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A successful startup with no errors resets the count
+	tracker.PluginFailed("flaky", errors.New("flaky error"))
+	tracker.SteamStarted()
+	tracker.PluginsLoaded()
+	if status := tracker.Status(); status.PluginErrors != 0 {
+		t.Fatalf("Expected plugin errors to be reset, got %d", status.PluginErrors)
+	}
+
+	for i := 0; i < FailureThreshold; i++ {
+		tracker.SteamStarted()
+		tracker.PluginFailed("broken", errors.New("load threw"))
+		tracker.PluginsLoaded()
+	}
+
+	status := tracker.Status()
+	if !status.Active || status.LastPlugin != "broken" || status.LastError != "load threw" {
+		t.Fatalf("Expected safe mode to blame broken, got %+v", status)
+	}
+}
+
+func TestForcedSafeModeIsNotPersisted(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "safe-mode.json")
+
+	tracker, err := Open(filePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := tracker.Status(); !status.Active || !status.Forced {
+		t.Fatalf("Expected forced safe mode, got %+v", status)
+	}
+
+	if tracker, _ = Open(filePath, false); tracker.Active() {
+		t.Fatal("Expected forced safe mode to only last for one run")
+	}
+}
@@ -0,0 +1,172 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+type operator string
+
+const (
+	opEqual        operator = "="
+	opGreater      operator = ">"
+	opGreaterEqual operator = ">="
+	opLess         operator = "<"
+	opLessEqual    operator = "<="
+	// Compatible with, allows changes that don't modify the left-most
+	// non-zero version number
+	opCaret operator = "^"
+	// Approximately, allows patch changes
+	opTilde operator = "~"
+)
+
+// Operators ordered so that longer ones are matched first
+var operators = []operator{opGreaterEqual, opLessEqual, opGreater, opLess, opEqual, opCaret, opTilde}
+
+type comparator struct {
+	op      operator
+	version Version
+}
+
+func (c comparator) check(v Version) bool {
+	switch c.op {
+	case opEqual:
+		return v.Compare(c.version) == 0
+	case opGreater:
+		return v.Compare(c.version) > 0
+	case opGreaterEqual:
+		return v.Compare(c.version) >= 0
+	case opLess:
+		return v.Compare(c.version) < 0
+	case opLessEqual:
+		return v.Compare(c.version) <= 0
+	case opCaret:
+		if v.Compare(c.version) < 0 {
+			return false
+		}
+		switch {
+		case c.version.Major != 0:
+			return v.Major == c.version.Major
+		case c.version.Minor != 0:
+			return v.Major == 0 && v.Minor == c.version.Minor
+		}
+		return v.Major == 0 && v.Minor == 0 && v.Patch == c.version.Patch
+	case opTilde:
+		return v.Compare(c.version) >= 0 &&
+			v.Major == c.version.Major &&
+			v.Minor == c.version.Minor
+	}
+	return false
+}
+
+// Constraint is a set of version requirements, like ">=1.2.0, <2.0.0" or
+// "^1.2 || ^2.0". Comparators separated by commas or spaces must all match,
+// and at least one of the groups separated by "||" must match.
+type Constraint struct {
+	raw    string
+	groups [][]comparator
+}
+
+// ParseConstraint parses a version constraint. Supported operators are =, >,
+// >=, <, <=, ^ and ~. A version without an operator must match exactly, and
+// "*" or an empty constraint matches any version.
+func ParseConstraint(constraint string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(constraint)}
+
+	for _, group := range strings.Split(constraint, "||") {
+		comparators := []comparator{}
+
+		fields := strings.FieldsFunc(group, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if field == "*" {
+				continue
+			}
+
+			op := opEqual
+			for _, candidate := range operators {
+				if strings.HasPrefix(field, string(candidate)) {
+					op = candidate
+					field = strings.TrimPrefix(field, string(candidate))
+					break
+				}
+			}
+
+			// Allow a space between the operator and version, like ">= 1.0"
+			if field == "" && i+1 < len(fields) {
+				i++
+				field = fields[i]
+			}
+
+			version, err := Parse(field)
+			if err != nil {
+				return c, fmt.Errorf(`Invalid constraint "%s": %v`, constraint, err)
+			}
+
+			comparators = append(comparators, comparator{op, version})
+		}
+
+		c.groups = append(c.groups, comparators)
+	}
+
+	return c, nil
+}
+
+// Check returns if the version satisfies the constraint.
+func (c Constraint) Check(v Version) bool {
+	for _, group := range c.groups {
+		matches := true
+		for _, comparator := range group {
+			if !comparator.check(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return len(c.groups) == 0
+}
+
+// MinVersion returns the lowest version the constraint allows, if it has an
+// inclusive lower bound. It's false for constraints like "*" or ">1.0.0" that
+// don't.
+func (c Constraint) MinVersion() (Version, bool) {
+	var min Version
+	found := false
+
+	for _, group := range c.groups {
+		var groupMin Version
+		groupFound := false
+		for _, comparator := range group {
+			switch comparator.op {
+			case opEqual, opGreaterEqual, opCaret, opTilde:
+				if !groupFound || comparator.version.Compare(groupMin) > 0 {
+					groupMin = comparator.version
+					groupFound = true
+				}
+			case opGreater:
+				// There's no lowest version above an exclusive bound
+				return Version{}, false
+			}
+		}
+
+		// A group without a lower bound allows any lower version
+		if !groupFound {
+			return Version{}, false
+		}
+		if !found || groupMin.Compare(min) < 0 {
+			min = groupMin
+			found = true
+		}
+	}
+
+	return min, found
+}
+
+func (c Constraint) String() string {
+	return c.raw
+}
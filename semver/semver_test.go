@@ -0,0 +1,133 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := map[string]Version{
+		"1.2.3":             {Major: 1, Minor: 2, Patch: 3},
+		"v0.2.5":            {Major: 0, Minor: 2, Patch: 5},
+		"1.2":               {Major: 1, Minor: 2},
+		"2.0.0-beta.1":      {Major: 2, Prerelease: "beta.1"},
+		"1.0.0-rc.1+abc123": {Major: 1, Prerelease: "rc.1", Build: "abc123"},
+	}
+
+	for input, expected := range tests {
+		v, err := Parse(input)
+		if err != nil {
+			t.Fatalf(`Parse(%v) returned error: %v`, input, err)
+		}
+		if v != expected {
+			t.Fatalf(`Parse(%v) expected "%v", got "%v"`, input, expected, v)
+		}
+	}
+
+	for _, input := range []string{"", "1.2.3.4", "a.b.c", "1.0.0-", "-1.0.0"} {
+		if _, err := Parse(input); err == nil {
+			t.Fatalf(`Parse(%v) expected error, got nil`, input)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Each version is lower than the next
+	ordered := []string{
+		"0.9.0",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0",
+		"1.0.1",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		a, b := MustParse(ordered[i]), MustParse(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Fatalf(`Expected "%v" to be lower than "%v"`, a, b)
+		}
+	}
+
+	if MustParse("1.0.0+a").Compare(MustParse("1.0.0+b")) != 0 {
+		t.Fatal("Expected build metadata to be ignored")
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"", "1.0.0", true},
+		{"*", "1.0.0", true},
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{">=0.2.0", "0.2.5", true},
+		{">= 0.3.0", "0.2.5", false},
+		{">1.0.0, <2.0.0", "1.5.0", true},
+		{">1.0.0 <2.0.0", "2.0.0", false},
+		{"^1.2.0", "1.9.0", true},
+		{"^1.2.0", "2.0.0", false},
+		{"^1.2.0", "1.1.0", false},
+		{"^0.2.0", "0.2.9", true},
+		{"^0.2.0", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.0", "1.2.9", true},
+		{"~1.2.0", "1.3.0", false},
+		{"^1.0.0 || ^2.0.0", "2.1.0", true},
+		{"^1.0.0 || ^2.0.0", "3.0.0", false},
+	}
+
+	for _, test := range tests {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf(`ParseConstraint(%v) returned error: %v`, test.constraint, err)
+		}
+
+		res := c.Check(MustParse(test.version))
+		if res != test.expected {
+			t.Fatalf(`"%v".Check(%v) expected %v, got %v`, test.constraint, test.version, test.expected, res)
+		}
+	}
+
+	for _, constraint := range []string{">=", "^abc", "1.0.0 || >x"} {
+		if _, err := ParseConstraint(constraint); err == nil {
+			t.Fatalf(`ParseConstraint(%v) expected error, got nil`, constraint)
+		}
+	}
+}
+
+func TestConstraintMinVersion(t *testing.T) {
+	tests := map[string]string{
+		">=1.2.0":           "1.2.0",
+		"^0.2.1":            "0.2.1",
+		">=1.0.0 <2.0.0":    "1.0.0",
+		"~1.4.0 || >=1.2.0": "1.2.0",
+		"*":                 "",
+		"<2.0.0":            "",
+		">1.0.0":            "",
+		"^1.0.0 || <0.5.0":  "",
+		">=1.0.0, >=1.1.0":  "1.1.0",
+	}
+
+	for constraint, expected := range tests {
+		c, err := ParseConstraint(constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) returned error: %v", constraint, err)
+		}
+
+		min, ok := c.MinVersion()
+		if expected == "" {
+			if ok {
+				t.Fatalf("Expected %q to have no minimum version, got %v", constraint, min)
+			}
+			continue
+		}
+		if !ok || min.String() != expected {
+			t.Fatalf("Expected %q to have minimum version %v, got %v", constraint, expected, min)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package store implements the key-value storage plugins use to persist data.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	pluginsBucket = []byte("plugins")
+	sharedBucket  = []byte("shared")
+)
+
+// Namespace is a set of keys that are stored separately from other
+// namespaces. Each plugin has its own namespace, and there's a single shared
+// namespace all plugins with the shared store permission can access.
+type Namespace struct {
+	// PluginId is empty for the shared namespace
+	PluginId string
+}
+
+// SharedNamespace is the namespace shared between plugins.
+var SharedNamespace = Namespace{}
+
+// PluginNamespace returns the namespace for the plugin's own data.
+func PluginNamespace(pluginId string) Namespace {
+	return Namespace{PluginId: pluginId}
+}
+
+// IsShared returns if this is the namespace shared between plugins.
+func (n Namespace) IsShared() bool {
+	return n.PluginId == ""
+}
+
+func (n Namespace) String() string {
+	if n.IsShared() {
+		return "shared"
+	}
+	return `plugin "` + n.PluginId + `"`
+}
+
+// bucket returns the namespace's bucket, or nil if nothing has been stored in
+// it yet.
+func (n Namespace) bucket(tx *bolt.Tx) *bolt.Bucket {
+	if n.IsShared() {
+		return tx.Bucket(sharedBucket)
+	}
+
+	plugins := tx.Bucket(pluginsBucket)
+	if plugins == nil {
+		return nil
+	}
+	return plugins.Bucket([]byte(n.PluginId))
+}
+
+func (n Namespace) createBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if n.IsShared() {
+		return tx.CreateBucketIfNotExists(sharedBucket)
+	}
+
+	plugins, err := tx.CreateBucketIfNotExists(pluginsBucket)
+	if err != nil {
+		return nil, err
+	}
+	return plugins.CreateBucketIfNotExists([]byte(n.PluginId))
+}
+
+// QuotaError is returned when storing a value would make a namespace larger
+// than its quota.
+type QuotaError struct {
+	Namespace Namespace
+	Quota     int64
+	Size      int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Storing value would make %s store %d bytes, over its quota of %d bytes", e.Namespace, e.Size, e.Quota)
+}
+
+// Store is a key-value store backed by a bolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store database at dbPath, creating it if it doesn't exist.
+func Open(dbPath string) (*Store, error) {
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`Error opening store database "%s": %v`, dbPath, err)
+	}
+
+	store := &Store{db}
+	if err := store.migrateLegacyBuckets(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// migrateLegacyBuckets moves buckets from before stores were namespaced into
+// plugin namespaces. Previously callers could use any top-level bucket, and
+// plugins used their ID as the bucket name.
+func (s *Store) migrateLegacyBuckets() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var legacy [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if string(name) != string(pluginsBucket) && string(name) != string(sharedBucket) {
+				legacy = append(legacy, append([]byte(nil), name...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, name := range legacy {
+			log.Printf("Moving store bucket \"%s\" into its plugin namespace\n", name)
+
+			dest, err := PluginNamespace(string(name)).createBucket(tx)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				if v == nil {
+					// Nested buckets were never created by StoreService
+					return nil
+				}
+				return dest.Put(k, v)
+			}); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Close closes the store database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// How long to wait for another process to unlock the store database
+const lockTimeout = time.Second
+
+// ErrInUse is returned when the store database is open in a running
+// Crankshaft.
+var ErrInUse = errors.New("Store database is in use, stop Crankshaft first")
+
+// Snapshot writes a consistent copy of the store database at dbPath to
+// outPath. It returns ErrInUse if the database is open in a running
+// Crankshaft.
+func Snapshot(dbPath, outPath string) error {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return ErrInUse
+	}
+	if err != nil {
+		return fmt.Errorf(`Error opening store database "%s": %v`, dbPath, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(outPath, 0600)
+	})
+}
+
+// EnsureNotInUse returns ErrInUse if the store database at dbPath is open in a
+// running Crankshaft, so it can be replaced.
+func EnsureNotInUse(dbPath string) error {
+	if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return ErrInUse
+	}
+	if err != nil {
+		return fmt.Errorf(`Error opening store database "%s": %v`, dbPath, err)
+	}
+
+	return db.Close()
+}
+
+// Get returns the value of key in the namespace, and if it was found.
+func (s *Store) Get(namespace Namespace, key string) (value string, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := namespace.bucket(tx)
+		if b == nil {
+			return nil
+		}
+
+		val := b.Get([]byte(key))
+		if val == nil {
+			return nil
+		}
+
+		value = string(val)
+		found = true
+		return nil
+	})
+	return value, found, err
+}
+
+// Set stores value for key in the namespace. If this would make the
+// namespace's size larger than quota bytes, nothing is stored and a QuotaError
+// is returned. A quota of 0 or less means there's no limit.
+func (s *Store) Set(namespace Namespace, key, value string, quota int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := namespace.createBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if quota > 0 {
+			size := bucketSize(b) + int64(len(key)+len(value))
+			if existing := b.Get([]byte(key)); existing != nil {
+				size -= int64(len(key) + len(existing))
+			}
+			if size > quota {
+				return &QuotaError{namespace, quota, size}
+			}
+		}
+
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+// Delete removes key from the namespace.
+func (s *Store) Delete(namespace Namespace, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := namespace.bucket(tx)
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// Size returns the number of bytes stored in the namespace, counting both keys
+// and values.
+func (s *Store) Size(namespace Namespace) (size int64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if b := namespace.bucket(tx); b != nil {
+			size = bucketSize(b)
+		}
+		return nil
+	})
+	return size, err
+}
+
+// DeletePluginData removes everything stored in the plugin's namespace.
+func (s *Store) DeletePluginData(pluginId string) error {
+	if pluginId == "" {
+		return errors.New("Plugin ID is required to delete plugin data")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		plugins := tx.Bucket(pluginsBucket)
+		if plugins == nil || plugins.Bucket([]byte(pluginId)) == nil {
+			return nil
+		}
+		return plugins.DeleteBucket([]byte(pluginId))
+	})
+}
+
+func bucketSize(b *bolt.Bucket) int64 {
+	var size int64
+	b.ForEach(func(k, v []byte) error {
+		size += int64(len(k) + len(v))
+		return nil
+	})
+	return size
+}
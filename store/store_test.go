@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func openTestStore(t *testing.T, dbPath string) *Store {
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestNamespacesAreSeparate(t *testing.T) {
+	store := openTestStore(t, path.Join(t.TempDir(), "store.db"))
+
+	namespaces := []Namespace{PluginNamespace("a"), PluginNamespace("b"), SharedNamespace}
+	for _, ns := range namespaces {
+		if err := store.Set(ns, "key", ns.String(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ns := range namespaces {
+		value, found, err := store.Get(ns, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || value != ns.String() {
+			t.Fatalf("Expected %s to have its own value, got %q", ns, value)
+		}
+	}
+}
+
+func TestSetQuota(t *testing.T) {
+	store := openTestStore(t, path.Join(t.TempDir(), "store.db"))
+	ns := PluginNamespace("a")
+
+	// 3 + 5 bytes
+	if err := store.Set(ns, "key", "12345", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing a value only counts the difference
+	if err := store.Set(ns, "key", "1234567", 10); err != nil {
+		t.Fatalf("Expected replacing value within quota to succeed, got %v", err)
+	}
+
+	var quotaErr *QuotaError
+	if err := store.Set(ns, "other", "1", 10); !errors.As(err, &quotaErr) {
+		t.Fatalf("Expected QuotaError, got %v", err)
+	}
+	if quotaErr.Size != 16 {
+		t.Fatalf("Expected size 16 in QuotaError, got %d", quotaErr.Size)
+	}
+
+	if size, _ := store.Size(ns); size != 10 {
+		t.Fatalf("Expected size to be unchanged after exceeding quota, got %d", size)
+	}
+}
+
+func TestDeletePluginData(t *testing.T) {
+	store := openTestStore(t, path.Join(t.TempDir(), "store.db"))
+
+	store.Set(PluginNamespace("a"), "key", "value", 0)
+	store.Set(PluginNamespace("b"), "key", "value", 0)
+
+	if err := store.DeletePluginData("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, _ := store.Get(PluginNamespace("a"), "key"); found {
+		t.Fatal("Expected deleted plugin data to be gone")
+	}
+	if _, found, _ := store.Get(PluginNamespace("b"), "key"); !found {
+		t.Fatal("Expected other plugin's data to be kept")
+	}
+}
+
+func TestMigrateLegacyBuckets(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "store.db")
+
+	// Write a bucket the way StoreService did before namespaces
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("old-plugin"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("key"), []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store := openTestStore(t, dbPath)
+
+	value, found, err := store.Get(PluginNamespace("old-plugin"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || value != "value" {
+		t.Fatalf("Expected legacy bucket to be moved into plugin namespace, got %q", value)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := path.Join(dir, "store.db")
+	snapshotPath := path.Join(dir, "snapshot.db")
+
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(PluginNamespace("a"), "key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is locked while it's open
+	if err := Snapshot(dbPath, snapshotPath); !errors.Is(err, ErrInUse) {
+		t.Fatalf("Snapshot expected ErrInUse, got %v", err)
+	}
+	if err := EnsureNotInUse(dbPath); !errors.Is(err, ErrInUse) {
+		t.Fatalf("EnsureNotInUse expected ErrInUse, got %v", err)
+	}
+
+	store.Close()
+	if err := Snapshot(dbPath, snapshotPath); err != nil {
+		t.Fatalf("Snapshot returned error: %v", err)
+	}
+
+	snapshot := openTestStore(t, snapshotPath)
+	if value, found, err := snapshot.Get(PluginNamespace("a"), "key"); err != nil || !found || value != "value" {
+		t.Fatalf(`Expected snapshot to have "value", got %q, %v, %v`, value, found, err)
+	}
+}
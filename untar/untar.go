@@ -10,26 +10,53 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Untar takes an archive path, and destination path, and processes the specified
-// archive, extracting all files and creating the structure along the way
+// archive, extracting all files and creating the structure along the way.
+// Entries that would be written outside of dest, either through their path or
+// through a symlink, are refused.
 func Untar(archive string, dest string) error {
 	return untar(archive, dest)
 }
 
-func untar(archive string, dest string) (err error) {
-	now := time.Now()
-	madeDirs := []*tar.Header{}
-
-	// Attempt to open the file on disk
+// Names returns the names of the entries in the archive, without extracting
+// it.
+func Names(archive string) ([]string, error) {
 	file, err := os.Open(archive)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
+	tarReader, err := newTarReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+			continue
+		}
+		names = append(names, header.Name)
+	}
+
+	return names, nil
+}
+
+// newTarReader reads the file as a tar archive, decompressing it first if it's
+// gzipped.
+func newTarReader(file *os.File) (*tar.Reader, error) {
 	// Attempt to open this as a gzip, if we can, set reader to the gzip reader
 	// otherwise just fall through to see if it's a tar file
 	var reader io.Reader = file
@@ -38,10 +65,64 @@ func untar(archive string, dest string) (err error) {
 		reader = gzipReader
 	} else {
 		// If it wasn't a gzip, reset our offset to the beginning of the file
-		file.Seek(0, 0)
+		if _, err := file.Seek(0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return tar.NewReader(reader), nil
+}
+
+// isInside returns if path is dest or inside of it. Both paths must be clean.
+func isInside(dest, path string) bool {
+	rel, err := filepath.Rel(dest, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkParents returns an error if the closest existing parent of path
+// resolves to a directory outside of realDest, which happens when a symlink
+// extracted earlier points outside of it.
+func checkParents(realDest, path string) error {
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !isInside(realDest, realDir) {
+		return fmt.Errorf("tar file entry %s would be extracted outside of the destination through a symlink", path)
+	}
+	return nil
+}
+
+func untar(archive string, dest string) (err error) {
+	now := time.Now()
+	madeDirs := []*tar.Header{}
+
+	// Attempt to open the file on disk
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tarReader, err := newTarReader(file)
+	if err != nil {
+		return err
 	}
 
-	tarReader := tar.NewReader(reader)
+	dest = filepath.Clean(dest)
+	// Where dest really is, to check that symlinks stay inside of it
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
 
 	for {
 		header, err := tarReader.Next()
@@ -61,9 +142,15 @@ func untar(archive string, dest string) (err error) {
 			continue
 		}
 
-		// Build the output path
+		// Build the output path, which has to stay inside of dest
 		relativePath := filepath.FromSlash(header.Name)
 		absolutePath := filepath.Join(dest, relativePath)
+		if !isInside(dest, absolutePath) {
+			return fmt.Errorf("tar file entry %s would be extracted outside of the destination", header.Name)
+		}
+		if err := checkParents(realDest, absolutePath); err != nil {
+			return err
+		}
 
 		// Get the file info and mode
 		fileInfo := header.FileInfo()
@@ -83,9 +170,26 @@ func untar(archive string, dest string) (err error) {
 
 		// Handle symlinks
 		case header.Typeflag == tar.TypeSymlink:
-			// Note: I'm not currently restricting this to the extraction dir
-			// but we could pull in SecureJoin if we wanted to do that
-			linkTarget := header.Linkname
+			// The target has to stay inside of dest. ".." is only allowed at
+			// the start of the target, where it's resolved from the link's real
+			// directory. After another symlink it would be resolved from
+			// wherever that symlink points.
+			linkTarget := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(linkTarget) || !dotDotOnlyAtStart(linkTarget) {
+				return fmt.Errorf("tar file entry %s is a symlink to %s, which isn't allowed", header.Name, header.Linkname)
+			}
+
+			targetDir := filepath.Dir(absolutePath)
+			if err := os.MkdirAll(targetDir, 0755); err != nil {
+				return err
+			}
+			realTargetDir, err := filepath.EvalSymlinks(targetDir)
+			if err != nil {
+				return err
+			}
+			if !isInside(realDest, filepath.Join(realTargetDir, linkTarget)) {
+				return fmt.Errorf("tar file entry %s is a symlink to %s, outside of the destination", header.Name, header.Linkname)
+			}
 
 			// Create the symlink
 			if err := os.Symlink(linkTarget, absolutePath); err != nil {
@@ -105,6 +209,11 @@ func untar(archive string, dest string) (err error) {
 				}
 			}
 
+			// Don't write through a symlink extracted earlier
+			if fi, err := os.Lstat(absolutePath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("tar file entry %s would overwrite a symlink", header.Name)
+			}
+
 			// Create the file, will truncate if it exists
 			file, err := os.Create(absolutePath)
 			if err != nil {
@@ -148,7 +257,7 @@ func untar(archive string, dest string) (err error) {
 	// Now that we're done, all our directories have been created, so
 	// we can set their attributes
 	for _, dirHeader := range madeDirs {
-		targetDir := filepath.Join(dest, dirHeader.Name)
+		targetDir := filepath.Join(dest, filepath.FromSlash(dirHeader.Name))
 
 		// Chmod the directory
 		if err := os.Chmod(targetDir, dirHeader.FileInfo().Mode()); err != nil {
@@ -168,3 +277,21 @@ func untar(archive string, dest string) (err error) {
 
 	return nil
 }
+
+// dotDotOnlyAtStart returns if every ".." element of the path comes before
+// its other elements.
+func dotDotOnlyAtStart(path string) bool {
+	pastStart := false
+	for _, element := range strings.Split(path, string(filepath.Separator)) {
+		switch element {
+		case "..":
+			if pastStart {
+				return false
+			}
+		case "", ".":
+		default:
+			pastStart = true
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package untar
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	linkname string
+	contents string
+}
+
+// writeTestArchive writes an uncompressed tar archive with the given entries.
+// Entries with a linkname are symlinks.
+func writeTestArchive(t *testing.T, entries []testEntry) string {
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tarWriter := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.contents))}
+		if entry.linkname != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.linkname
+			header.Size = 0
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if entry.linkname == "" {
+			if _, err := tarWriter.Write([]byte(entry.contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+func TestUntar(t *testing.T) {
+	archivePath := writeTestArchive(t, []testEntry{
+		{name: "plugin/node_modules/pkg/cli.js", contents: "cli"},
+		{name: "plugin/node_modules/.bin/cli", linkname: "../pkg/cli.js"},
+	})
+
+	dest := t.TempDir()
+	if err := Untar(archivePath, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dest, "plugin", "node_modules", ".bin", "cli"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "cli" {
+		t.Fatalf(`Expected symlinked file to contain "cli", got "%s"`, data)
+	}
+}
+
+func TestUntarRefusesEscapes(t *testing.T) {
+	tests := map[string][]testEntry{
+		"path traversal": {
+			{name: "../evil", contents: "evil"},
+		},
+		"absolute symlink": {
+			{name: "link", linkname: "/tmp"},
+		},
+		"symlink out of dest": {
+			{name: "dir/link", linkname: "../../evil"},
+		},
+		"dot dot after symlink": {
+			{name: "sub/up", linkname: ".."},
+			{name: "sub/link", linkname: "up/../evil"},
+		},
+		"overwrite symlink": {
+			{name: "target", contents: "target"},
+			{name: "link", linkname: "target"},
+			{name: "link", contents: "evil"},
+		},
+	}
+
+	for name, entries := range tests {
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "dest")
+		if err := os.Mkdir(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Untar(writeTestArchive(t, entries), dest); err == nil {
+			t.Fatalf("%s: expected extracting to fail", name)
+		}
+		if _, err := os.Stat(filepath.Join(parent, "evil")); err == nil {
+			t.Fatalf("%s: file was written outside of the destination", name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package watcher implements watching directories for file changes.
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer coalesces bursts of calls for the same key into a single call
+// that runs once no new calls have been made for the debounce duration.
+type Debouncer struct {
+	mu       sync.Mutex
+	duration time.Duration
+	timers   map[string]*time.Timer
+}
+
+func NewDebouncer(duration time.Duration) *Debouncer {
+	return &Debouncer{
+		duration: duration,
+		timers:   make(map[string]*time.Timer),
+	}
+}
+
+// Call schedules f to run after the debounce duration, replacing any call
+// that's still pending for the same key.
+func (d *Debouncer) Call(key string, f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[key]; ok {
+		timer.Stop()
+	}
+
+	d.timers[key] = time.AfterFunc(d.duration, func() {
+		d.mu.Lock()
+		delete(d.timers, key)
+		d.mu.Unlock()
+
+		f()
+	})
+}
+
+// Stop cancels all pending calls.
+func (d *Debouncer) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, timer := range d.timers {
+		timer.Stop()
+		delete(d.timers, key)
+	}
+}
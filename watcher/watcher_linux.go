@@ -0,0 +1,147 @@
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Events that count as a file changing. Editors often save by writing a new
+// file and moving it over the old one, so moves are included.
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+
+// Watcher watches directories with inotify and sends the path of every file
+// that changes in them to Events.
+type Watcher struct {
+	Events chan string
+
+	file *os.File
+
+	mu sync.Mutex
+	// Watched directories by watch descriptor
+	dirs map[int]string
+	// Watch descriptors by directory
+	wds map[string]int
+}
+
+// New creates a watcher and starts reading events.
+func New() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing inotify: %v", err)
+	}
+
+	w := &Watcher{
+		Events: make(chan string, 64),
+		// Using a non-blocking fd with os.File lets the runtime poller handle
+		// reads, so Close interrupts a pending read
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+		wds:  make(map[string]int),
+	}
+
+	go w.readEvents()
+
+	return w, nil
+}
+
+// Add starts watching the directory. Subdirectories aren't watched.
+func (w *Watcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.wds[dir]; ok {
+		return nil
+	}
+
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dir, watchMask)
+	if err != nil {
+		return fmt.Errorf(`Error watching "%s": %v`, dir, err)
+	}
+
+	w.dirs[wd] = dir
+	w.wds[dir] = wd
+
+	return nil
+}
+
+// Remove stops watching the directory.
+func (w *Watcher) Remove(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wd, ok := w.wds[dir]
+	if !ok {
+		return nil
+	}
+
+	delete(w.dirs, wd)
+	delete(w.wds, dir)
+
+	if _, err := unix.InotifyRmWatch(int(w.file.Fd()), uint32(wd)); err != nil {
+		return fmt.Errorf(`Error removing watch for "%s": %v`, dir, err)
+	}
+
+	return nil
+}
+
+// Close stops the watcher and closes the Events channel.
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.Events)
+
+	var buf [unix.SizeofInotifyEvent * 4096]byte
+
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, io.EOF) {
+				log.Printf("Error reading inotify events: %v\n", err)
+			}
+			return
+		}
+
+		offset := 0
+		for offset+unix.SizeofInotifyEvent <= n {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+
+			if event.Mask&unix.IN_IGNORED != 0 {
+				// The watched directory was removed
+				w.mu.Lock()
+				if dir, ok := w.dirs[int(event.Wd)]; ok {
+					delete(w.dirs, int(event.Wd))
+					delete(w.wds, dir)
+				}
+				w.mu.Unlock()
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[int(event.Wd)]
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			w.Events <- filepath.Join(dir, name)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package watcher
+
+import "errors"
+
+// Watcher watches directories and sends the path of every file that changes
+// in them to Events.
+//
+// Watching is only implemented on Linux for now.
+type Watcher struct {
+	Events chan string
+}
+
+func New() (*Watcher, error) {
+	return nil, errors.New("Watching files is only supported on Linux")
+}
+
+func (w *Watcher) Add(dir string) error {
+	return nil
+}
+
+func (w *Watcher) Remove(dir string) error {
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}